@@ -7,6 +7,7 @@ import (
 	"strings"
 	"text/tabwriter"
 	"time"
+	"unicode"
 
 	"github.com/erikh/go-transport"
 	"github.com/erikh/ldhcpd/proto"
@@ -53,6 +54,12 @@ func main() {
 			ArgsUsage: "[mac address]",
 			Usage:     "Get a lease based on the mac address provided",
 			Action:    get,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "client-id, i",
+					Usage: "Get the lease by client identifier instead; no mac address is given",
+				},
+			},
 		},
 		{
 			Name:      "set",
@@ -63,9 +70,27 @@ Examples:
 
 	ldhcpctl set 00:00:00:00:00:00 1.2.3.4 8m # expires in 8 minutes
 	ldhcpctl set 00:00:00:00:00:00 1.2.3.4 persistent # renews until deleted
+	ldhcpctl set -i 01:00:00:00:00:00:00 1.2.3.4 persistent # by client identifier; the mac address may be omitted
+	ldhcpctl set -n 10.0.0.5 -b pxelinux.0 00:00:00:00:00:00 1.2.3.4 persistent # boots from its own file
 			`,
 			Action: set,
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "client-id, i",
+					Usage: "Client identifier (option 61) of the lease, as colon separated hex bytes",
+				},
+				cli.StringFlag{
+					Name:  "next-server, n",
+					Usage: "Server the host loads its boot file from, overriding the configured one",
+				},
+				cli.StringFlag{
+					Name:  "boot-file, b",
+					Usage: "Boot file of the host, overriding the configured ones",
+				},
+				cli.StringFlag{
+					Name:  "ipxe-boot-file",
+					Usage: "Boot file of the host once it is running iPXE, overriding the configured one",
+				},
 				cli.DurationFlag{
 					Name:  "grace-period, gp",
 					Usage: "Grace period between lease expiration and hard reclaim time",
@@ -78,12 +103,48 @@ Examples:
 			ArgsUsage: "",
 			Usage:     "List all leases in the table",
 			Action:    list,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "hostname, H",
+					Usage: "List only the leases with a hostname matching this glob, without regard to case",
+				},
+			},
 		},
 		{
 			Name:      "remove",
 			ArgsUsage: "[mac address]",
 			Usage:     "Remove a lease by mac address",
 			Action:    remove,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "client-id, i",
+					Usage: "Remove the lease by client identifier instead; no mac address is given",
+				},
+			},
+		},
+		{
+			Name:  "quarantine",
+			Usage: "Manage addresses quarantined after a client declined them",
+			Subcommands: []cli.Command{
+				{
+					Name:      "list",
+					ArgsUsage: "",
+					Usage:     "List all quarantined addresses",
+					Action:    listQuarantine,
+				},
+				{
+					Name:      "clear",
+					ArgsUsage: "[ip address]",
+					Usage:     "Clear the quarantine on an address",
+					Action:    clearQuarantine,
+				},
+			},
+		},
+		{
+			Name:      "stats",
+			ArgsUsage: "",
+			Usage:     "Show lease counts and where allocation is in each pool",
+			Action:    stats,
 		},
 	}
 
@@ -110,15 +171,16 @@ func getClient(ctx *cli.Context) (proto.LeaseControlClient, error) {
 func listLeases(leases []*proto.Lease) {
 	/// func NewWriter(output io.Writer, minwidth, tabwidth, padding int, padchar byte, flags uint) *Writer {
 	w := tabwriter.NewWriter(os.Stdout, 8, 2, 2, ' ', 0)
-	w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\n", "MAC", "IP", "Dynamic", "Persistent", "Lease End", "Grace Period End")))
+	w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "MAC", "IP", "Hostname", "Client ID", "Dynamic", "Persistent", "Offered", "Lease End", "Grace Period End")))
 	for _, lease := range leases {
-		w.Write([]byte(fmt.Sprintf("%s\t%s\t%v\t%v\t%s\t%s\n", lease.MACAddress, lease.IPAddress, lease.Dynamic, lease.Persistent, time.Unix(lease.LeaseEnd.Seconds, 0), time.Unix(lease.LeaseGraceEnd.Seconds, 0))))
+		w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\t%v\t%v\t%v\t%s\t%s\n", lease.MACAddress, lease.IPAddress, lease.Hostname, lease.ClientID, lease.Dynamic, lease.Persistent, lease.Offered, time.Unix(lease.LeaseEnd.Seconds, 0), time.Unix(lease.LeaseGraceEnd.Seconds, 0))))
 	}
 	w.Flush()
 }
 
 func get(ctx *cli.Context) error {
-	if len(ctx.Args()) != 1 {
+	clientID := ctx.String("client-id")
+	if (clientID == "" && len(ctx.Args()) != 1) || (clientID != "" && len(ctx.Args()) != 0) {
 		return errors.New("invalid arguments")
 	}
 
@@ -127,17 +189,83 @@ func get(ctx *cli.Context) error {
 		return err
 	}
 
-	lease, err := client.GetLease(context.Background(), &proto.MACAddress{Address: ctx.Args()[0]})
-	if err != nil {
-		return errors.Wrapf(err, "while obtaining lease for %v", ctx.Args()[0])
+	var lease *proto.Lease
+	if clientID != "" {
+		lease, err = client.GetLeaseByClientID(context.Background(), &proto.ClientID{ID: clientID})
+		if err != nil {
+			return errors.Wrapf(err, "while obtaining lease for client id %v", clientID)
+		}
+	} else {
+		lease, err = client.GetLease(context.Background(), &proto.MACAddress{Address: ctx.Args()[0]})
+		if err != nil {
+			return errors.Wrapf(err, "while obtaining lease for %v", ctx.Args()[0])
+		}
 	}
 
 	listLeases([]*proto.Lease{lease})
+
+	for _, ci := range []struct {
+		name  string
+		value string
+	}{
+		{"FQDN", lease.FQDN},
+		{"Vendor Class", lease.VendorClass},
+	} {
+		if ci.value != "" {
+			fmt.Printf("%s: %s\n", ci.name, ci.value)
+		}
+	}
+
+	for _, ri := range []struct {
+		name  string
+		value []byte
+	}{
+		{"Circuit ID", lease.CircuitID},
+		{"Remote ID", lease.RemoteID},
+		{"Subscriber ID", lease.SubscriberID},
+	} {
+		if len(ri.value) != 0 {
+			fmt.Printf("%s: %s\n", ri.name, relayID(ri.value))
+		}
+	}
+
+	for _, boot := range []struct {
+		name  string
+		value string
+	}{
+		{"Next Server", lease.NextServer},
+		{"Boot File", lease.BootFile},
+		{"iPXE Boot File", lease.IPXEBootFile},
+	} {
+		if boot.value != "" {
+			fmt.Printf("%s: %s\n", boot.name, boot.value)
+		}
+	}
+
 	return nil
 }
 
+// relayID formats relay agent information, which is frequently binary.
+func relayID(id []byte) string {
+	for _, b := range id {
+		if !unicode.IsPrint(rune(b)) || b > unicode.MaxASCII {
+			return fmt.Sprintf("0x%x", id)
+		}
+	}
+
+	return string(id)
+}
+
 func set(ctx *cli.Context) error {
-	if len(ctx.Args()) != 3 {
+	args := ctx.Args()
+	clientID := ctx.String("client-id")
+
+	switch {
+	case len(args) == 3:
+	case len(args) == 2 && clientID != "":
+		// leased by client id alone
+		args = append([]string{""}, args...)
+	default:
 		return errors.New("invalid arguments")
 	}
 
@@ -151,22 +279,26 @@ func set(ctx *cli.Context) error {
 		persistent bool
 	)
 
-	leaseDuration := strings.TrimSpace(ctx.Args()[2])
+	leaseDuration := strings.TrimSpace(args[2])
 	switch {
 	case strings.HasPrefix(leaseDuration, "persist"):
 		leaseEnd = time.Hour
 		persistent = true
 	default:
 		var err error
-		leaseEnd, err = time.ParseDuration(ctx.Args()[2])
+		leaseEnd, err = time.ParseDuration(args[2])
 		if err != nil {
 			return errors.Wrap(err, "while parsing lease end duration")
 		}
 	}
 
 	_, err = client.SetLease(context.Background(), &proto.Lease{
-		MACAddress:    ctx.Args()[0],
-		IPAddress:     ctx.Args()[1],
+		MACAddress:    args[0],
+		IPAddress:     args[1],
+		ClientID:      clientID,
+		NextServer:    ctx.String("next-server"),
+		BootFile:      ctx.String("boot-file"),
+		IPXEBootFile:  ctx.String("ipxe-boot-file"),
 		Persistent:    persistent,
 		LeaseEnd:      &timestamp.Timestamp{Seconds: time.Now().Add(leaseEnd).Unix()},
 		LeaseGraceEnd: &timestamp.Timestamp{Seconds: time.Now().Add(leaseEnd).Add(ctx.Duration("grace-period")).Unix()},
@@ -188,7 +320,7 @@ func list(ctx *cli.Context) error {
 		return err
 	}
 
-	leases, err := client.ListLeases(context.Background(), &empty.Empty{})
+	leases, err := client.ListLeases(context.Background(), &proto.LeaseFilter{Hostname: ctx.String("hostname")})
 	if err != nil {
 		return errors.Wrap(err, "could not list leases")
 	}
@@ -199,7 +331,8 @@ func list(ctx *cli.Context) error {
 }
 
 func remove(ctx *cli.Context) error {
-	if len(ctx.Args()) != 1 {
+	clientID := ctx.String("client-id")
+	if (clientID == "" && len(ctx.Args()) != 1) || (clientID != "" && len(ctx.Args()) != 0) {
 		return errors.New("invalid arguments")
 	}
 
@@ -208,6 +341,15 @@ func remove(ctx *cli.Context) error {
 		return err
 	}
 
+	if clientID != "" {
+		if _, err := client.RemoveLeaseByClientID(context.Background(), &proto.ClientID{ID: clientID}); err != nil {
+			return err
+		}
+
+		fmt.Printf("Deleted %s\n", clientID)
+		return nil
+	}
+
 	_, err = client.RemoveLease(context.Background(), &proto.MACAddress{Address: ctx.Args()[0]})
 	if err != nil {
 		return err
@@ -216,3 +358,87 @@ func remove(ctx *cli.Context) error {
 	fmt.Printf("Deleted %s\n", ctx.Args()[0])
 	return nil
 }
+
+func listQuarantine(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return errors.New("invalid arguments")
+	}
+
+	client, err := getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	quarantine, err := client.ListQuarantine(context.Background(), &empty.Empty{})
+	if err != nil {
+		return errors.Wrap(err, "could not list quarantined addresses")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 2, 2, ' ', 0)
+	w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\n", "IP", "Declined By", "Until")))
+	for _, q := range quarantine.List {
+		w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\n", q.IPAddress, q.MACAddress, time.Unix(q.Until.Seconds, 0))))
+	}
+	w.Flush()
+
+	return nil
+}
+
+func clearQuarantine(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	client, err := getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RemoveQuarantine(context.Background(), &proto.IPAddress{Address: ctx.Args()[0]})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cleared quarantine on %s\n", ctx.Args()[0])
+	return nil
+}
+
+func stats(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return errors.New("invalid arguments")
+	}
+
+	client, err := getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	stats, err := client.GetStats(context.Background(), &empty.Empty{})
+	if err != nil {
+		return errors.Wrap(err, "could not get stats")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 2, 2, ' ', 0)
+	w.Write([]byte(fmt.Sprintf("Leases:\t%d\n", stats.Leases)))
+	w.Write([]byte(fmt.Sprintf("Offered:\t%d\n", stats.Offered)))
+	w.Write([]byte(fmt.Sprintf("Persistent:\t%d\n", stats.Persistent)))
+	w.Write([]byte(fmt.Sprintf("Configured:\t%d\n", stats.Configured)))
+	w.Write([]byte(fmt.Sprintf("Quarantined:\t%d\n", stats.Quarantined)))
+	w.Write([]byte(fmt.Sprintf("History:\t%d\n", stats.History)))
+	w.Flush()
+
+	if len(stats.Cursors) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+
+	w = tabwriter.NewWriter(os.Stdout, 8, 2, 2, ' ', 0)
+	w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\n", "Pool", "Strategy", "Last Allocated", "At")))
+	for _, c := range stats.Cursors {
+		w.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\n", c.Pool, c.Strategy, c.IPAddress, time.Unix(c.Updated.Seconds, 0))))
+	}
+	w.Flush()
+
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/erikh/ldhcpd/proto"
 	"github.com/erikh/ldhcpd/version"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -36,6 +37,10 @@ func main() {
 			Usage: "Change the host:port to listen for GRPC connections",
 			Value: "localhost:7846",
 		},
+		cli.StringFlag{
+			Name:  "dns, n",
+			Usage: "host:port to answer DNS queries for the search domains on; off if empty",
+		},
 	}
 
 	app.Action = serve
@@ -46,23 +51,63 @@ func main() {
 	}
 }
 
-func installSignalHandler(appName string, grpcS *grpc.Server, l net.Listener, handler *dhcpd.Handler) {
+func installSignalHandler(appName, configFile string, grpcS *grpc.Server, l net.Listener, dnsServers []*dns.Server, handler *dhcpd.Handler) {
 	sigChan := make(chan os.Signal, 1)
 	go func() {
 		for {
 			switch <-sigChan {
-			// FIXME add config reload as SIGUSR1 or SIGHUP
+			case syscall.SIGHUP:
+				logrus.Infof("Reloading configuration from %v...", configFile)
+				config, err := dhcpd.ParseConfig(configFile)
+				if err != nil {
+					logrus.Errorf("Could not parse configuration; keeping the old one: %v", err)
+					continue
+				}
+
+				if err := handler.Reload(config); err != nil {
+					logrus.Errorf("Could not reload configuration: %v", err)
+					continue
+				}
+
+				logrus.Infof("Done.")
 			case syscall.SIGTERM, syscall.SIGINT:
 				logrus.Infof("Stopping %v...", appName)
 				grpcS.GracefulStop()
 				l.Close()
+				for _, s := range dnsServers {
+					s.Shutdown()
+				}
 				handler.Close()
 				logrus.Infof("Done.")
 				os.Exit(0)
 			}
 		}
 	}()
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+}
+
+// listenDNS makes the servers answering DNS queries on the address over udp
+// and tcp. There are none if the address is empty.
+func listenDNS(addr string, handler *dhcpd.Handler) ([]*dns.Server, error) {
+	if addr == "" {
+		return nil, nil
+	}
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	return []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	}, nil
 }
 
 func serve(ctx *cli.Context) error {
@@ -100,9 +145,17 @@ func serve(ctx *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "while configuring grpc listener")
 	}
-	installSignalHandler(ctx.App.Name, srv, l, handler)
+
+	dnsServers, err := listenDNS(ctx.GlobalString("dns"), handler)
+	if err != nil {
+		return errors.Wrap(err, "while configuring dns listener")
+	}
+	installSignalHandler(ctx.App.Name, ctx.Args()[1], srv, l, dnsServers, handler)
 
 	go srv.Serve(l)
+	for _, s := range dnsServers {
+		go s.ActivateAndServe()
+	}
 
 	if ctx.GlobalBool("disable") {
 		select {} // will never reach dhcp listen
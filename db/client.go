@@ -0,0 +1,65 @@
+package db
+
+import (
+	"net"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Client identifies the client holding a lease. A client with a client
+// identifier (option 61) holds the lease recorded for the identifier, or else
+// the lease of its hardware address without a client identifier, which it
+// takes over. A client without one holds the lease of its hardware address,
+// preferably one without a client identifier.
+//
+// Leaving ID empty identifies every client by its hardware address alone.
+type Client struct {
+	MAC net.HardwareAddr
+	// ID is the client identifier, formatted with FormatClientID.
+	ID string
+}
+
+func (c Client) String() string {
+	switch {
+	case c.ID == "":
+		return c.MAC.String()
+	case c.MAC == nil:
+		return "id " + c.ID
+	default:
+		return c.MAC.String() + " id " + c.ID
+	}
+}
+
+// find retrieves the client's lease, or its history.
+func (c Client) find(tx *gorm.DB, out interface{}) error {
+	if c.ID != "" {
+		err := tx.First(out, "client_id = ?", c.ID).Error
+		if c.MAC == nil || !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return tx.First(out, "mac_address = ? and client_id = ''", c.MAC.String()).Error
+	}
+
+	if c.MAC == nil {
+		return errors.New("client has neither a mac nor a client id")
+	}
+
+	return tx.Order("client_id = '' desc").First(out, "mac_address = ?", c.MAC.String()).Error
+}
+
+// Holds returns true if the lease is the client's, as find would have it.
+func (c Client) Holds(l *Lease) bool {
+	if c.ID != "" && l.ClientID == c.ID {
+		return true
+	}
+
+	return c.MAC != nil && l.MACAddress == c.MAC.String() && (c.ID == "" || l.ClientID == "")
+}
+
+// Client returns the client holding the lease.
+func (l *Lease) Client() Client {
+	mac, _ := l.HardwareAddr()
+	return Client{MAC: mac, ID: l.ClientID}
+}
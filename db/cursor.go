@@ -0,0 +1,93 @@
+package db
+
+import (
+	"net"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Cursor is where allocation from a pool of addresses is: the address last
+// allocated from it. It is kept so allocation carries on from there after a
+// restart.
+type Cursor struct {
+	// Pool names the dynamic ranges allocated from.
+	Pool      string `gorm:"primary_key"`
+	Strategy  string
+	IPAddress string
+	Updated   time.Time
+}
+
+// IP returns the parsed, typed IP made for a ipv4 network.
+func (c *Cursor) IP() net.IP {
+	return net.ParseIP(c.IPAddress).To4()
+}
+
+// GetCursor retrieves the cursor of the pool.
+func (db *DB) GetCursor(pool string) (*Cursor, error) {
+	c := &Cursor{}
+
+	return c, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.First(c, "pool = ?", pool).Error
+	})
+}
+
+// SetCursor records the address last allocated from the pool by the
+// strategy.
+func (db *DB) SetCursor(pool, strategy string, ip net.IP) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Save(&Cursor{
+			Pool:      pool,
+			Strategy:  strategy,
+			IPAddress: ip.String(),
+			Updated:   time.Now(),
+		}).Error
+	})
+}
+
+// ListCursors returns the cursors of all pools.
+func (db *DB) ListCursors() ([]*Cursor, error) {
+	list := []*Cursor{}
+
+	return list, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&list).Error
+	})
+}
+
+// Stats are counts of what the database holds.
+type Stats struct {
+	// Leases counts all leases, including offers.
+	Leases      int
+	Offered     int
+	Persistent  int
+	Configured  int
+	Quarantined int
+	History     int
+}
+
+// Stats counts the leases by kind, the quarantined addresses and the history.
+func (db *DB) Stats() (*Stats, error) {
+	s := &Stats{}
+
+	return s, db.db.Transaction(func(tx *gorm.DB) error {
+		counts := []struct {
+			count *int
+			query *gorm.DB
+		}{
+			{&s.Leases, tx.Model(&Lease{})},
+			{&s.Offered, tx.Model(&Lease{}).Where("offered")},
+			{&s.Persistent, tx.Model(&Lease{}).Where("persistent")},
+			{&s.Configured, tx.Model(&Lease{}).Where("configured")},
+			{&s.Quarantined, tx.Model(&Quarantine{}).Where("until > ?", time.Now())},
+			{&s.History, tx.Model(&History{})},
+		}
+
+		for _, c := range counts {
+			if err := c.query.Count(c.count).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -1,6 +1,10 @@
 package db
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite" // import sqlite3
 	"github.com/pkg/errors"
@@ -9,6 +13,13 @@ import (
 // DB is the outer shell for the gorm DB handle.
 type DB struct {
 	db *gorm.DB
+
+	// index is kept in step with the lease table by update.
+	index       *addressIndex
+	updateMutex sync.Mutex
+
+	expireHook func([]*Lease)
+	hookMutex  sync.RWMutex
 }
 
 // NewDB opens the DB
@@ -18,14 +29,140 @@ func NewDB(dbfile string) (*DB, error) {
 		return nil, errors.Wrap(err, "could not connect to db")
 	}
 
-	if err := db.AutoMigrate(&Lease{}).Error; err != nil {
+	// sqlite cannot upgrade a read transaction to a write while another
+	// connection holds a lock, and fails with "database is locked" rather than
+	// waiting. A single connection serializes the transactions instead.
+	db.DB().SetMaxOpenConns(1)
+
+	// leases and history were once keyed by mac address, and leases set by
+	// client identifier alone by the client identifier.
+	if err := rekey(db, &Lease{}, "update leases set mac_address = '' where mac_address = client_id"); err != nil {
+		return nil, errors.Wrap(err, "while migrating leases")
+	}
+
+	if err := rekey(db, &History{}); err != nil {
+		return nil, errors.Wrap(err, "while migrating history")
+	}
+
+	if err := db.AutoMigrate(&Lease{}, &Quarantine{}, &History{}, &Cursor{}).Error; err != nil {
 		return nil, errors.Wrap(err, "while migrating database")
 	}
 
-	return &DB{db: db}, nil
+	d := &DB{db: db, index: newAddressIndex()}
+	if err := d.loadIndex(); err != nil {
+		return nil, errors.Wrap(err, "while indexing leased addresses")
+	}
+
+	return d, nil
+}
+
+// rekey rebuilds the table of the model if it was made before the model was
+// keyed by an id, numbering the rows. The statements given are run once the
+// rows are copied.
+func rekey(db *gorm.DB, model interface{}, fixes ...string) error {
+	scope := db.NewScope(model)
+	table := scope.TableName()
+	have, err := tableColumns(db, table)
+	if err != nil || len(have) == 0 || have["id"] {
+		return err
+	}
+
+	old := table + "_unkeyed"
+	columns := []string{}
+	for _, field := range scope.Fields() {
+		if field.IsNormal && !field.IsPrimaryKey && have[field.DBName] {
+			columns = append(columns, field.DBName)
+		}
+	}
+
+	list := strings.Join(columns, ", ")
+	statements := append([]string{
+		fmt.Sprintf("insert into %s (%s) select %s from %s", table, list, list, old),
+	}, fixes...)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// the copy has none of the indexes, whose names the new table takes.
+		if err := tx.Exec(fmt.Sprintf("create table %s as select * from %s", old, table)).Error; err != nil {
+			return err
+		}
+
+		if err := tx.DropTable(table).Error; err != nil {
+			return err
+		}
+
+		if err := tx.AutoMigrate(model).Error; err != nil {
+			return err
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.DropTable(old).Error
+	})
+}
+
+// tableColumns returns the columns of the table, or none if there is no such
+// table. The dialect's HasColumn matches names within others, like id within
+// client_id.
+func tableColumns(db *gorm.DB, table string) (map[string]bool, error) {
+	rows, err := db.Raw(fmt.Sprintf("pragma table_info(%s)", table)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             interface{}
+		)
+
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
 }
 
 // Close the database
 func (db *DB) Close() error {
 	return db.db.Close()
 }
+
+// SetExpireHook sets the function given the leases PurgeLeases removes, once
+// they are gone. Offers which lapsed are not passed to it. The hook is called
+// from the goroutine purging the leases, and should not block.
+func (db *DB) SetExpireHook(hook func([]*Lease)) {
+	db.hookMutex.Lock()
+	defer db.hookMutex.Unlock()
+	db.expireHook = hook
+}
+
+func (db *DB) expired(leases []*Lease) {
+	db.hookMutex.RLock()
+	hook := db.expireHook
+	db.hookMutex.RUnlock()
+
+	if hook == nil {
+		return
+	}
+
+	expired := []*Lease{}
+	for _, l := range leases {
+		if !l.Offered {
+			expired = append(expired, l)
+		}
+	}
+
+	if len(expired) != 0 {
+		hook(expired)
+	}
+}
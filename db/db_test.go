@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/erikh/ldhcpd/testutil"
+	"github.com/jinzhu/gorm"
 )
 
 func TestDBLeaseCRUD(t *testing.T) {
@@ -18,11 +19,11 @@ func TestDBLeaseCRUD(t *testing.T) {
 	defer db.Close()
 	defer os.Remove("test.db")
 
-	if err := db.SetLease(testutil.FakeMAC, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
 		t.Fatalf("could not set basic lease: %v", err)
 	}
 
-	if err := db.SetLease(testutil.FakeMAC2, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
 		t.Fatalf("could not set basic lease: %v", err)
 	}
 
@@ -37,15 +38,15 @@ func TestDBLeaseCRUD(t *testing.T) {
 		t.Fatalf("Did not purge the right number of leases, expected 2, got %d", count)
 	}
 
-	if err := db.SetLease(testutil.FakeMAC, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
 		t.Fatalf("could not set basic lease: %v", err)
 	}
 
-	if _, err := db.RenewLease(testutil.FakeMAC2, time.Now().Add(time.Minute), time.Now()); err == nil {
+	if _, err := db.RenewLease(Client{MAC: testutil.FakeMAC2}, time.Now().Add(time.Minute), time.Now()); err == nil {
 		t.Fatal("did not error renewing lease for missing mac")
 	}
 
-	lease, err := db.RenewLease(testutil.FakeMAC, time.Now().Add(time.Minute), time.Now())
+	lease, err := db.RenewLease(Client{MAC: testutil.FakeMAC}, time.Now().Add(time.Minute), time.Now())
 	if err != nil {
 		t.Fatalf("could not renew lease: %v", err)
 	}
@@ -57,7 +58,7 @@ func TestDBLeaseCRUD(t *testing.T) {
 		t.Fatal("Lease ending was not updated")
 	}
 
-	if err := db.SetLease(testutil.FakeMAC2, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Second), time.Now()); err != nil {
 		t.Fatalf("could not set basic lease: %v", err)
 	}
 }
@@ -70,15 +71,15 @@ func TestDBLease(t *testing.T) {
 	defer db.Close()
 	defer os.Remove("test.db")
 
-	if _, err := db.GetLease(testutil.FakeMAC); err == nil {
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC}); err == nil {
 		t.Fatalf("Found lease where there shouldn't be one")
 	}
 
-	if err := db.SetLease(testutil.FakeMAC, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Hour), time.Now()); err != nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Hour), time.Now()); err != nil {
 		t.Fatalf("Found lease where there shouldn't be one")
 	}
 
-	l, err := db.GetLease(testutil.FakeMAC)
+	l, err := db.GetLease(Client{MAC: testutil.FakeMAC})
 	if err != nil {
 		t.Fatalf("Did not find lease where there should be one")
 	}
@@ -96,11 +97,642 @@ func TestDBLease(t *testing.T) {
 		t.Fatalf("Mac address is not equal in lease: %v", tmpMac.String())
 	}
 
-	if err := db.SetLease(testutil.FakeMAC2, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Hour), time.Now()); err == nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.1"), false, false, time.Now().Add(time.Hour), time.Now()); err == nil {
 		t.Fatal("Should not have been able to create a second lease for 10.0.0.1")
 	}
 
-	if err := db.SetLease(testutil.FakeMAC, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Hour), time.Now()); err == nil {
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.2"), false, false, time.Now().Add(time.Hour), time.Now()); err == nil {
 		t.Fatalf("Should not have been able to create a second lease for %v", testutil.FakeMAC.String())
 	}
 }
+
+func TestDBReleaseLease(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set basic lease: %v", err)
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), false, true, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set persistent lease: %v", err)
+	}
+
+	if err := db.ReleaseLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.2")); err == nil {
+		t.Fatal("released a lease for an ip the mac does not hold")
+	}
+
+	if err := db.ReleaseLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1")); err != nil {
+		t.Fatalf("could not release lease: %v", err)
+	}
+
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("lease was still present after release")
+	}
+
+	if err := db.ReleaseLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2")); err == nil {
+		t.Fatal("released a persistent lease")
+	}
+
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC2}); err != nil {
+		t.Fatalf("persistent lease was removed: %v", err)
+	}
+}
+
+func TestDBOffers(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	if err := db.OfferLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("could not offer lease: %v", err)
+	}
+
+	if err := db.OfferLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.1"), time.Now().Add(time.Second)); err == nil {
+		t.Fatal("offered the same ip twice")
+	}
+
+	if err := db.OfferLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("could not offer lease: %v", err)
+	}
+
+	l, err := db.CommitLease(Client{MAC: testutil.FakeMAC}, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+
+	if l.Offered {
+		t.Fatal("committed lease is still an offer")
+	}
+
+	time.Sleep(time.Second)
+
+	count, err := db.PurgeOffers()
+	if err != nil {
+		t.Fatalf("could not purge offers: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("Did not purge the right number of offers, expected 1, got %d", count)
+	}
+
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC}); err != nil {
+		t.Fatalf("committed lease was purged: %v", err)
+	}
+}
+
+func TestDBClientID(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	id, err := ParseClientID("01:52:54:00:12:34:56")
+	if err != nil {
+		t.Fatalf("could not parse client id: %v", err)
+	}
+
+	clientID := FormatClientID(id)
+	if clientID != "01:52:54:00:12:34:56" {
+		t.Fatalf("client id did not format correctly: %v", clientID)
+	}
+
+	for _, invalid := range []string{"", "1", "01:5", "01:zz", "01::02"} {
+		if _, err := ParseClientID(invalid); err == nil {
+			t.Fatalf("invalid client id %q parsed", invalid)
+		}
+	}
+
+	// a lease set by client id alone is found by it, and takes the mac of
+	// the client when it is seen
+	if err := db.SetLease(Client{ID: clientID}, net.ParseIP("10.0.0.1"), false, true, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set lease by client id: %v", err)
+	}
+
+	l, err := db.GetLease(Client{ID: clientID})
+	if err != nil {
+		t.Fatalf("could not get lease by client id: %v", err)
+	}
+
+	if l.MACAddress != "" {
+		t.Fatalf("lease set by client id has mac %q", l.MACAddress)
+	}
+
+	if _, err := db.GetLease(Client{}); err == nil {
+		t.Fatal("found a lease for an empty client")
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.2"), true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	client := Client{MAC: testutil.FakeMAC, ID: clientID}
+	if l, err := db.GetLease(client); err != nil || !l.IP().Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("client was not given the lease of its client id: %+v %v", l, err)
+	}
+
+	if err := db.SetClientID(client, clientID); err != nil {
+		t.Fatalf("could not record client id: %v", err)
+	}
+
+	if l, err := db.GetLease(Client{ID: clientID}); err != nil || l.MACAddress != testutil.FakeMAC.String() {
+		t.Fatalf("mac of the client was not recorded: %+v %v", l, err)
+	}
+
+	// the lease of the mac without a client id is another client's
+	if l, err := db.GetLease(Client{MAC: testutil.FakeMAC}); err != nil || !l.IP().Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("lease of the mac was lost: %+v %v", l, err)
+	}
+
+	// which is taken by a client id not yet holding a lease
+	other := Client{MAC: testutil.FakeMAC, ID: "01:02:03"}
+	if l, err := db.GetLease(other); err != nil || !l.IP().Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("client did not find the lease of its mac: %+v %v", l, err)
+	}
+
+	if err := db.SetClientID(other, other.ID); err != nil {
+		t.Fatalf("could not record client id: %v", err)
+	}
+
+	// and another client id on the mac gets a lease of its own
+	third := Client{MAC: testutil.FakeMAC, ID: "01:02:04"}
+	if _, err := db.GetLease(third); err == nil {
+		t.Fatal("found a lease for a client without one")
+	}
+
+	if err := db.OfferLease(third, net.ParseIP("10.0.0.3"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("could not offer a lease to another client on the mac: %v", err)
+	}
+
+	if err := db.OfferLease(third, net.ParseIP("10.0.0.4"), time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("offered a second lease to a client")
+	}
+
+	for _, test := range []struct {
+		c  Client
+		ip string
+	}{{client, "10.0.0.1"}, {other, "10.0.0.2"}, {third, "10.0.0.3"}} {
+		c, ip := test.c, test.ip
+		l, err := db.GetLease(c)
+		if err != nil || l.IPAddress != ip || l.ClientID != c.ID || !c.Holds(l) {
+			t.Fatalf("unexpected lease of client [%v]: %+v %v", c, l, err)
+		}
+
+		if !l.Client().Holds(l) {
+			t.Fatalf("lease of client [%v] is not held by its own client", c)
+		}
+	}
+
+	// recording a client id elsewhere takes it from its lease
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.5"), true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if err := db.SetClientID(Client{MAC: testutil.FakeMAC2}, clientID); err != nil {
+		t.Fatalf("could not set client id: %v", err)
+	}
+
+	if l, err := db.GetLease(Client{ID: clientID}); err != nil || l.MACAddress != testutil.FakeMAC2.String() {
+		t.Fatalf("client id did not move to the new lease: %v", err)
+	}
+
+	if l, err := db.GetLeaseByIP(net.ParseIP("10.0.0.1")); err != nil || l.ClientID != "" {
+		t.Fatalf("client id was not cleared from the old lease: %v", err)
+	}
+
+	if err := db.RemoveLease(Client{ID: clientID}); err != nil {
+		t.Fatalf("could not remove lease by client id: %v", err)
+	}
+
+	if err := db.RemoveLease(Client{ID: clientID}); err == nil {
+		t.Fatal("removed a lease which does not exist")
+	}
+}
+
+func TestDBReservations(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	end := time.Now().Add(time.Hour)
+
+	// a reservation replaces the leases of the key and on the ip
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.2"), true, false, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.1"), true, false, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if err := db.SetReservation(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), Boot{BootFile: "host.kpxe"}, end, end); err != nil {
+		t.Fatalf("could not set reservation: %v", err)
+	}
+
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC2}); err == nil {
+		t.Fatal("lease on reserved ip was kept")
+	}
+
+	l, err := db.GetLease(Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("could not get reservation: %v", err)
+	}
+
+	if l.IPAddress != "10.0.0.1" || !l.Persistent || !l.Configured || l.BootFile != "host.kpxe" {
+		t.Fatalf("reservation was incorrect: %+v", l)
+	}
+
+	if err := db.SetReservation(Client{ID: "01:02:03"}, net.ParseIP("10.0.0.3"), Boot{}, end, end); err != nil {
+		t.Fatalf("could not set reservation: %v", err)
+	}
+
+	if l, err := db.GetLease(Client{ID: "01:02:03"}); err != nil || l.MACAddress != "" || !l.Configured {
+		t.Fatalf("reservation by client id was incorrect: %+v %v", l, err)
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.4"), false, true, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	count, err := db.PurgeReservations([]net.IP{net.ParseIP("10.0.0.3")})
+	if err != nil {
+		t.Fatalf("could not purge reservations: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("purged %d reservations, not 1", count)
+	}
+
+	count, err = db.PurgeReservations(nil)
+	if err != nil {
+		t.Fatalf("could not purge reservations: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("purged %d reservations, not 1", count)
+	}
+
+	// leases set at runtime are left alone
+	if _, err := db.GetLease(Client{MAC: testutil.FakeMAC2}); err != nil {
+		t.Fatalf("persistent lease was purged: %v", err)
+	}
+}
+
+func TestDBHistory(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	if _, err := db.GetHistory(Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("history was present before any lease ended")
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), true, false, past, past); err != nil {
+		t.Fatalf("could not set expired lease: %v", err)
+	}
+
+	if err := db.OfferLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), past); err != nil {
+		t.Fatalf("could not set lapsed offer: %v", err)
+	}
+
+	count, err := db.PurgeLeases(false)
+	if err != nil {
+		t.Fatalf("could not purge leases: %v", err)
+	}
+
+	if count != 2 {
+		t.Fatalf("purged %d leases, not 2", count)
+	}
+
+	h, err := db.GetHistory(Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("purged lease was not recorded in the history: %v", err)
+	}
+
+	if !h.IP().Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("history recorded ip %v, not 10.0.0.1", h.IP())
+	}
+
+	if _, err := db.GetHistory(Client{MAC: testutil.FakeMAC2}); err == nil {
+		t.Fatal("lapsed offer was recorded in the history")
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.3"), true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set basic lease: %v", err)
+	}
+
+	if err := db.ReleaseLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.3")); err != nil {
+		t.Fatalf("could not release lease: %v", err)
+	}
+
+	h, err = db.GetHistory(Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("released lease was not recorded in the history: %v", err)
+	}
+
+	if !h.IP().Equal(net.ParseIP("10.0.0.3")) {
+		t.Fatalf("history recorded ip %v, not the released 10.0.0.3", h.IP())
+	}
+
+	count, err = db.PruneHistory(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("could not prune history: %v", err)
+	}
+
+	if count != 0 {
+		t.Fatal("pruned recent history")
+	}
+
+	count, err = db.PruneHistory(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("could not prune history: %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("pruned %d history records, not 1", count)
+	}
+
+	if _, err := db.GetHistory(Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("history was present after pruning")
+	}
+}
+
+func TestAddressIndex(t *testing.T) {
+	ai := newAddressIndex()
+
+	// fill the first chunk, and the word after it
+	base := uint32(0x0a000000)
+	for i := uint32(0); i < chunkSize+64; i++ {
+		ai.set(toIP(base+i), true)
+	}
+
+	if a, ok := ai.nextFree(base, base+0xffff); !ok || a != base+chunkSize+64 {
+		t.Fatalf("next free address was %v", toIP(a))
+	}
+
+	if a, ok := ai.nextFree(base, base+chunkSize+63); ok {
+		t.Fatalf("found free address %v in leased addresses", toIP(a))
+	}
+
+	ai.set(toIP(base+100), false)
+	if a, ok := ai.nextFree(base+1, base+0xffff); !ok || a != base+100 {
+		t.Fatalf("next free address was %v, not the freed %v", toIP(a), toIP(base+100))
+	}
+
+	if a, ok := ai.nextFree(base+101, base+0xffff); !ok || a != base+chunkSize+64 {
+		t.Fatalf("next free address after the freed one was %v", toIP(a))
+	}
+
+	// the end of the address space
+	ai.set(net.ParseIP("255.255.255.255"), true)
+	if a, ok := ai.nextFree(0xfffffff0, 0xffffffff); !ok || a != 0xfffffff0 {
+		t.Fatalf("next free address was %v", toIP(a))
+	}
+
+	if a, ok := ai.nextFree(0xffffffff, 0xffffffff); ok {
+		t.Fatalf("found free address %v in leased addresses", toIP(a))
+	}
+
+	for i := uint32(0); i < chunkSize+64; i++ {
+		ai.set(toIP(base+i), false)
+	}
+
+	if len(ai.chunks) != 1 {
+		t.Fatalf("%d chunks were kept, not 1", len(ai.chunks))
+	}
+}
+
+func TestDBIndex(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer os.Remove("test.db")
+
+	end := time.Now().Add(time.Hour)
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), true, false, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), false, true, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if ip := db.NextUnleased(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.255")); !ip.Equal(net.ParseIP("10.0.0.3")) {
+		t.Fatalf("next unleased address was %v", ip)
+	}
+
+	// a lease which could not be made is not indexed
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.3"), true, false, end, end); err == nil {
+		t.Fatal("leased two addresses to a mac")
+	}
+
+	if db.IsLeased(net.ParseIP("10.0.0.3")) {
+		t.Fatal("address of a lease which could not be made was indexed")
+	}
+
+	if err := db.RemoveLease(Client{MAC: testutil.FakeMAC}); err != nil {
+		t.Fatalf("could not remove lease: %v", err)
+	}
+
+	if db.IsLeased(net.ParseIP("10.0.0.1")) {
+		t.Fatal("address of a removed lease was still indexed")
+	}
+
+	if err := db.OfferLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.3"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("could not offer lease: %v", err)
+	}
+
+	if !db.IsLeased(net.ParseIP("10.0.0.3")) {
+		t.Fatal("offered address was not indexed")
+	}
+
+	if _, err := db.PurgeOffers(); err != nil {
+		t.Fatalf("could not purge offers: %v", err)
+	}
+
+	if db.IsLeased(net.ParseIP("10.0.0.3")) {
+		t.Fatal("address of a lapsed offer was still indexed")
+	}
+
+	db.Close()
+
+	// the index is rebuilt from the lease table
+	db, err = NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+
+	if !db.IsLeased(net.ParseIP("10.0.0.2")) {
+		t.Fatal("index was not rebuilt when the database was opened")
+	}
+
+	if ip := db.NextUnleased(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.255")); !ip.Equal(net.ParseIP("10.0.0.3")) {
+		t.Fatalf("next unleased address was %v after reopening", ip)
+	}
+}
+
+func TestDBClientInfo(t *testing.T) {
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+	defer db.Close()
+	defer os.Remove("test.db")
+
+	end := time.Now().Add(time.Hour)
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC}, net.ParseIP("10.0.0.1"), true, false, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.2"), true, false, end, end); err != nil {
+		t.Fatalf("could not set lease: %v", err)
+	}
+
+	ci := ClientInfo{Hostname: "Laptop", FQDN: "laptop.example.com", VendorClass: "MSFT 5.0"}
+	if err := db.SetClientInfo(Client{MAC: testutil.FakeMAC}, ci); err != nil {
+		t.Fatalf("could not set client info: %v", err)
+	}
+
+	if err := db.SetClientInfo(Client{MAC: testutil.FakeMAC2}, ClientInfo{Hostname: "printer"}); err != nil {
+		t.Fatalf("could not set client info: %v", err)
+	}
+
+	l, err := db.GetLease(Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("could not get lease: %v", err)
+	}
+
+	if l.ClientInfo != ci {
+		t.Fatalf("client info was not recorded: %+v", l.ClientInfo)
+	}
+
+	for pattern, count := range map[string]int{
+		"laptop": 1,
+		"LAP*":   1,
+		"*t*":    2,
+		"desk*":  0,
+	} {
+		leases, err := db.ListLeasesByHostname(pattern)
+		if err != nil {
+			t.Fatalf("could not list leases by hostname: %v", err)
+		}
+
+		if len(leases) != count {
+			t.Fatalf("%d leases matched hostname %q, not %d", len(leases), pattern, count)
+		}
+	}
+
+	for name, count := range map[string]int{
+		"Laptop":  1,
+		"printer": 1,
+		"lap":     0,
+		"example": 0,
+	} {
+		leases, err := db.ListLeasesByName(name)
+		if err != nil {
+			t.Fatalf("could not list leases by name: %v", err)
+		}
+
+		if len(leases) != count {
+			t.Fatalf("%d leases matched name %q, not %d", len(leases), name, count)
+		}
+	}
+
+	// empty values leave those recorded alone
+	if err := db.SetClientInfo(Client{MAC: testutil.FakeMAC}, ClientInfo{Hostname: "laptop"}); err != nil {
+		t.Fatalf("could not set client info: %v", err)
+	}
+
+	l, err = db.GetLease(Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("could not get lease: %v", err)
+	}
+
+	ci.Hostname = "laptop"
+	if l.ClientInfo != ci {
+		t.Fatalf("client info was not updated: %+v", l.ClientInfo)
+	}
+}
+
+func TestDBRekey(t *testing.T) {
+	defer os.Remove("test.db")
+
+	// the tables as they were keyed by mac address
+	type lease struct {
+		MACAddress string `gorm:"primary_key"`
+		IPAddress  string `gorm:"unique"`
+		ClientID   string `gorm:"index"`
+		Persistent bool
+	}
+
+	type history struct {
+		MACAddress string `gorm:"primary_key"`
+		IPAddress  string
+	}
+
+	old, err := gorm.Open("sqlite3", "test.db")
+	if err != nil {
+		t.Fatalf("Could not open test database: %v", err)
+	}
+
+	if err := old.AutoMigrate(&lease{}, &history{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range []interface{}{
+		&lease{MACAddress: testutil.FakeMAC.String(), IPAddress: "10.0.0.1"},
+		&lease{MACAddress: "01:02:03", IPAddress: "10.0.0.2", ClientID: "01:02:03", Persistent: true},
+		&history{MACAddress: testutil.FakeMAC2.String(), IPAddress: "10.0.0.3"},
+	} {
+		if err := old.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := NewDB("test.db")
+	if err != nil {
+		t.Fatalf("Could not migrate test database: %v", err)
+	}
+	defer db.Close()
+
+	if l, err := db.GetLease(Client{MAC: testutil.FakeMAC}); err != nil || l.ID == 0 || l.IPAddress != "10.0.0.1" {
+		t.Fatalf("lease was not migrated: %+v %v", l, err)
+	}
+
+	if l, err := db.GetLease(Client{ID: "01:02:03"}); err != nil || l.MACAddress != "" || !l.Persistent {
+		t.Fatalf("lease set by client id was not migrated: %+v %v", l, err)
+	}
+
+	if h, err := db.GetHistory(Client{MAC: testutil.FakeMAC2}); err != nil || h.ID == 0 || h.IPAddress != "10.0.0.3" {
+		t.Fatalf("history was not migrated: %+v %v", h, err)
+	}
+
+	if !db.IsLeased(net.ParseIP("10.0.0.2")) {
+		t.Fatal("migrated lease was not indexed")
+	}
+
+	if err := db.SetLease(Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.0.4"), true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not set lease after migration: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"net"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// History is the last lease a client held, kept after the lease ended so the
+// client can be given the same address when it returns.
+type History struct {
+	ID         uint   `gorm:"primary_key"`
+	MACAddress string `gorm:"index"`
+	IPAddress  string
+	ClientID   string `gorm:"index"`
+	LeaseEnd   time.Time
+	// Ended is when the lease was purged or released.
+	Ended time.Time `gorm:"index"`
+}
+
+// IP returns the parsed, typed IP made for a ipv4 network.
+func (h *History) IP() net.IP {
+	return net.ParseIP(h.IPAddress).To4()
+}
+
+// GetHistory retrieves the last lease the client held.
+func (db *DB) GetHistory(c Client) (*History, error) {
+	h := &History{}
+
+	return h, db.db.Transaction(func(tx *gorm.DB) error {
+		return c.find(tx, h)
+	})
+}
+
+// PruneHistory removes the history of leases which ended before the given
+// time. It returns the count of removed records, and an error if any.
+func (db *DB) PruneHistory(before time.Time) (int64, error) {
+	var rows int64
+	return rows, db.db.Transaction(func(tx *gorm.DB) error {
+		// shadowing db
+		db := tx.Delete(&History{}, "ended < ?", before)
+		rows = db.RowsAffected
+		return db.Error
+	})
+}
+
+// archive records the leases, which are about to be removed, in the
+// history, in place of the last lease of the same client. Offers never became
+// leases and are not recorded.
+func archive(tx *gorm.DB, leases []*Lease) error {
+	now := time.Now()
+
+	for _, l := range leases {
+		if l.Offered {
+			continue
+		}
+
+		// shadowing db
+		db := tx.Where("client_id = ?", l.ClientID)
+		if l.ClientID == "" {
+			db = db.Where("mac_address = ?", l.MACAddress)
+		}
+
+		if err := db.Delete(&History{}).Error; err != nil {
+			return err
+		}
+
+		err := tx.Create(&History{
+			MACAddress: l.MACAddress,
+			IPAddress:  l.IPAddress,
+			ClientID:   l.ClientID,
+			LeaseEnd:   l.LeaseEnd,
+			Ended:      now,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
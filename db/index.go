@@ -0,0 +1,244 @@
+package db
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	chunkBits  = 12
+	chunkSize  = 1 << chunkBits
+	chunkWords = chunkSize / 64
+)
+
+// chunk is the bitmap of a block of chunkSize addresses, and the count of
+// them leased.
+type chunk struct {
+	words [chunkWords]uint64
+	used  int
+}
+
+// addressIndex is the set of leased addresses, kept in memory so free
+// addresses can be found without querying the lease table. It is a bitmap
+// split into chunks, which are only made once an address in them is leased.
+type addressIndex struct {
+	mutex  sync.RWMutex
+	chunks map[uint32]*chunk
+}
+
+func newAddressIndex() *addressIndex {
+	return &addressIndex{chunks: map[uint32]*chunk{}}
+}
+
+func toUint32(ip net.IP) (uint32, bool) {
+	ip = ip.To4()
+	if ip == nil {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint32(ip), true
+}
+
+func toIP(a uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, a)
+	return ip
+}
+
+// set marks the address leased or free. The mutex must be held for writing.
+func (ai *addressIndex) set(ip net.IP, leased bool) {
+	a, ok := toUint32(ip)
+	if !ok {
+		return
+	}
+
+	c, ok := ai.chunks[a>>chunkBits]
+	if !ok {
+		if !leased {
+			return
+		}
+
+		c = &chunk{}
+		ai.chunks[a>>chunkBits] = c
+	}
+
+	off := a & (chunkSize - 1)
+	bit := uint64(1) << (off % 64)
+	word := &c.words[off/64]
+
+	switch {
+	case leased && *word&bit == 0:
+		*word |= bit
+		c.used++
+	case !leased && *word&bit != 0:
+		*word &^= bit
+		c.used--
+		if c.used == 0 {
+			delete(ai.chunks, a>>chunkBits)
+		}
+	}
+}
+
+func (ai *addressIndex) isLeased(ip net.IP) bool {
+	ai.mutex.RLock()
+	defer ai.mutex.RUnlock()
+
+	a, ok := toUint32(ip)
+	if !ok {
+		return false
+	}
+
+	c, ok := ai.chunks[a>>chunkBits]
+	if !ok {
+		return false
+	}
+
+	off := a & (chunkSize - 1)
+	return c.words[off/64]&(uint64(1)<<(off%64)) != 0
+}
+
+// nextFree returns the first address from from to to inclusive which is not
+// leased. Full chunks and words are skipped whole.
+func (ai *addressIndex) nextFree(from, to uint32) (uint32, bool) {
+	ai.mutex.RLock()
+	defer ai.mutex.RUnlock()
+
+	a := from
+	for a <= to {
+		c, ok := ai.chunks[a>>chunkBits]
+		if !ok {
+			return a, true
+		}
+
+		var next uint32
+		if c.used == chunkSize {
+			next = (a>>chunkBits + 1) << chunkBits
+		} else {
+			off := a & (chunkSize - 1)
+			// the addresses before a in its word are treated as leased
+			word := c.words[off/64] | (uint64(1)<<(off%64) - 1)
+			if word != ^uint64(0) {
+				free := a - off%64 + uint32(bits.TrailingZeros64(^word))
+				return free, free <= to
+			}
+
+			next = a - off%64 + 64
+		}
+
+		// past the last address
+		if next <= a {
+			break
+		}
+
+		a = next
+	}
+
+	return 0, false
+}
+
+// change is an address leased or freed by a transaction.
+type change struct {
+	ip     string
+	leased bool
+}
+
+// changes are the addresses leased and freed by a transaction, in order.
+type changes []change
+
+func (ch *changes) lease(ip string) {
+	*ch = append(*ch, change{ip: ip, leased: true})
+}
+
+func (ch *changes) free(ip string) {
+	*ch = append(*ch, change{ip: ip})
+}
+
+// update runs a transaction which changes the leases, and applies the changes
+// it records to the address index once it commits. Updates are serialized so
+// the index sees them in the order they were committed.
+func (db *DB) update(f func(tx *gorm.DB, ch *changes) error) error {
+	db.updateMutex.Lock()
+	defer db.updateMutex.Unlock()
+
+	ch := changes{}
+	if err := db.db.Transaction(func(tx *gorm.DB) error {
+		return f(tx, &ch)
+	}); err != nil {
+		return err
+	}
+
+	db.index.mutex.Lock()
+	defer db.index.mutex.Unlock()
+
+	for _, c := range ch {
+		db.index.set(net.ParseIP(c.ip), c.leased)
+	}
+
+	return nil
+}
+
+// remove deletes the leases the query selects, and records their addresses
+// as freed. It returns the leases deleted, and the result of the deletion.
+func remove(query *gorm.DB, ch *changes) ([]*Lease, *gorm.DB) {
+	leases := []*Lease{}
+	if db := query.Find(&leases); db.Error != nil {
+		return nil, db
+	}
+
+	db := query.Delete(&Lease{})
+	if db.Error == nil {
+		for _, l := range leases {
+			ch.free(l.IPAddress)
+		}
+	}
+
+	return leases, db
+}
+
+// loadIndex builds the address index from the lease table.
+func (db *DB) loadIndex() error {
+	leases, err := db.ListLeases()
+	if err != nil {
+		return err
+	}
+
+	db.index.mutex.Lock()
+	defer db.index.mutex.Unlock()
+
+	for _, l := range leases {
+		db.index.set(l.IP(), true)
+	}
+
+	return nil
+}
+
+// IsLeased returns true if the ip is held by a lease or offer.
+func (db *DB) IsLeased(ip net.IP) bool {
+	return db.index.isLeased(ip)
+}
+
+// NextUnleased returns the first address from from to to inclusive which is
+// not held by a lease or offer, or nil if there is none. It does not query
+// the lease table.
+func (db *DB) NextUnleased(from, to net.IP) net.IP {
+	f, ok := toUint32(from)
+	if !ok {
+		return nil
+	}
+
+	t, ok := toUint32(to)
+	if !ok {
+		return nil
+	}
+
+	a, ok := db.index.nextFree(f, t)
+	if !ok {
+		return nil
+	}
+
+	return toIP(a)
+}
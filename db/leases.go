@@ -1,20 +1,95 @@
 package db
 
 import (
+	"encoding/hex"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 )
 
-// Lease is a pre-programmed DHCP lease
+// Lease is a pre-programmed DHCP lease. It is held by a client, identified as
+// described for Client.
 type Lease struct {
-	MACAddress    string `gorm:"primary_key"`
+	ID uint `gorm:"primary_key"`
+	// MACAddress is the hardware address the client was last seen with. It
+	// is empty for leases set by client identifier before the client was
+	// seen.
+	MACAddress    string `gorm:"index"`
 	IPAddress     string `gorm:"unique"`
 	Dynamic       bool
 	LeaseEnd      time.Time
 	LeaseGraceEnd time.Time
 	Persistent    bool
+	// Offered leases have been offered to a client which has not yet
+	// requested them. They are held only briefly.
+	Offered bool
+	// ClientID is the client identifier (DHCP option 61) the client last
+	// presented, formatted with FormatClientID. It is unique among leases.
+	ClientID string `gorm:"index"`
+	// Configured leases are the host reservations of the configuration file,
+	// kept in step with it by SetReservation and PurgeReservations.
+	Configured bool
+	RelayInfo
+	Boot
+	ClientInfo
+}
+
+// FormatClientID formats a client identifier for storage: colon separated
+// hex bytes, like a mac address.
+func FormatClientID(id []byte) string {
+	return net.HardwareAddr(id).String()
+}
+
+// ParseClientID parses a client identifier formatted by FormatClientID.
+func ParseClientID(s string) ([]byte, error) {
+	id := []byte{}
+	for _, part := range strings.Split(s, ":") {
+		if len(part) != 2 {
+			return nil, errors.Errorf("invalid client identifier %q", s)
+		}
+
+		b, err := hex.DecodeString(part)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid client identifier %q", s)
+		}
+
+		id = append(id, b...)
+	}
+
+	return id, nil
+}
+
+// RelayInfo is the relay agent information (DHCP option 82, RFC 3046) last
+// seen for a lease: where the client is attached to the network. The values
+// are opaque to us and frequently binary.
+type RelayInfo struct {
+	CircuitID    string
+	RemoteID     string
+	SubscriberID string
+}
+
+// ClientInfo is what the client last told us about itself. The values are
+// as the client sent them.
+type ClientInfo struct {
+	// Hostname is the host name (option 12).
+	Hostname string `gorm:"index"`
+	// FQDN is the domain name of the client FQDN option (81).
+	FQDN string
+	// FQDNFlags are the flags of the client FQDN option.
+	FQDNFlags uint8
+	// VendorClass is the vendor class identifier (option 60).
+	VendorClass string
+}
+
+// Boot is the network boot configuration of a reserved host, overriding the
+// server's. Empty values are not overridden.
+type Boot struct {
+	NextServer   string
+	BootFile     string
+	IPXEBootFile string
 }
 
 // IP returns the parsed, typed IP made for a ipv4 network.
@@ -27,74 +102,355 @@ func (l *Lease) HardwareAddr() (net.HardwareAddr, error) {
 	return net.ParseMAC(l.MACAddress)
 }
 
-// GetLease retrieves the lease if possible, otherwise returns error.
-func (db *DB) GetLease(mac net.HardwareAddr) (*Lease, error) {
+// GetLease retrieves the client's lease if possible, otherwise returns error.
+func (db *DB) GetLease(c Client) (*Lease, error) {
 	l := &Lease{}
 
 	return l, db.db.Transaction(func(tx *gorm.DB) error {
-		return tx.First(l, "mac_address = ?", mac.String()).Error
+		return c.find(tx, l)
 	})
 }
 
-// SetLease creates a lease if possible.
-func (db *DB) SetLease(mac net.HardwareAddr, ip net.IP, dynamic, persistent bool, end, graceEnd time.Time) error {
-	return db.db.Transaction(func(tx *gorm.DB) error {
-		return tx.Create(&Lease{
-			MACAddress:    mac.String(),
+// GetLeaseByIP retrieves the lease holding the IP if possible, otherwise
+// returns error.
+func (db *DB) GetLeaseByIP(ip net.IP) (*Lease, error) {
+	l := &Lease{}
+
+	return l, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.First(l, "ip_address = ?", ip.String()).Error
+	})
+}
+
+// create creates the lease for the client, which must not hold one yet.
+func create(tx *gorm.DB, ch *changes, c Client, l *Lease) error {
+	err := c.find(tx, &Lease{})
+	if err == nil {
+		return errors.Errorf("client [%v] already holds a lease", c)
+	}
+
+	if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	if c.MAC != nil {
+		l.MACAddress = c.MAC.String()
+	}
+
+	l.ClientID = c.ID
+	ch.lease(l.IPAddress)
+	return tx.Create(l).Error
+}
+
+// SetLease creates a lease for the client if possible. The client may be
+// given by its client identifier alone, when its mac is not known; the mac is
+// recorded when the client is first seen.
+func (db *DB) SetLease(c Client, ip net.IP, dynamic, persistent bool, end, graceEnd time.Time) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		return create(tx, ch, c, &Lease{
 			IPAddress:     ip.String(),
 			Dynamic:       dynamic,
 			LeaseEnd:      end,
 			LeaseGraceEnd: graceEnd,
 			Persistent:    persistent,
-		}).Error
+		})
 	})
 }
 
-// RenewLease renews a lease up to the given time.
-func (db *DB) RenewLease(mac net.HardwareAddr, end, graceEnd time.Time) (*Lease, error) {
+// SetReservation makes a persistent lease on the ip for a host reserved in the
+// configuration by its mac, its client identifier, or both. Any lease held by
+// the mac or the client identifier, or on the ip, is replaced.
+func (db *DB) SetReservation(c Client, ip net.IP, b Boot, end, graceEnd time.Time) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		// shadowing db
+		db := tx.Where("ip_address = ?", ip.String())
+		if c.MAC != nil {
+			db = db.Or("mac_address = ?", c.MAC.String())
+		}
+
+		if c.ID != "" {
+			db = db.Or("client_id = ?", c.ID)
+		}
+
+		if _, db := remove(db, ch); db.Error != nil {
+			return db.Error
+		}
+
+		return create(tx, ch, c, &Lease{
+			IPAddress:     ip.String(),
+			LeaseEnd:      end,
+			LeaseGraceEnd: graceEnd,
+			Persistent:    true,
+			Configured:    true,
+			Boot:          b,
+		})
+	})
+}
+
+// PurgeReservations removes the leases made by SetReservation for hosts no
+// longer reserved; keep are the addresses of those which still are. It
+// returns the count of removed leases, and an error if any.
+func (db *DB) PurgeReservations(keep []net.IP) (int64, error) {
+	ips := []string{}
+	for _, ip := range keep {
+		ips = append(ips, ip.String())
+	}
+
+	var rows int64
+	return rows, db.update(func(tx *gorm.DB, ch *changes) error {
+		// shadowing db. an empty list would compare against null, matching
+		// nothing.
+		db := tx.Where("configured")
+		if len(ips) != 0 {
+			db = db.Where("ip_address not in (?)", ips)
+		}
+
+		_, db = remove(db, ch)
+		rows = db.RowsAffected
+		return db.Error
+	})
+}
+
+// OfferLease holds the ip for the client until the given time, pending a
+// request from the client.
+func (db *DB) OfferLease(c Client, ip net.IP, end time.Time) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		return create(tx, ch, c, &Lease{
+			IPAddress:     ip.String(),
+			Dynamic:       true,
+			LeaseEnd:      end,
+			LeaseGraceEnd: end,
+			Offered:       true,
+		})
+	})
+}
+
+// CommitLease promotes the client's offered lease to a full lease ending at
+// the given time.
+func (db *DB) CommitLease(c Client, end, graceEnd time.Time) (*Lease, error) {
 	l := &Lease{}
 
 	return l, db.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.First(l, "mac_address = ?", mac.String()).Error; err != nil {
+		if err := c.find(tx, l); err != nil {
 			return err
 		}
 
+		l.Offered = false
 		l.LeaseEnd = end
 		l.LeaseGraceEnd = graceEnd
 		return tx.Save(l).Error
 	})
 }
 
-// RemoveLease removes a lease based on MAC.
-func (db *DB) RemoveLease(mac net.HardwareAddr) error {
-	count := int64(0)
-	err := db.db.Transaction(func(tx *gorm.DB) error {
+// updateLease applies the updates to the client's lease. A map is used so
+// empty values are written too.
+func updateLease(tx *gorm.DB, c Client, updates map[string]interface{}) error {
+	l := &Lease{}
+	if err := c.find(tx, l); err != nil {
+		return err
+	}
+
+	return tx.Model(l).Updates(updates).Error
+}
+
+// SetClientID records the client identifier the client presented for its
+// lease, with the mac it presented it from: a client found by its client
+// identifier may have moved to another. Any other lease recorded for the
+// client identifier loses it.
+func (db *DB) SetClientID(c Client, clientID string) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		l := &Lease{}
+		if err := c.find(tx, l); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Lease{}).Where("client_id = ? and id <> ?", clientID, l.ID).Update("client_id", "").Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"client_id": clientID}
+		if c.MAC != nil {
+			updates["mac_address"] = c.MAC.String()
+		}
+
+		return tx.Model(l).Updates(updates).Error
+	})
+}
+
+// SetRelayInfo records the relay agent information for the client's lease.
+func (db *DB) SetRelayInfo(c Client, ri RelayInfo) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return updateLease(tx, c, map[string]interface{}{
+			"circuit_id":    ri.CircuitID,
+			"remote_id":     ri.RemoteID,
+			"subscriber_id": ri.SubscriberID,
+		})
+	})
+}
+
+// SetClientInfo records what the client told us about itself for its lease.
+// Clients need not repeat everything in every message, so empty values leave
+// those recorded alone; the FQDN flags go with the FQDN.
+func (db *DB) SetClientInfo(c Client, ci ClientInfo) error {
+	updates := map[string]interface{}{}
+	if ci.Hostname != "" {
+		updates["hostname"] = ci.Hostname
+	}
+
+	if ci.FQDN != "" {
+		updates["fqdn"] = ci.FQDN
+		updates["fqdn_flags"] = ci.FQDNFlags
+	}
+
+	if ci.VendorClass != "" {
+		updates["vendor_class"] = ci.VendorClass
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return updateLease(tx, c, updates)
+	})
+}
+
+// SetBoot sets the network boot configuration of the client's lease.
+func (db *DB) SetBoot(c Client, b Boot) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return updateLease(tx, c, map[string]interface{}{
+			"next_server":    b.NextServer,
+			"boot_file":      b.BootFile,
+			"ipxe_boot_file": b.IPXEBootFile,
+		})
+	})
+}
+
+// RenewLease renews the client's lease up to the given time.
+func (db *DB) RenewLease(c Client, end, graceEnd time.Time) (*Lease, error) {
+	l := &Lease{}
+
+	return l, db.db.Transaction(func(tx *gorm.DB) error {
+		if err := c.find(tx, l); err != nil {
+			return err
+		}
+
+		l.LeaseEnd = end
+		l.LeaseGraceEnd = graceEnd
+		return tx.Save(l).Error
+	})
+}
+
+// RemoveLease removes the client's lease.
+func (db *DB) RemoveLease(c Client) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		l := &Lease{}
+		if err := c.find(tx, l); err != nil {
+			return err
+		}
+
 		// shadowing db
-		db := tx.Delete(&Lease{}, "mac_address = ?", mac.String())
-		count = db.RowsAffected
+		_, db := remove(tx.Where("id = ?", l.ID), ch)
 		return db.Error
 	})
+}
 
-	if err == nil && count == 0 {
-		return gorm.ErrRecordNotFound
+// removeDynamic removes the client's lease if it is a dynamic lease, or
+// offer, for the ip. It returns the lease removed.
+func removeDynamic(tx *gorm.DB, ch *changes, c Client, ip net.IP) (*Lease, error) {
+	l := &Lease{}
+	if err := c.find(tx, l); err != nil {
+		return nil, err
 	}
 
-	return err
+	if l.Persistent || !l.IP().Equal(ip) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	// shadowing db
+	_, db := remove(tx.Where("id = ?", l.ID), ch)
+	return l, db.Error
+}
+
+// ReleaseLease ends the client's dynamic lease for the ip, freeing the
+// address immediately and recording it in the history. Persistent leases are
+// left alone.
+func (db *DB) ReleaseLease(c Client, ip net.IP) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		l, err := removeDynamic(tx, ch, c, ip)
+		if err != nil {
+			return err
+		}
+
+		return archive(tx, []*Lease{l})
+	})
+}
+
+// DeclineLease ends the client's dynamic lease or offer for the ip, which the
+// client found in use, and quarantines the ip until the given time. The lease
+// is not recorded in the history, and any history of the client with the ip
+// is forgotten, so the address is not handed back to the client.
+func (db *DB) DeclineLease(c Client, ip net.IP, until time.Time) error {
+	return db.update(func(tx *gorm.DB, ch *changes) error {
+		l, err := removeDynamic(tx, ch, c, ip)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&History{}, "ip_address = ? and mac_address = ? and client_id = ?", l.IPAddress, l.MACAddress, l.ClientID).Error; err != nil {
+			return err
+		}
+
+		return tx.Save(&Quarantine{
+			IPAddress:  l.IPAddress,
+			MACAddress: l.MACAddress,
+			Until:      until,
+		}).Error
+	})
 }
 
-// PurgeLeases removes all leases that are expired. It returns the count of expired leases, and an error if any.
+// PurgeLeases removes all leases that are expired, recording them in the
+// history and passing them to the expire hook. It returns the count of
+// expired leases, and an error if any.
 func (db *DB) PurgeLeases(ignoreGrace bool) (int64, error) {
-	var rows int64
-	return rows, db.db.Transaction(func(tx *gorm.DB) error {
+	var (
+		rows    int64
+		expired []*Lease
+	)
+
+	err := db.update(func(tx *gorm.DB, ch *changes) error {
 		// shadowing db
 		now := time.Now()
 		var db *gorm.DB
 		if ignoreGrace { // we need ips
-			db = tx.Delete(&Lease{}, "lease_end < ? and not persistent", now)
+			db = tx.Where("lease_end < ? and not persistent", now)
 		} else {
-			db = tx.Delete(&Lease{}, "lease_end < ? and lease_grace_end < ? and not persistent", now, now)
+			db = tx.Where("lease_end < ? and lease_grace_end < ? and not persistent", now, now)
+		}
+
+		leases, db := remove(db, ch)
+		if db.Error != nil {
+			return db.Error
 		}
 
+		rows = db.RowsAffected
+		expired = leases
+		return archive(tx, leases)
+	})
+
+	if err == nil {
+		db.expired(expired)
+	}
+
+	return rows, err
+}
+
+// PurgeOffers removes all offers that lapsed without being requested. It
+// returns the count of removed offers, and an error if any.
+func (db *DB) PurgeOffers() (int64, error) {
+	var rows int64
+	return rows, db.update(func(tx *gorm.DB, ch *changes) error {
+		// shadowing db
+		_, db := remove(tx.Where("offered and lease_end < ?", time.Now()), ch)
 		rows = db.RowsAffected
 		return db.Error
 	})
@@ -108,3 +464,25 @@ func (db *DB) ListLeases() ([]*Lease, error) {
 		return tx.Find(&leases).Error
 	})
 }
+
+// ListLeasesByHostname returns the leases with a hostname matching the
+// pattern, a shell glob matched without regard to case.
+func (db *DB) ListLeasesByHostname(pattern string) ([]*Lease, error) {
+	leases := []*Lease{}
+
+	return leases, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&leases, "lower(hostname) glob lower(?)", pattern).Error
+	})
+}
+
+// ListLeasesByName returns the leases of clients which may go by the name,
+// matched without regard to case: those with it as their hostname, or as the
+// first label of their FQDN.
+func (db *DB) ListLeasesByName(name string) ([]*Lease, error) {
+	leases := []*Lease{}
+	name = strings.ToLower(name)
+
+	return leases, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&leases, "lower(hostname) = ? or lower(fqdn) = ? or substr(lower(fqdn), 1, ?) = ?", name, name, len(name)+1, name+".").Error
+	})
+}
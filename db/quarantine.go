@@ -0,0 +1,79 @@
+package db
+
+import (
+	"net"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Quarantine is an address that may not be handed out until it expires,
+// typically because a client declined it as already in use.
+type Quarantine struct {
+	IPAddress  string `gorm:"primary_key"`
+	MACAddress string
+	Until      time.Time
+}
+
+// IP returns the parsed, typed IP made for a ipv4 network.
+func (q *Quarantine) IP() net.IP {
+	return net.ParseIP(q.IPAddress).To4()
+}
+
+// QuarantineIP quarantines the ip until the given time. mac is the client
+// which reported the address; it is informational only.
+func (db *DB) QuarantineIP(ip net.IP, mac net.HardwareAddr, until time.Time) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Save(&Quarantine{
+			IPAddress:  ip.String(),
+			MACAddress: mac.String(),
+			Until:      until,
+		}).Error
+	})
+}
+
+// IsQuarantined returns true if the ip is currently quarantined.
+func (db *DB) IsQuarantined(ip net.IP) (bool, error) {
+	var count int
+	return count > 0, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Model(&Quarantine{}).Where("ip_address = ? and until > ?", ip.String(), time.Now()).Count(&count).Error
+	})
+}
+
+// ListQuarantine returns all quarantined addresses.
+func (db *DB) ListQuarantine() ([]*Quarantine, error) {
+	list := []*Quarantine{}
+
+	return list, db.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Find(&list).Error
+	})
+}
+
+// RemoveQuarantine lifts the quarantine on an ip.
+func (db *DB) RemoveQuarantine(ip net.IP) error {
+	count := int64(0)
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		// shadowing db
+		db := tx.Delete(&Quarantine{}, "ip_address = ?", ip.String())
+		count = db.RowsAffected
+		return db.Error
+	})
+
+	if err == nil && count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return err
+}
+
+// PurgeQuarantine removes all expired quarantines. It returns the count of
+// removed entries, and an error if any.
+func (db *DB) PurgeQuarantine() (int64, error) {
+	var rows int64
+	return rows, db.db.Transaction(func(tx *gorm.DB) error {
+		// shadowing db
+		db := tx.Delete(&Quarantine{}, "until < ?", time.Now())
+		rows = db.RowsAffected
+		return db.Error
+	})
+}
@@ -14,92 +14,377 @@ import (
 // ErrRangeExhausted is returned when the IP range is exhausted
 var ErrRangeExhausted = errors.New("IP range exhausted")
 
+// maxProbes is how many addresses are probed for an offer before giving up.
+const maxProbes = 4
+
 // Allocator allocates IP addresses from a range
 type Allocator struct {
 	config Config
 	db     *db.DB
+	pool   *pool
+
+	strategy      Strategy
+	strategyName  string
+	strategyMutex *sync.Mutex
+
+	// prober is nil if addresses are not probed.
+	prober Prober
+	// offering serializes Offer for each mac, so a retransmitted
+	// DHCPDISCOVER waits for the first to be probed instead of being handed
+	// its address unprobed.
+	offering *macLocks
+}
+
+// macLocks are mutexes held for each mac.
+type macLocks struct {
+	mutex sync.Mutex
+	locks map[string]*macLock
+}
+
+type macLock struct {
+	sync.Mutex
+	// waiters counts the holder and those waiting for the lock; it is
+	// forgotten when none are left.
+	waiters int
+}
 
-	lastIP      net.IP
-	lastIPMutex sync.Mutex
+func newMACLocks() *macLocks {
+	return &macLocks{locks: map[string]*macLock{}}
 }
 
-// NewAllocator creates a new allocator
+// lock locks the mac, and returns the function unlocking it.
+func (ml *macLocks) lock(mac net.HardwareAddr) func() {
+	key := mac.String()
+
+	ml.mutex.Lock()
+	l, ok := ml.locks[key]
+	if !ok {
+		l = &macLock{}
+		ml.locks[key] = l
+	}
+	l.waiters++
+	ml.mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		ml.mutex.Lock()
+		defer ml.mutex.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(ml.locks, key)
+		}
+	}
+}
+
+// NewAllocator creates a new allocator using the configured strategy. The
+// sequential strategy starts at initial, if it is not nil, and otherwise
+// after the address last allocated from the same ranges, which is kept in the
+// database.
 func NewAllocator(db *db.DB, c Config, initial net.IP) (*Allocator, error) {
+	p := newPool(c.ranges(), c.Exclude)
 	if initial == nil {
-		initial = net.ParseIP(c.DynamicRange.From)
+		initial = p.first()
+
+		cursor, err := db.GetCursor(p.String())
+		if err == nil && p.contains(cursor.IP()) {
+			initial = dhcp4.IPAdd(cursor.IP(), 1)
+		}
+	}
+
+	name := c.Strategy
+	if name == "" {
+		name = StrategySequential
 	}
 
 	return &Allocator{
-		config: c,
-		db:     db,
-		lastIP: dhcp4.IPAdd(initial, -1),
+		config:        c,
+		db:            db,
+		pool:          p,
+		strategy:      newStrategy(name, p, initial),
+		strategyName:  name,
+		strategyMutex: &sync.Mutex{},
+		prober:        newProber(c.Probe.Method),
+		offering:      newMACLocks(),
 	}, nil
 }
 
-// Allocate or Retrieve an IP address for a mac. renew states that if there is
-// already an IP present in the leases table for this mac, to renew the lease
-// if necessary.
-func (a *Allocator) Allocate(mac net.HardwareAddr, renew bool, preferred net.IP) (net.IP, error) {
+// withConfig returns an allocator serving the configuration from this one's
+// pool: the addresses are walked once, by this allocator's strategy, for
+// both.
+func (a *Allocator) withConfig(c Config) *Allocator {
+	shared := *a
+	shared.config = c
+	shared.prober = newProber(c.Probe.Method)
+	return &shared
+}
+
+// Allocate or Retrieve an IP address for a client. renew states that if there
+// is already an IP present in the leases table for this client, to renew the
+// lease if necessary. An outstanding offer for the client is committed as a
+// full lease.
+func (a *Allocator) Allocate(c db.Client, renew bool, preferred net.IP) (net.IP, error) {
 	now := time.Now()
 	// FIXME returning lease end here may help with some distributed race conditions we're seeing
-	l, err := a.db.GetLease(mac)
+	l, err := a.db.GetLease(c)
 	if err == nil {
-		if (renew && (l.LeaseEnd.Before(now) || l.LeaseGraceEnd.Before(now))) || l.Persistent {
+		if l.Offered {
+			leaseEnd := now.Add(a.config.Lease.Duration)
+			l, err = a.db.CommitLease(c, leaseEnd, leaseEnd.Add(a.config.Lease.GracePeriod))
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not commit offer for client [%v]", c)
+			}
+		} else if (renew && (l.LeaseEnd.Before(now) || l.LeaseGraceEnd.Before(now))) || l.Persistent {
 			leaseEnd := now.Add(a.config.Lease.Duration)
-			l, err = a.db.RenewLease(mac, leaseEnd, leaseEnd.Add(a.config.Lease.GracePeriod))
+			l, err = a.db.RenewLease(c, leaseEnd, leaseEnd.Add(a.config.Lease.GracePeriod))
 			if err != nil {
-				return nil, errors.Wrapf(err, "could not renew lease for mac [%v] ip [%v]", mac, a.lastIP)
+				return nil, errors.Wrapf(err, "could not renew lease for client [%v] ip [%v]", c, l.IP())
 			}
 		}
 
 		return l.IP(), nil
 	}
 
-	first, last := a.config.DynamicRange.Dimensions()
-
 	// calculate these ahead of time to save a few cycles
 	leaseEnd := now.Add(a.config.Lease.Duration)
 	gracePeriodEnd := leaseEnd.Add(a.config.Lease.GracePeriod)
 
-	if preferred != nil && dhcp4.IPInRange(first, last, preferred) {
-		logrus.Infof("Preferred IP (%v) supplied; will attempt leasing that for [%v]", preferred, mac)
-		if err := a.db.SetLease(mac, preferred, true, false, leaseEnd, gracePeriodEnd); err != nil {
-			logrus.Warnf("[%v] Getting a lease for preferred IP (%v) was rejected due to an error: %v", mac, preferred, err)
+	return a.allocate(c, preferred, func(ip net.IP) error {
+		return a.db.SetLease(c, ip, true, false, leaseEnd, gracePeriodEnd)
+	})
+}
+
+// Offer reserves an IP address for a client for the offer hold time, pending
+// a request. If the client already holds a lease or offer, that address is
+// offered again. Otherwise the address is probed, if configured; one found in
+// use is quarantined and another is tried.
+func (a *Allocator) Offer(c db.Client, preferred net.IP) (net.IP, error) {
+	// the offer is made before it is probed, claiming the address while it
+	// is; the mac is locked so it is not offered again until then.
+	unlock := a.offering.lock(c.MAC)
+	defer unlock()
+
+	holdEnd := time.Now().Add(a.config.Lease.OfferHold)
+
+	l, err := a.db.GetLease(c)
+	if err == nil {
+		if l.Offered {
+			if _, err := a.db.RenewLease(c, holdEnd, holdEnd); err != nil {
+				return nil, errors.Wrapf(err, "could not extend offer for client [%v]", c)
+			}
+		}
+
+		return l.IP(), nil
+	}
+
+	for i := 0; i < maxProbes; i++ {
+		ip, err := a.allocate(c, preferred, func(ip net.IP) error {
+			return a.db.OfferLease(c, ip, holdEnd)
+		})
+		if err != nil || !a.inUse(c, ip) {
+			return ip, err
+		}
+	}
+
+	return nil, errors.Errorf("the last %d addresses probed for client [%v] were in use", maxProbes, c)
+}
+
+// inUse probes the ip offered to the client. If it answers, it is quarantined
+// and the offer is withdrawn. Probe failures are logged, and the address is
+// taken to be free.
+func (a *Allocator) inUse(c db.Client, ip net.IP) bool {
+	if a.prober == nil {
+		return false
+	}
+
+	answered, err := a.prober.Probe(ip, a.config.Probe.Timeout)
+	if err != nil {
+		logrus.Errorf("While probing ip [%v]: %v", ip, err)
+		return false
+	}
+
+	if !answered {
+		return false
+	}
+
+	logrus.Warnf("ip [%v] answered a probe; quarantining it for %v", ip, a.config.Lease.Quarantine)
+	if err := a.db.QuarantineIP(ip, nil, time.Now().Add(a.config.Lease.Quarantine)); err != nil {
+		logrus.Errorf("Could not quarantine ip [%v]: %v", ip, err)
+	}
+
+	if err := a.db.RemoveLease(c); err != nil {
+		logrus.Errorf("Could not withdraw offer of ip [%v] to client [%v]: %v", ip, c, err)
+	}
+
+	return true
+}
+
+// OfferPinned reserves the pinned address for a client for the offer hold
+// time. A lease another client holds on the address is taken over if it has
+// expired, or if it was made at the same pinned attachment point: the client
+// there was replaced. Any other lease the client holds is given up.
+func (a *Allocator) OfferPinned(c db.Client, pin RelayPin) (net.IP, error) {
+	now := time.Now()
+	holdEnd := now.Add(a.config.Lease.OfferHold)
+	ip := pin.IP()
+
+	if a.quarantined(ip) {
+		return nil, errors.Errorf("pinned ip [%v] is quarantined", ip)
+	}
+
+	l, err := a.db.GetLeaseByIP(ip)
+	if err == nil && !c.Holds(l) {
+		holder := l.Client()
+		if l.Persistent || (l.LeaseEnd.After(now) && !pin.matches(&l.RelayInfo)) {
+			return nil, errors.Errorf("pinned ip [%v] is leased to client [%v]", ip, holder)
+		}
+
+		logrus.Infof("client [%v] replaces client [%v] on pinned ip [%v]", c, holder, ip)
+		if err := a.db.RemoveLease(holder); err != nil {
+			return nil, errors.Wrapf(err, "could not remove lease of client [%v] on pinned ip [%v]", holder, ip)
+		}
+	}
+
+	l, err = a.db.GetLease(c)
+	if err == nil {
+		if l.IP().Equal(ip) {
+			if l.Offered {
+				if _, err := a.db.RenewLease(c, holdEnd, holdEnd); err != nil {
+					return nil, errors.Wrapf(err, "could not extend offer for client [%v]", c)
+				}
+			}
+
+			return ip, nil
+		}
+
+		if l.Persistent {
+			return nil, errors.Errorf("client [%v] holds a persistent lease on ip [%v]", c, l.IP())
+		}
+
+		if err := a.db.RemoveLease(c); err != nil {
+			return nil, errors.Wrapf(err, "could not remove lease of client [%v] on ip [%v]", c, l.IP())
+		}
+	}
+
+	if err := a.db.OfferLease(c, ip, holdEnd); err != nil {
+		return nil, errors.Wrapf(err, "could not offer pinned ip [%v] to client [%v]", ip, c)
+	}
+
+	return ip, nil
+}
+
+// allocate finds a free address in the ranges, trying preferred first, then
+// the address the client last held, and claims it with take. take is expected
+// to fail if the address is in use.
+func (a *Allocator) allocate(c db.Client, preferred net.IP, take func(net.IP) error) (net.IP, error) {
+	if preferred != nil && a.pool.contains(preferred) && !a.quarantined(preferred) {
+		logrus.Infof("Preferred IP (%v) supplied; will attempt leasing that for [%v]", preferred, c)
+		if err := take(preferred); err != nil {
+			logrus.Warnf("[%v] Getting a lease for preferred IP (%v) was rejected due to an error: %v", c, preferred, err)
 		} else {
 			return preferred, nil
 		}
 	}
 
-	a.lastIPMutex.Lock()
-	defer a.lastIPMutex.Unlock()
+	if last := a.lastAddress(c); last != nil && !last.Equal(preferred) && a.pool.contains(last) && !a.quarantined(last) {
+		if err := take(last); err == nil {
+			logrus.Infof("client [%v] was given its previous IP (%v) again", c, last)
+			return last, nil
+		}
+	}
 
-	var foundFirst, foundFirstClearedGrace bool
-	for {
-		ip := dhcp4.IPAdd(a.lastIP, 1)
+	a.strategyMutex.Lock()
+	defer a.strategyMutex.Unlock()
 
-		if !dhcp4.IPInRange(first, last, ip) {
-			if foundFirst {
-				if foundFirstClearedGrace {
-					return nil, ErrRangeExhausted
+	start := a.strategy.Start(c.MAC)
+
+	// the second pass is made after reclaiming addresses in their grace
+	// period.
+	for pass := 0; pass < 2; pass++ {
+		if pass != 0 {
+			if _, err := a.db.PurgeLeases(true); err != nil {
+				return nil, errors.Wrap(err, "trying to clean up lease table")
+			}
+		}
+
+		// the address index skips the leased addresses; take may still fail
+		// if another allocation claims the address first.
+		for _, segment := range a.pool.segments(start) {
+			from, to := segment[0], segment[1]
+			for ip := a.db.NextUnleased(from, to); ip != nil; ip = a.db.NextUnleased(from, to) {
+				if !a.pool.isExcluded(ip) && !a.quarantined(ip) && take(ip) == nil {
+					a.strategy.Allocated(ip)
+					return ip, nil
 				}
 
-				_, err := a.db.PurgeLeases(true)
-				if err != nil {
-					return nil, errors.Wrap(err, "trying to clean up lease table")
+				if ip.Equal(to) {
+					break
 				}
 
-				foundFirstClearedGrace = true
+				from = dhcp4.IPAdd(ip, 1)
 			}
-			a.lastIP = first
-			foundFirst = true
-		} else {
-			a.lastIP = ip
 		}
+	}
 
-		if err := a.db.SetLease(mac, a.lastIP, true, false, leaseEnd, gracePeriodEnd); err != nil {
-			continue
-		}
+	return nil, ErrRangeExhausted
+}
+
+// saveCursor records the address last allocated by the sequential strategy in
+// the database, if it changed since it was last recorded, so allocation
+// carries on from there after a restart. It is called periodically rather
+// than on every allocation. Other strategies keep no cursor.
+func (a *Allocator) saveCursor() error {
+	a.strategyMutex.Lock()
+	defer a.strategyMutex.Unlock()
+
+	s, ok := a.strategy.(*sequentialStrategy)
+	if !ok || s.lastIP.Equal(s.savedIP) {
+		return nil
+	}
+
+	if err := a.db.SetCursor(a.pool.String(), a.strategyName, s.lastIP); err != nil {
+		return errors.Wrapf(err, "could not save the allocation cursor of pool %v", a.pool)
+	}
 
-		return a.lastIP, nil
+	s.savedIP = s.lastIP
+	return nil
+}
+
+// Renew extends the lease held by the client for another lease duration.
+func (a *Allocator) Renew(c db.Client) (*db.Lease, error) {
+	leaseEnd := time.Now().Add(a.config.Lease.Duration)
+	l, err := a.db.RenewLease(c, leaseEnd, leaseEnd.Add(a.config.Lease.GracePeriod))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not renew lease for client [%v]", c)
 	}
+
+	return l, nil
+}
+
+// lastAddress returns the address the client held before its last lease ended,
+// or nil if it is not known.
+func (a *Allocator) lastAddress(c db.Client) net.IP {
+	h, err := a.db.GetHistory(c)
+	if err != nil {
+		return nil
+	}
+
+	return h.IP()
+}
+
+// available returns true if the ip may be allocated: it is in the dynamic
+// ranges and is not quarantined. Whether it is leased is not checked.
+func (a *Allocator) available(ip net.IP) bool {
+	return a.pool.contains(ip) && !a.quarantined(ip)
+}
+
+func (a *Allocator) quarantined(ip net.IP) bool {
+	quarantined, err := a.db.IsQuarantined(ip)
+	if err != nil {
+		logrus.Errorf("While checking quarantine for ip [%v]: %v", ip, err)
+		return true
+	}
+
+	return quarantined
 }
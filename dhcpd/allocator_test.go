@@ -1,29 +1,509 @@
 package dhcpd
 
 import (
+	"fmt"
 	"net"
 	"os"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/erikh/ldhcpd/db"
 	"github.com/erikh/ldhcpd/testutil"
+	"github.com/krolaw/dhcp4"
 )
 
+// strategies runs the test against each allocation strategy.
+func strategies(t *testing.T, test func(t *testing.T, strategy string)) {
+	for _, strategy := range []string{StrategySequential, StrategyRandom, StrategyHash} {
+		t.Run(strategy, func(t *testing.T) {
+			test(t, strategy)
+		})
+	}
+}
+
 func TestAllocator(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: 100 * time.Millisecond,
+			},
+			DNSServers: []string{
+				"10.0.0.1",
+				"1.1.1.1",
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		ip, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil)
+		if err != nil {
+			t.Fatalf("error allocating first ip: %v", err)
+		}
+
+		if strategy == StrategySequential && ip.String() != config.DynamicRange.From {
+			t.Fatalf("Expected allocated ip was incorrect, was %v, supposed to be %v", ip, config.DynamicRange.From)
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil); err != nil {
+			t.Fatalf("error allocating first ip: %v", err)
+		}
+
+		ip2, err := a.Allocate(byMAC(testutil.FakeMAC2), false, nil)
+		if err != nil {
+			t.Fatalf("Could not allocate second mac: %v", err)
+		}
+
+		if ip.String() == ip2.String() {
+			t.Fatal("Allocator handed out same address twice")
+		}
+
+		time.Sleep(100 * time.Millisecond) // lease duration
+
+		count, err := db.PurgeLeases(false)
+		if err != nil {
+			t.Fatalf("could not purge leases: %v", err)
+		}
+
+		if count != 2 {
+			t.Fatal("Did not purge all leases!")
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil); err != nil {
+			t.Fatalf("error allocating first ip: %v", err)
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC2), false, nil); err != nil {
+			t.Fatalf("Could not allocate second mac: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC), true, nil); err != nil {
+			t.Fatalf("error allocating first ip: %v", err)
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC2), true, nil); err != nil {
+			t.Fatalf("Could not allocate second mac: %v", err)
+		}
+
+		count, err = db.PurgeLeases(false)
+		if err != nil {
+			t.Fatalf("could not purge leases: %v", err)
+		}
+
+		if count != 0 {
+			t.Fatal("purged a renewed lease")
+		}
+	})
+}
+
+func TestAllocatorPreferred(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: 100 * time.Millisecond,
+			},
+			DNSServers: []string{
+				"10.0.0.1",
+				"1.1.1.1",
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.50",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		ip, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil)
+		if err != nil {
+			t.Fatalf("allocation failed: %v", err)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		count, err := db.PurgeLeases(true)
+		if err != nil {
+			t.Fatalf("While purging leases: %v", err)
+		}
+
+		if count != 1 {
+			t.Fatalf("Purged lease count wasn't 1, was %d", count)
+		}
+
+		ip2, err := a.Allocate(byMAC(testutil.FakeMAC), false, ip)
+		if err != nil {
+			t.Fatalf("allocation failed: %v", err)
+		}
+
+		if !ip.Equal(ip2) {
+			t.Fatalf("Preferred IP (%v) wasn't allocated, got %v back", ip, ip2)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		count, err = db.PurgeLeases(true)
+		if err != nil {
+			t.Fatalf("While purging leases: %v", err)
+		}
+
+		if count != 1 {
+			t.Fatalf("Purged lease count wasn't 1, was %d", count)
+		}
+
+		// give out to another mac
+		ip2, err = a.Allocate(byMAC(testutil.FakeMAC2), false, ip)
+		if err != nil {
+			t.Fatalf("allocation failed: %v", err)
+		}
+
+		if !ip.Equal(ip2) {
+			t.Fatalf("Preferred IP (%v) wasn't allocated, got %v back", ip, ip2)
+		}
+	})
+}
+
+func TestAllocatorCycles(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: 100 * time.Millisecond,
+			},
+			DNSServers: []string{
+				"10.0.0.1",
+				"1.1.1.1",
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.50",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		ip, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil)
+		if err != nil {
+			t.Fatalf("allocation failed: %v", err)
+		}
+
+		if ip.String() != "10.0.20.50" {
+			t.Fatal("IP was not allocated properly")
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC2), false, nil); err != ErrRangeExhausted {
+			if err != nil {
+				t.Logf("Error was: %v", err)
+			}
+
+			t.Fatalf("allocation did not fail!")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		count, err := db.PurgeLeases(false)
+		if err != nil {
+			t.Fatalf("could not purge leases: %v", err)
+		}
+
+		if count != 1 {
+			t.Fatal("Did not purge all leases!")
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.FakeMAC2), false, nil); err != nil {
+			t.Fatalf("Could not allocate against other mac after purge: %v", err)
+		}
+	})
+}
+
+func TestAllocatorGaps(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration:    time.Second,      // XXX heisenbugs abound in this test, this value is the key to adjusting them away
+				GracePeriod: 10 * time.Minute, // an obnoxious limit intended to blow out the purge routine
+			},
+			DNSServers: []string{
+				"10.0.0.1",
+				"1.1.1.1",
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.59",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		keep := map[string]net.HardwareAddr{}
+
+		for i := 0; i < 10; i++ {
+			mac := testutil.RandomMAC()
+			ip, err := a.Allocate(byMAC(mac), false, nil)
+			if err != nil {
+				t.Fatalf("Allocation failed: %v", err)
+			}
+
+			if i%2 == 0 {
+				keep[ip.String()] = mac
+			}
+		}
+
+		time.Sleep(time.Second)
+
+		for ip, mac := range keep {
+			newip, err := a.Allocate(byMAC(mac), true, nil)
+			if err != nil {
+				t.Fatalf("Error allocating for renewal: %v", err)
+			}
+
+			if newip.String() != ip {
+				t.Fatalf("Allocation did not reap same ip: %v/%v", newip.String(), ip)
+			}
+		}
+
+		count, err := db.PurgeLeases(true)
+		if err != nil {
+			t.Fatalf("Could not purge leases: %v", err)
+		}
+
+		if count != 5 {
+			t.Fatalf("Purged n != 5 records: %v", count)
+		}
+
+		for i := 0; i < 5; i++ {
+			mac := testutil.RandomMAC()
+			ip, err := a.Allocate(byMAC(mac), false, nil)
+			if err != nil {
+				t.Fatalf("Allocation failed: %v", err)
+			}
+
+			if _, ok := keep[ip.String()]; ok {
+				t.Fatalf("Re-allocated renewed ip: %v %v %v", mac, ip.String(), keep[ip.String()])
+			}
+
+			keep[ip.String()] = mac
+		}
+
+		// this is needed to keep the pool from timing out while between this and
+		// that, no purge will happen so the leases are safe.
+		for _, mac := range keep {
+			_, err := a.Allocate(byMAC(mac), true, nil)
+			if err != nil {
+				t.Fatalf("While refreshing ip addresses: %v", err)
+			}
+		}
+
+		if ip, err := a.Allocate(byMAC(testutil.RandomMAC()), false, nil); err != ErrRangeExhausted {
+			t.Fatalf("range was not exhausted during testing: %v", ip)
+		}
+
+		time.Sleep(time.Second)
+
+		// now this should succeed by clearing all the leases in grace period
+		if ip, err := a.Allocate(byMAC(testutil.RandomMAC()), false, nil); err == ErrRangeExhausted {
+			t.Fatalf("range was exhausted during testing: %v", ip)
+		}
+
+		// purge leases to check count, they should have been purged earlier.
+		count, err = db.PurgeLeases(true)
+		if err != nil {
+			t.Fatalf("Could not purge leases: %v", err)
+		}
+
+		if count != 0 {
+			t.Fatalf("Purged n != 0 records: %v", count)
+		}
+	})
+}
+
+func TestAllocatorPersistent(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: 100 * time.Millisecond,
+			},
+			DNSServers: []string{
+				"10.0.0.1",
+				"1.1.1.1",
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.59",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		mac := testutil.RandomMAC()
+		if err := db.SetLease(byMAC(mac), net.ParseIP("1.2.3.4"), false, true, time.Now(), time.Now()); err != nil {
+			t.Fatalf("Error setting lease: %v", err)
+		}
+
+		time.Sleep(time.Second)
+
+		count, err := db.PurgeLeases(false)
+		if err != nil {
+			t.Fatalf("Error purging leases: %v", err)
+		}
+
+		if count != 0 {
+			t.Fatal("Purged persistent lease for some reason")
+		}
+
+		ip, err := a.Allocate(byMAC(mac), false, nil)
+		if err != nil {
+			t.Fatalf("Error allocating mac: %v", err)
+		}
+
+		if ip.String() != "1.2.3.4" {
+			t.Fatalf("Got wrong ip back from allocator: %v, should be 1.2.3.4", ip.String())
+		}
+	})
+}
+
+func TestAllocatorRanges(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: time.Hour,
+			},
+			Gateway: "10.0.20.1",
+			DynamicRanges: []Range{
+				{From: "10.0.20.60", To: "10.0.20.63"},
+				{From: "10.0.20.50", To: "10.0.20.52"},
+			},
+			Exclude:  []string{"10.0.20.51", "10.0.20.62/31"},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
+
+		if err := config.validateAndFix(); err != nil {
+			t.Fatalf("Error validating configuration: %v", err)
+		}
+
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
+
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
+
+		// excluded addresses are not handed out even when asked for, and the
+		// sequential strategy goes through the ranges in address order.
+		allocated := []string{}
+		for i := 0; i < 4; i++ {
+			ip, err := a.Allocate(byMAC(testutil.RandomMAC()), false, net.ParseIP("10.0.20.51"))
+			if err != nil {
+				t.Fatalf("error allocating ip: %v", err)
+			}
+
+			allocated = append(allocated, ip.String())
+		}
+
+		expected := []string{"10.0.20.50", "10.0.20.52", "10.0.20.60", "10.0.20.61"}
+		if strategy != StrategySequential {
+			sort.Strings(allocated)
+		}
+
+		if !reflect.DeepEqual(allocated, expected) {
+			t.Fatalf("Expected allocated ips were incorrect, were %v, supposed to be %v", allocated, expected)
+		}
+
+		if _, err := a.Allocate(byMAC(testutil.RandomMAC()), false, nil); err != ErrRangeExhausted {
+			t.Fatalf("Ranges were not exhausted: %v", err)
+		}
+	})
+}
+
+func TestAllocatorHash(t *testing.T) {
 	config := Config{
 		Lease: Lease{
-			Duration: 100 * time.Millisecond,
-		},
-		DNSServers: []string{
-			"10.0.0.1",
-			"1.1.1.1",
+			Duration: time.Hour,
 		},
 		Gateway: "10.0.20.1",
 		DynamicRange: Range{
 			From: "10.0.20.50",
 			To:   "10.0.20.100",
 		},
-		DBFile: "test.db",
+		Strategy: StrategyHash,
+		DBFile:   "test.db",
 	}
 	defer os.Remove("test.db")
 
@@ -38,80 +518,129 @@ func TestAllocator(t *testing.T) {
 		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	ip, err := a.Allocate(testutil.FakeMAC, false, nil)
-	if err != nil {
-		t.Fatalf("error allocating first ip: %v", err)
-	}
-
-	if ip.String() != config.DynamicRange.From {
-		t.Fatalf("Expected allocated ip was incorrect, was %v, supposed to be %v", ip, config.DynamicRange.From)
-	}
+	mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	other := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
 
-	if _, err := a.Allocate(testutil.FakeMAC, false, nil); err != nil {
-		t.Fatalf("error allocating first ip: %v", err)
+	if _, err := a.Allocate(byMAC(other), false, nil); err != nil {
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	ip2, err := a.Allocate(testutil.FakeMAC2, false, nil)
+	ip, err := a.Allocate(byMAC(mac), false, nil)
 	if err != nil {
-		t.Fatalf("Could not allocate second mac: %v", err)
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	if ip.String() == ip2.String() {
-		t.Fatal("Allocator handed out same address twice")
+	if err := db.RemoveLease(byMAC(mac)); err != nil {
+		t.Fatalf("error removing lease: %v", err)
 	}
 
-	time.Sleep(100 * time.Millisecond) // lease duration
+	if err := db.RemoveLease(byMAC(other)); err != nil {
+		t.Fatalf("error removing lease: %v", err)
+	}
 
-	count, err := db.PurgeLeases(false)
+	// a restarted server hands out the same address, whatever else it
+	// handed out before
+	a, err = NewAllocator(db, config, nil)
 	if err != nil {
-		t.Fatalf("could not purge leases: %v", err)
+		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	if count != 2 {
-		t.Fatal("Did not purge all leases!")
+	ip2, err := a.Allocate(byMAC(mac), false, nil)
+	if err != nil {
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	if _, err := a.Allocate(testutil.FakeMAC, false, nil); err != nil {
-		t.Fatalf("error allocating first ip: %v", err)
+	if !ip.Equal(ip2) {
+		t.Fatalf("mac was allocated %v, not %v", ip2, ip)
 	}
+}
 
-	if _, err := a.Allocate(testutil.FakeMAC2, false, nil); err != nil {
-		t.Fatalf("Could not allocate second mac: %v", err)
-	}
+func TestAllocatorSticky(t *testing.T) {
+	strategies(t, func(t *testing.T, strategy string) {
+		config := Config{
+			Lease: Lease{
+				Duration: 100 * time.Millisecond,
+			},
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Strategy: strategy,
+			DBFile:   "test.db",
+		}
+		defer os.Remove("test.db")
 
-	time.Sleep(100 * time.Millisecond)
+		db, err := config.NewDB()
+		if err != nil {
+			t.Fatalf("Error creating database: %v", err)
+		}
+		defer db.Close()
 
-	if _, err := a.Allocate(testutil.FakeMAC, true, nil); err != nil {
-		t.Fatalf("error allocating first ip: %v", err)
-	}
+		a, err := NewAllocator(db, config, nil)
+		if err != nil {
+			t.Fatalf("error creating allocator: %v", err)
+		}
 
-	if _, err := a.Allocate(testutil.FakeMAC2, true, nil); err != nil {
-		t.Fatalf("Could not allocate second mac: %v", err)
-	}
+		ip, err := a.Allocate(byMAC(testutil.FakeMAC), false, nil)
+		if err != nil {
+			t.Fatalf("error allocating ip: %v", err)
+		}
 
-	count, err = db.PurgeLeases(false)
-	if err != nil {
-		t.Fatalf("could not purge leases: %v", err)
-	}
+		time.Sleep(100 * time.Millisecond)
 
-	if count != 0 {
-		t.Fatal("purged a renewed lease")
-	}
+		if _, err := db.PurgeLeases(false); err != nil {
+			t.Fatalf("could not purge leases: %v", err)
+		}
+
+		// other clients come and go in the meantime; the other strategies
+		// may hand them the free address.
+		if strategy == StrategySequential {
+			for i := 0; i < 5; i++ {
+				if _, err := a.Offer(byMAC(testutil.RandomMAC()), nil); err != nil {
+					t.Fatalf("error offering ip: %v", err)
+				}
+			}
+		}
+
+		ip2, err := a.Offer(byMAC(testutil.FakeMAC), nil)
+		if err != nil {
+			t.Fatalf("error offering ip: %v", err)
+		}
+
+		if !ip.Equal(ip2) {
+			t.Fatalf("returning mac was offered %v, not its previous ip %v", ip2, ip)
+		}
+
+		if err := db.RemoveLease(byMAC(testutil.FakeMAC)); err != nil {
+			t.Fatalf("error removing offer: %v", err)
+		}
+
+		// the previous address is only given while it is free
+		if err := db.SetLease(byMAC(testutil.FakeMAC2), ip, true, false, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("error taking previous ip: %v", err)
+		}
+
+		ip2, err = a.Allocate(byMAC(testutil.FakeMAC), false, nil)
+		if err != nil {
+			t.Fatalf("error allocating ip: %v", err)
+		}
+
+		if ip.Equal(ip2) {
+			t.Fatalf("mac was allocated %v, which is leased to another mac", ip2)
+		}
+	})
 }
 
-func TestAllocatorPreferred(t *testing.T) {
+func TestAllocatorCursor(t *testing.T) {
 	config := Config{
 		Lease: Lease{
-			Duration: 100 * time.Millisecond,
-		},
-		DNSServers: []string{
-			"10.0.0.1",
-			"1.1.1.1",
+			Duration: time.Hour,
 		},
 		Gateway: "10.0.20.1",
 		DynamicRange: Range{
 			From: "10.0.20.50",
-			To:   "10.0.20.50",
+			To:   "10.0.20.100",
 		},
 		DBFile: "test.db",
 	}
@@ -128,130 +657,138 @@ func TestAllocatorPreferred(t *testing.T) {
 		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	ip, err := a.Allocate(testutil.FakeMAC, false, nil)
-	if err != nil {
-		t.Fatalf("allocation failed: %v", err)
-	}
+	macs := []net.HardwareAddr{}
+	for i := 0; i < 3; i++ {
+		mac := testutil.RandomMAC()
+		if _, err := a.Allocate(byMAC(mac), false, nil); err != nil {
+			t.Fatalf("error allocating ip: %v", err)
+		}
 
-	time.Sleep(200 * time.Millisecond)
+		macs = append(macs, mac)
+	}
 
-	count, err := db.PurgeLeases(true)
-	if err != nil {
-		t.Fatalf("While purging leases: %v", err)
+	for _, mac := range macs {
+		if err := db.RemoveLease(byMAC(mac)); err != nil {
+			t.Fatalf("error removing lease: %v", err)
+		}
 	}
 
-	if count != 1 {
-		t.Fatalf("Purged lease count wasn't 1, was %d", count)
+	if err := a.saveCursor(); err != nil {
+		t.Fatalf("error saving cursor: %v", err)
 	}
 
-	ip2, err := a.Allocate(testutil.FakeMAC, false, ip)
+	// a restarted server carries on after the last address it allocated,
+	// rather than at the start of the range
+	a, err = NewAllocator(db, config, nil)
 	if err != nil {
-		t.Fatalf("allocation failed: %v", err)
+		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	if !ip.Equal(ip2) {
-		t.Fatalf("Preferred IP (%v) wasn't allocated, got %v back", ip, ip2)
+	ip, err := a.Allocate(byMAC(testutil.RandomMAC()), false, nil)
+	if err != nil {
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	time.Sleep(200 * time.Millisecond)
-
-	count, err = db.PurgeLeases(true)
-	if err != nil {
-		t.Fatalf("While purging leases: %v", err)
+	if !ip.Equal(net.ParseIP("10.0.20.53")) {
+		t.Fatalf("allocated %v, not 10.0.20.53", ip)
 	}
 
-	if count != 1 {
-		t.Fatalf("Purged lease count wasn't 1, was %d", count)
+	if err := a.saveCursor(); err != nil {
+		t.Fatalf("error saving cursor: %v", err)
 	}
 
-	// give out to another mac
-	ip2, err = a.Allocate(testutil.FakeMAC2, false, ip)
+	cursor, err := db.GetCursor(a.pool.String())
 	if err != nil {
-		t.Fatalf("allocation failed: %v", err)
+		t.Fatalf("error getting cursor: %v", err)
 	}
 
-	if !ip.Equal(ip2) {
-		t.Fatalf("Preferred IP (%v) wasn't allocated, got %v back", ip, ip2)
+	if !cursor.IP().Equal(ip) || cursor.Strategy != StrategySequential {
+		t.Fatalf("cursor was %v (%v), not %v (%v)", cursor.IP(), cursor.Strategy, ip, StrategySequential)
 	}
-}
 
-func TestAllocatorCycles(t *testing.T) {
-	config := Config{
-		Lease: Lease{
-			Duration: 100 * time.Millisecond,
-		},
-		DNSServers: []string{
-			"10.0.0.1",
-			"1.1.1.1",
-		},
-		Gateway: "10.0.20.1",
-		DynamicRange: Range{
-			From: "10.0.20.50",
-			To:   "10.0.20.50",
-		},
-		DBFile: "test.db",
+	// a cursor outside the ranges, as after they are changed, is ignored
+	config.DynamicRange = Range{From: "10.0.20.10", To: "10.0.20.20"}
+	if err := db.SetCursor("10.0.20.10-10.0.20.20", StrategySequential, net.ParseIP("10.0.20.60")); err != nil {
+		t.Fatalf("error setting cursor: %v", err)
 	}
-	defer os.Remove("test.db")
 
-	db, err := config.NewDB()
+	a, err = NewAllocator(db, config, nil)
 	if err != nil {
-		t.Fatalf("Error creating database: %v", err)
+		t.Fatalf("error creating allocator: %v", err)
 	}
-	defer db.Close()
 
-	a, err := NewAllocator(db, config, nil)
+	ip, err = a.Allocate(byMAC(testutil.RandomMAC()), false, nil)
 	if err != nil {
-		t.Fatalf("error creating allocator: %v", err)
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	ip, err := a.Allocate(testutil.FakeMAC, false, nil)
+	if !ip.Equal(net.ParseIP("10.0.20.10")) {
+		t.Fatalf("allocated %v, not 10.0.20.10", ip)
+	}
+
+	// only the sequential strategy keeps a cursor
+	config.DynamicRange = Range{From: "10.0.20.30", To: "10.0.20.40"}
+	config.Strategy = StrategyRandom
+	a, err = NewAllocator(db, config, nil)
 	if err != nil {
-		t.Fatalf("allocation failed: %v", err)
+		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	if ip.String() != "10.0.20.50" {
-		t.Fatal("IP was not allocated properly")
+	if _, err := a.Allocate(byMAC(testutil.RandomMAC()), false, nil); err != nil {
+		t.Fatalf("error allocating ip: %v", err)
 	}
 
-	if _, err := a.Allocate(testutil.FakeMAC2, false, nil); err != ErrRangeExhausted {
-		if err != nil {
-			t.Logf("Error was: %v", err)
-		}
+	if err := a.saveCursor(); err != nil {
+		t.Fatalf("error saving cursor: %v", err)
+	}
 
-		t.Fatalf("allocation did not fail!")
+	if _, err := db.GetCursor(a.pool.String()); err == nil {
+		t.Fatal("random strategy saved a cursor")
 	}
+}
 
-	time.Sleep(100 * time.Millisecond)
+// fakeProber answers probes of the addresses in use, in place of the hosts
+// on a network.
+type fakeProber struct {
+	mutex  sync.Mutex
+	inUse  map[string]bool
+	probed []string
+	// wait, if not nil, holds up the answers until it is closed.
+	wait chan struct{}
+}
 
-	count, err := db.PurgeLeases(false)
-	if err != nil {
-		t.Fatalf("could not purge leases: %v", err)
-	}
+func (p *fakeProber) Probe(ip net.IP, timeout time.Duration) (bool, error) {
+	p.mutex.Lock()
+	p.probed = append(p.probed, ip.String())
+	inUse, wait := p.inUse[ip.String()], p.wait
+	p.mutex.Unlock()
 
-	if count != 1 {
-		t.Fatal("Did not purge all leases!")
+	if wait != nil {
+		<-wait
 	}
 
-	if _, err := a.Allocate(testutil.FakeMAC2, false, nil); err != nil {
-		t.Fatalf("Could not allocate against other mac after purge: %v", err)
-	}
+	return inUse, nil
 }
 
-func TestAllocatorGaps(t *testing.T) {
+func (p *fakeProber) probes() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.probed)
+}
+
+func TestAllocatorProbe(t *testing.T) {
 	config := Config{
 		Lease: Lease{
-			Duration:    time.Second,      // XXX heisenbugs abound in this test, this value is the key to adjusting them away
-			GracePeriod: 10 * time.Minute, // an obnoxious limit intended to blow out the purge routine
-		},
-		DNSServers: []string{
-			"10.0.0.1",
-			"1.1.1.1",
+			Duration:   time.Hour,
+			OfferHold:  time.Minute,
+			Quarantine: time.Hour,
 		},
 		Gateway: "10.0.20.1",
 		DynamicRange: Range{
 			From: "10.0.20.50",
-			To:   "10.0.20.59",
+			To:   "10.0.20.60",
 		},
+		Probe:  Probe{Method: ProbeICMP, Timeout: time.Millisecond},
 		DBFile: "test.db",
 	}
 	defer os.Remove("test.db")
@@ -267,138 +804,194 @@ func TestAllocatorGaps(t *testing.T) {
 		t.Fatalf("error creating allocator: %v", err)
 	}
 
-	keep := map[string]net.HardwareAddr{}
+	prober := &fakeProber{inUse: map[string]bool{"10.0.20.50": true, "10.0.20.51": true}}
+	a.prober = prober
 
-	for i := 0; i < 10; i++ {
-		mac := testutil.RandomMAC()
-		ip, err := a.Allocate(mac, false, nil)
-		if err != nil {
-			t.Fatalf("Allocation failed: %v", err)
-		}
+	ip, err := a.Offer(byMAC(testutil.FakeMAC), nil)
+	if err != nil {
+		t.Fatalf("error offering ip: %v", err)
+	}
 
-		if i%2 == 0 {
-			keep[ip.String()] = mac
-		}
+	if !ip.Equal(net.ParseIP("10.0.20.52")) {
+		t.Fatalf("offered %v, not the first address not in use", ip)
 	}
 
-	time.Sleep(time.Second)
+	if !reflect.DeepEqual(prober.probed, []string{"10.0.20.50", "10.0.20.51", "10.0.20.52"}) {
+		t.Fatalf("probed %v", prober.probed)
+	}
 
-	for ip, mac := range keep {
-		newip, err := a.Allocate(mac, true, nil)
+	for _, inUse := range []string{"10.0.20.50", "10.0.20.51"} {
+		quarantined, err := db.IsQuarantined(net.ParseIP(inUse))
 		if err != nil {
-			t.Fatalf("Error allocating for renewal: %v", err)
+			t.Fatalf("error checking quarantine: %v", err)
 		}
 
-		if newip.String() != ip {
-			t.Fatalf("Allocation did not reap same ip: %v/%v", newip.String(), ip)
+		if !quarantined {
+			t.Fatalf("ip %v in use was not quarantined", inUse)
 		}
 	}
 
-	count, err := db.PurgeLeases(true)
+	l, err := db.GetLease(byMAC(testutil.FakeMAC))
 	if err != nil {
-		t.Fatalf("Could not purge leases: %v", err)
+		t.Fatalf("error getting offer: %v", err)
 	}
 
-	if count != 5 {
-		t.Fatalf("Purged n != 5 records: %v", count)
+	if !l.IP().Equal(ip) {
+		t.Fatalf("offer was of %v, not %v", l.IP(), ip)
 	}
 
-	for i := 0; i < 5; i++ {
-		mac := testutil.RandomMAC()
-		ip, err := a.Allocate(mac, false, nil)
-		if err != nil {
-			t.Fatalf("Allocation failed: %v", err)
-		}
+	// addresses already held are not probed again
+	prober.probed = nil
+	if _, err := a.Offer(byMAC(testutil.FakeMAC), nil); err != nil {
+		t.Fatalf("error offering ip: %v", err)
+	}
 
-		if _, ok := keep[ip.String()]; ok {
-			t.Fatalf("Re-allocated renewed ip: %v %v %v", mac, ip.String(), keep[ip.String()])
-		}
+	if len(prober.probed) != 0 {
+		t.Fatalf("probed %v for a mac already offered an address", prober.probed)
+	}
 
-		keep[ip.String()] = mac
+	// the preferred address is probed too
+	prober.inUse["10.0.20.55"] = true
+	ip, err = a.Offer(byMAC(testutil.FakeMAC2), net.ParseIP("10.0.20.55"))
+	if err != nil {
+		t.Fatalf("error offering ip: %v", err)
 	}
 
-	// this is needed to keep the pool from timing out while between this and
-	// that, no purge will happen so the leases are safe.
-	for _, mac := range keep {
-		_, err := a.Allocate(mac, true, nil)
+	if ip.Equal(net.ParseIP("10.0.20.55")) {
+		t.Fatal("offered the preferred address, which is in use")
+	}
+
+	// a retransmitted discover waits for the address offered to the first to
+	// be probed
+	prober.probed = nil
+	prober.wait = make(chan struct{})
+	mac := testutil.RandomMAC()
+	offered := make(chan net.IP, 2)
+	offer := func() {
+		ip, err := a.Offer(byMAC(mac), nil)
 		if err != nil {
-			t.Fatalf("While refreshing ip addresses: %v", err)
+			t.Errorf("error offering ip: %v", err)
 		}
+		offered <- ip
 	}
 
-	if ip, err := a.Allocate(testutil.RandomMAC(), false, nil); err != ErrRangeExhausted {
-		t.Fatalf("range was not exhausted during testing: %v", ip)
+	go offer()
+	for prober.probes() == 0 {
+		time.Sleep(time.Millisecond)
 	}
 
-	time.Sleep(time.Second)
+	go offer()
+	select {
+	case ip := <-offered:
+		t.Fatalf("offered %v before it was probed", ip)
+	case <-time.After(50 * time.Millisecond):
+	}
 
-	// now this should succeed by clearing all the leases in grace period
-	if ip, err := a.Allocate(testutil.RandomMAC(), false, nil); err == ErrRangeExhausted {
-		t.Fatalf("range was exhausted during testing: %v", ip)
+	close(prober.wait)
+	if first, second := <-offered, <-offered; !first.Equal(second) {
+		t.Fatalf("retransmitted discover was offered %v, not %v", second, first)
 	}
 
-	// purge leases to check count, they should have been purged earlier.
-	count, err = db.PurgeLeases(true)
-	if err != nil {
-		t.Fatalf("Could not purge leases: %v", err)
+	if prober.probes() != 1 {
+		t.Fatalf("probed %v for one offer", prober.probed)
 	}
 
-	if count != 0 {
-		t.Fatalf("Purged n != 0 records: %v", count)
+	prober.wait = nil
+	if err := db.RemoveLease(byMAC(mac)); err != nil {
+		t.Fatalf("error removing offer: %v", err)
 	}
-}
 
-func TestAllocatorPersistent(t *testing.T) {
-	config := Config{
-		Lease: Lease{
-			Duration: 100 * time.Millisecond,
-		},
-		DNSServers: []string{
-			"10.0.0.1",
-			"1.1.1.1",
-		},
-		Gateway: "10.0.20.1",
-		DynamicRange: Range{
-			From: "10.0.20.50",
-			To:   "10.0.20.59",
-		},
-		DBFile: "test.db",
+	// offering gives up after probing maxProbes addresses in use
+	for i := 50; i <= 60; i++ {
+		prober.inUse[fmt.Sprintf("10.0.20.%d", i)] = true
 	}
-	defer os.Remove("test.db")
 
-	db, err := config.NewDB()
-	if err != nil {
-		t.Fatalf("Error creating database: %v", err)
+	if _, err := a.Offer(byMAC(testutil.RandomMAC()), nil); err == nil {
+		t.Fatal("offered an address in use")
 	}
-	defer db.Close()
 
-	a, err := NewAllocator(db, config, nil)
+	stats, err := db.Stats()
 	if err != nil {
-		t.Fatalf("error creating allocator: %v", err)
+		t.Fatalf("error getting stats: %v", err)
 	}
 
-	mac := testutil.RandomMAC()
-	if err := db.SetLease(mac, net.ParseIP("1.2.3.4"), false, true, time.Now(), time.Now()); err != nil {
-		t.Fatalf("Error setting lease: %v", err)
+	if stats.Leases != 2 {
+		t.Fatalf("%d leases remained, not the 2 offers not in use", stats.Leases)
 	}
+}
+
+// benchmarkDB is a database with all but one in every 256 addresses of
+// benchmarkRange leased. It is slow to fill, so it is made once and shared by
+// the benchmarks, which leave it as they found it.
+var (
+	benchmarkRange = Range{From: "10.0.0.1", To: "10.0.255.254"}
+	benchmarkDB    *db.DB
+	benchmarkOnce  sync.Once
+)
 
-	time.Sleep(time.Second)
+func leasedDB(b *testing.B) *db.DB {
+	benchmarkOnce.Do(func() {
+		d, err := db.NewDB(":memory:")
+		if err != nil {
+			b.Fatalf("Error creating database: %v", err)
+		}
 
-	count, err := db.PurgeLeases(false)
-	if err != nil {
-		t.Fatalf("Error purging leases: %v", err)
-	}
+		from, to := benchmarkRange.Dimensions()
+		end := time.Now().Add(time.Hour)
+		for i, ip := 0, from; !dhcp4.IPLess(to, ip); i, ip = i+1, dhcp4.IPAdd(ip, 1) {
+			if i%256 == 0 {
+				continue
+			}
+
+			mac := net.HardwareAddr{0x02, 0x00, 0x00, byte(i >> 16), byte(i >> 8), byte(i)}
+			if err := d.SetLease(byMAC(mac), ip, true, false, end, end); err != nil {
+				b.Fatalf("error leasing ip: %v", err)
+			}
+		}
 
-	if count != 0 {
-		t.Fatal("Purged persistent lease for some reason")
-	}
+		benchmarkDB = d
+	})
 
-	ip, err := a.Allocate(mac, false, nil)
-	if err != nil {
-		t.Fatalf("Error allocating mac: %v", err)
+	if benchmarkDB == nil {
+		b.Fatal("benchmark database could not be filled")
 	}
 
-	if ip.String() != "1.2.3.4" {
-		t.Fatalf("Got wrong ip back from allocator: %v, should be 1.2.3.4", ip.String())
+	return benchmarkDB
+}
+
+// BenchmarkAllocator measures allocating from a /16 with more than 99% of it
+// leased.
+func BenchmarkAllocator(b *testing.B) {
+	for _, strategy := range []string{StrategySequential, StrategyRandom, StrategyHash} {
+		b.Run(strategy, func(b *testing.B) {
+			d := leasedDB(b)
+
+			config := Config{
+				Lease: Lease{
+					Duration: time.Hour,
+				},
+				DynamicRange: benchmarkRange,
+				Strategy:     strategy,
+			}
+
+			a, err := NewAllocator(d, config, nil)
+			if err != nil {
+				b.Fatalf("error creating allocator: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				mac := testutil.RandomMAC()
+				if _, err := a.Allocate(byMAC(mac), false, nil); err != nil {
+					b.Fatalf("error allocating ip: %v", err)
+				}
+
+				b.StopTimer()
+				if err := d.RemoveLease(byMAC(mac)); err != nil {
+					b.Fatalf("error removing lease: %v", err)
+				}
+				b.StartTimer()
+			}
+		})
 	}
 }
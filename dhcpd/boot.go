@@ -0,0 +1,180 @@
+package dhcpd
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/pkg/errors"
+)
+
+// maxBootFileLen is the longest boot file name which fits the file field,
+// which must be NUL terminated.
+const maxBootFileLen = 127
+
+// ipxeUserClass is the user class (option 77) iPXE identifies itself with.
+const ipxeUserClass = "iPXE"
+
+// architectures are the names client architectures (option 93, RFC 4578) can
+// be given by in the configuration.
+var architectures = map[string]iana.Arch{
+	"bios":            iana.INTEL_X86PC,
+	"efi-ia32":        iana.EFI_IA32,
+	"efi-bc":          iana.EFI_BC,
+	"efi-x86_64":      iana.EFI_X86_64,
+	"efi-arm32":       10,
+	"efi-arm64":       11,
+	"efi-x86_64-http": 16,
+	"efi-arm64-http":  19,
+}
+
+// BootFile is the boot file for clients of an architecture.
+type BootFile struct {
+	// Arch is a name from architectures, or the numeric type.
+	Arch     string `yaml:"arch"`
+	Filename string `yaml:"filename"`
+}
+
+func (bf BootFile) arch() (iana.Arch, error) {
+	return parseArch(bf.Arch)
+}
+
+// parseArch parses an architecture name from architectures, or a numeric
+// type.
+func parseArch(s string) (iana.Arch, error) {
+	if arch, ok := architectures[s]; ok {
+		return arch, nil
+	}
+
+	arch, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, errors.Errorf("unknown architecture %q", s)
+	}
+
+	return iana.Arch(arch), nil
+}
+
+// Boot configures network booting (PXE). Clients are sent the boot file for
+// their architecture, or Filename if none matches. iPXE, which is commonly
+// chainloaded from the boot file, is sent IPXEFilename instead so it does
+// not load itself again.
+type Boot struct {
+	NextServer     string     `yaml:"next_server"`
+	TFTPServerName string     `yaml:"tftp_server_name"`
+	Filename       string     `yaml:"filename"`
+	Architectures  []BootFile `yaml:"architectures"`
+	IPXEFilename   string     `yaml:"ipxe_filename"`
+}
+
+// NextServerIP returns the parsed address of the next server.
+func (b Boot) NextServerIP() net.IP {
+	return net.ParseIP(b.NextServer).To4()
+}
+
+func (b Boot) validate() error {
+	if b.NextServer != "" && b.NextServerIP() == nil {
+		return errors.Errorf("invalid next server %q", b.NextServer)
+	}
+
+	files := []string{b.Filename, b.IPXEFilename}
+	for _, bf := range b.Architectures {
+		if _, err := bf.arch(); err != nil {
+			return err
+		}
+
+		if bf.Filename == "" {
+			return errors.Errorf("no filename for architecture %v", bf.Arch)
+		}
+
+		files = append(files, bf.Filename)
+	}
+
+	for _, f := range files {
+		if len(f) > maxBootFileLen {
+			return errors.Errorf("boot file name %q is longer than %d bytes", f, maxBootFileLen)
+		}
+	}
+
+	return nil
+}
+
+// filename selects the boot file for the client.
+func (b Boot) filename(m *dhcpv4.DHCPv4) string {
+	if b.IPXEFilename != "" && isIPXE(m) {
+		return b.IPXEFilename
+	}
+
+	for _, clientArch := range m.ClientArch() {
+		for _, bf := range b.Architectures {
+			if arch, err := bf.arch(); err == nil && arch == clientArch {
+				return bf.Filename
+			}
+		}
+	}
+
+	return b.Filename
+}
+
+// withHost returns the boot configuration with the host's, from its
+// reservation, laid over it.
+func (b Boot) withHost(host db.Boot) Boot {
+	if host.NextServer != "" {
+		b.NextServer = host.NextServer
+	}
+
+	if host.BootFile != "" {
+		b.Filename = host.BootFile
+		b.Architectures = nil
+	}
+
+	if host.IPXEBootFile != "" {
+		b.IPXEFilename = host.IPXEBootFile
+	}
+
+	return b
+}
+
+// apply sets the boot parameters in the reply: the next server (siaddr) and
+// the boot file in the file field, mirrored into options 66 and 67 for
+// clients which look for them there.
+func (b Boot) apply(m, rep *dhcpv4.DHCPv4) {
+	if ip := b.NextServerIP(); ip != nil {
+		rep.ServerIPAddr = ip
+	}
+
+	if b.TFTPServerName != "" {
+		rep.UpdateOption(dhcpv4.OptTFTPServerName(b.TFTPServerName))
+	}
+
+	if f := b.filename(m); f != "" {
+		rep.BootFileName = f
+		rep.UpdateOption(dhcpv4.OptBootFileName(f))
+	}
+}
+
+// configureBoot sets the boot parameters of the scope in the reply, with
+// those of the client's reservation laid over them.
+func (h *Handler) configureBoot(s *scope, m, rep *dhcpv4.DHCPv4) {
+	b := Boot{}
+	if s.config.Boot != nil {
+		b = *s.config.Boot
+	}
+
+	if l, err := h.db.GetLease(h.client(m)); err == nil {
+		b = b.withHost(l.Boot)
+	}
+
+	b.apply(m, rep)
+}
+
+func isIPXE(m *dhcpv4.DHCPv4) bool {
+	for _, class := range m.UserClass() {
+		if class == ipxeUserClass {
+			return true
+		}
+	}
+
+	return false
+}
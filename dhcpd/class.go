@@ -0,0 +1,266 @@
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/pkg/errors"
+)
+
+// Class is a named class of clients, such as phones or PXE clients, which are
+// served differently from the rest: with their own options, lease duration or
+// dynamic range. Clients are placed in the first class they match.
+type Class struct {
+	Name  string     `yaml:"name"`
+	Match ClassMatch `yaml:"match"`
+	// Options are laid over the options of the network the client is on.
+	Options []Option `yaml:"options"`
+	// LeaseDuration replaces the lease duration if set.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	// DynamicRange replaces the dynamic range on the network containing it,
+	// and must lie apart from that network's own ranges.
+	DynamicRange *Range `yaml:"dynamic_range"`
+	// Strategy replaces the allocation strategy of DynamicRange if set.
+	Strategy string `yaml:"strategy"`
+}
+
+// ClassMatch is what a client must match to be in a class. Every criterion
+// set must match. Patterns are shell globs, as in path.Match, so * does not
+// match a slash.
+type ClassMatch struct {
+	// VendorClass is a pattern for the vendor class identifier (option 60).
+	VendorClass string `yaml:"vendor_class"`
+	// UserClass is a pattern for any of the user classes (option 77).
+	UserClass string `yaml:"user_class"`
+	// MACPrefix is the leading bytes of the hardware address, such as an
+	// OUI, as colon separated hex bytes.
+	MACPrefix string `yaml:"mac_prefix"`
+	// Hostname is a pattern for the hostname (option 12) the client sends.
+	Hostname string `yaml:"hostname"`
+	// CircuitID and RemoteID are patterns for the relay agent information
+	// (option 82).
+	CircuitID string `yaml:"circuit_id"`
+	RemoteID  string `yaml:"remote_id"`
+	// Arch is any of the client architectures (option 93), by a name listed
+	// in architectures or number.
+	Arch string `yaml:"arch"`
+}
+
+func (c Class) validate() error {
+	if c.Name == "" {
+		return errors.New("class has no name")
+	}
+
+	if c.Match == (ClassMatch{}) {
+		return errors.New("class matches nothing")
+	}
+
+	for _, pattern := range []string{c.Match.VendorClass, c.Match.UserClass, c.Match.Hostname, c.Match.CircuitID, c.Match.RemoteID} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+
+	if c.Match.MACPrefix != "" {
+		if _, err := parseMACPrefix(c.Match.MACPrefix); err != nil {
+			return err
+		}
+	}
+
+	if c.Match.Arch != "" {
+		if _, err := parseArch(c.Match.Arch); err != nil {
+			return err
+		}
+	}
+
+	if c.LeaseDuration < 0 {
+		return errors.New("lease duration is negative")
+	}
+
+	if c.DynamicRange != nil {
+		if err := c.DynamicRange.validate(); err != nil {
+			return errors.Wrap(err, "could not validate dynamic range")
+		}
+	}
+
+	if err := validateStrategy(c.Strategy); err != nil {
+		return err
+	}
+
+	if c.Strategy != "" && c.DynamicRange == nil {
+		return errors.New("strategy is set without a dynamic range")
+	}
+
+	return validateOptions(c.Options)
+}
+
+// matches returns true if the client sending the message is in the class.
+func (c Class) matches(m *dhcpv4.DHCPv4) bool {
+	match := c.Match
+
+	if match.VendorClass != "" && !matchPattern(match.VendorClass, m.ClassIdentifier()) {
+		return false
+	}
+
+	if match.UserClass != "" && !matchAny(match.UserClass, m.UserClass()) {
+		return false
+	}
+
+	if match.MACPrefix != "" {
+		prefix, _ := parseMACPrefix(match.MACPrefix)
+		if !bytes.HasPrefix(m.ClientHWAddr, prefix) {
+			return false
+		}
+	}
+
+	if match.Hostname != "" && !matchPattern(match.Hostname, m.HostName()) {
+		return false
+	}
+
+	if match.CircuitID != "" || match.RemoteID != "" {
+		ri := relayInfo(m)
+		if ri == nil {
+			return false
+		}
+
+		if match.CircuitID != "" && !matchPattern(match.CircuitID, ri.CircuitID) {
+			return false
+		}
+
+		if match.RemoteID != "" && !matchPattern(match.RemoteID, ri.RemoteID) {
+			return false
+		}
+	}
+
+	if match.Arch != "" {
+		arch, _ := parseArch(match.Arch)
+		found := false
+		for _, clientArch := range m.ClientArch() {
+			if clientArch == arch {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateClassRanges checks the dynamic range of each class lies within the
+// network of the interface or of a subnet, apart from the dynamic ranges of
+// that network and of the other classes.
+func (c Config) validateClassRanges(network *net.IPNet) error {
+	for i, class := range c.Classes {
+		if class.DynamicRange == nil {
+			continue
+		}
+
+		ranges := []Range{*class.DynamicRange}
+		if rangesWithin(ranges, network) == nil {
+			ranges = append(ranges, c.ranges()...)
+		} else {
+			found := false
+			for _, subnet := range c.Subnets {
+				if rangesWithin(ranges, subnet.IPNet()) == nil {
+					ranges = append(ranges, joinRanges(subnet.DynamicRange, subnet.DynamicRanges)...)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return errors.Errorf("dynamic range %v of class %v is outside of network %v and the subnets", *class.DynamicRange, class.Name, network)
+			}
+		}
+
+		for _, other := range c.Classes[:i] {
+			if other.DynamicRange != nil {
+				ranges = append(ranges, *other.DynamicRange)
+			}
+		}
+
+		if err := validateRanges(ranges, nil); err != nil {
+			return errors.Wrapf(err, "could not validate dynamic range of class %v", class.Name)
+		}
+	}
+
+	return nil
+}
+
+// classConfig returns the configuration for serving the class on the
+// network: this configuration with the class's parameters laid over it. It
+// also returns true if the class has a dynamic range of its own there.
+func (c Config) classConfig(class Class, network *net.IPNet) (Config, bool) {
+	cc := c
+	cc.Classes = nil
+	ownRange := false
+
+	if class.LeaseDuration != 0 {
+		cc.Lease.Duration = class.LeaseDuration
+	}
+
+	if class.DynamicRange != nil && rangesWithin([]Range{*class.DynamicRange}, network) == nil {
+		cc.DynamicRange = *class.DynamicRange
+		cc.DynamicRanges = nil
+		ownRange = true
+
+		if class.Strategy != "" {
+			cc.Strategy = class.Strategy
+		}
+	}
+
+	cc.Options = append(append([]Option{}, c.Options...), class.Options...)
+
+	return cc, ownRange
+}
+
+// classify returns the scope serving the class the client is in, or the
+// scope itself if it is in none.
+func (s *scope) classify(m *dhcpv4.DHCPv4) *scope {
+	for i, class := range s.config.Classes {
+		if class.matches(m) {
+			return s.classes[i]
+		}
+	}
+
+	return s
+}
+
+func parseMACPrefix(s string) ([]byte, error) {
+	prefix, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil || len(prefix) == 0 {
+		return nil, errors.Errorf("invalid mac prefix %q", s)
+	}
+
+	return prefix, nil
+}
+
+// matchPattern matches the value against the pattern. An empty value never
+// matches, so clients which do not send it are not matched by "*".
+func matchPattern(pattern, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+func matchAny(pattern string, values []string) bool {
+	for _, v := range values {
+		if matchPattern(pattern, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package dhcpd
+
+import (
+	"strings"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Client FQDN option flags (RFC 4702).
+const (
+	// fqdnFlagS is set when the server is to update the A record.
+	fqdnFlagS = 0x01
+	// fqdnFlagE is set when the domain name is in DNS wire format rather than
+	// ASCII.
+	fqdnFlagE = 0x04
+	// fqdnFlagN is set when the server is to make no updates at all.
+	fqdnFlagN = 0x08
+)
+
+// clientInfo returns what the client says about itself: its hostname (option
+// 12), FQDN (option 81) and vendor class (option 60). It returns nil if it
+// sends none of them.
+func clientInfo(m *dhcpv4.DHCPv4) *db.ClientInfo {
+	fqdn := m.Options.Get(dhcpv4.OptionFQDN)
+	ci := db.ClientInfo{
+		Hostname:    m.HostName(),
+		FQDN:        parseFQDN(fqdn),
+		VendorClass: m.ClassIdentifier(),
+	}
+
+	if ci.FQDN != "" {
+		ci.FQDNFlags = fqdn[0]
+	}
+
+	if ci == (db.ClientInfo{}) {
+		return nil
+	}
+
+	return &ci
+}
+
+// parseFQDN returns the domain name of a client FQDN option, or an empty
+// string if it is missing or malformed. The option is the flags, two
+// deprecated rcode fields and the name.
+func parseFQDN(opt []byte) string {
+	if len(opt) < 3 {
+		return ""
+	}
+
+	flags, name := opt[0], opt[3:]
+	if flags&fqdnFlagE == 0 {
+		return strings.TrimSuffix(string(name), ".")
+	}
+
+	labels := []string{}
+	for len(name) != 0 && name[0] != 0 {
+		n := int(name[0])
+		if n > len(name)-1 {
+			return ""
+		}
+
+		labels = append(labels, string(name[1:1+n]))
+		name = name[1+n:]
+	}
+
+	return strings.Join(labels, ".")
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/erikh/go-transport"
 	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/krolaw/dhcp4"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -16,6 +17,10 @@ import (
 const (
 	defaultDBFile        = "ldhcpd.db"
 	defaultLeaseDuration = 24 * time.Hour
+	defaultQuarantine    = time.Hour
+	defaultOfferHold     = time.Minute
+	defaultHistory       = 30 * 24 * time.Hour
+	defaultProbeTimeout  = 500 * time.Millisecond
 	defaultCAFile        = "/etc/ldhcpd/rootCA.pem"
 	defaultCertFile      = "/etc/ldhcpd/server.pem"
 	defaultKeyFile       = "/etc/ldhcpd/server.key"
@@ -49,20 +54,245 @@ func (r Range) Dimensions() (net.IP, net.IP) {
 	return net.ParseIP(r.From).To4(), net.ParseIP(r.To).To4()
 }
 
+// StaticRoute is a route sent to clients in the classless static routes
+// option (RFC 3442).
+type StaticRoute struct {
+	Destination string `yaml:"destination"`
+	NextHop     string `yaml:"next_hop"`
+}
+
+// Route returns the parsed route.
+func (r StaticRoute) Route() *dhcpv4.Route {
+	_, dest, err := net.ParseCIDR(r.Destination)
+	if err != nil || dest.IP.To4() == nil {
+		return nil
+	}
+
+	nextHop := net.ParseIP(r.NextHop).To4()
+	if nextHop == nil {
+		return nil
+	}
+
+	return &dhcpv4.Route{Dest: dest, Router: nextHop}
+}
+
+func validateStaticRoutes(routes []StaticRoute) error {
+	for _, r := range routes {
+		if r.Route() == nil {
+			return errors.Errorf("invalid static route to %q via %q", r.Destination, r.NextHop)
+		}
+	}
+
+	return nil
+}
+
+// Subnet is a network reached through a DHCP relay agent. Relayed requests
+// are matched to the subnet containing the relay's address (giaddr). DNS
+// servers and search domains are inherited from the top-level configuration
+// when not specified.
+type Subnet struct {
+	Network       string   `yaml:"network"`
+	DynamicRange  Range    `yaml:"dynamic_range"`
+	DynamicRanges []Range  `yaml:"dynamic_ranges"`
+	Exclude       []string `yaml:"exclude"`
+	Strategy      string   `yaml:"strategy"`
+	Gateway       string   `yaml:"gateway"`
+	DNSServers    []string `yaml:"dns_servers"`
+	SearchDomains []string `yaml:"search_domains"`
+	// StaticRoutes replace the top-level static routes.
+	StaticRoutes []StaticRoute `yaml:"static_routes"`
+	// Options are laid over the top-level options.
+	Options []Option `yaml:"options"`
+	// Boot replaces the top-level boot configuration.
+	Boot *Boot `yaml:"boot"`
+}
+
+// IPNet returns the parsed network of the subnet.
+func (s Subnet) IPNet() *net.IPNet {
+	_, network, err := net.ParseCIDR(s.Network)
+	if err != nil || network.IP.To4() == nil {
+		return nil
+	}
+
+	return network
+}
+
+func (s Subnet) validate() error {
+	network := s.IPNet()
+	if network == nil {
+		return errors.Errorf("invalid network %q", s.Network)
+	}
+
+	ranges := joinRanges(s.DynamicRange, s.DynamicRanges)
+	if err := validateRanges(ranges, s.Exclude); err != nil {
+		return errors.Wrap(err, "could not validate dynamic range")
+	}
+
+	if err := rangesWithin(ranges, network); err != nil {
+		return err
+	}
+
+	if err := validateStrategy(s.Strategy); err != nil {
+		return err
+	}
+
+	gateway := net.ParseIP(s.Gateway).To4()
+	if gateway == nil {
+		return errors.New("gateway IP is invalid")
+	}
+
+	if !network.Contains(gateway) {
+		return errors.Errorf("gateway %v is outside of network %v", gateway, network)
+	}
+
+	for _, srv := range s.DNSServers {
+		if net.ParseIP(srv).To4() == nil {
+			return errors.New("DNS servers contains invalid IPs")
+		}
+	}
+
+	if err := validateStaticRoutes(s.StaticRoutes); err != nil {
+		return err
+	}
+
+	if s.Boot != nil {
+		if err := s.Boot.validate(); err != nil {
+			return errors.Wrap(err, "could not validate boot configuration")
+		}
+	}
+
+	return validateOptions(s.Options)
+}
+
+func validateOptions(options []Option) error {
+	codes := map[uint8]bool{}
+	for _, o := range options {
+		code, _, err := o.encode()
+		if err != nil {
+			return errors.Wrapf(err, "invalid option %v", o)
+		}
+
+		if codes[code] {
+			return errors.Errorf("option %v is set more than once", o)
+		}
+
+		codes[code] = true
+	}
+
+	return nil
+}
+
+// RelayPin reserves an address for whichever client is attached at a point
+// in the network a relay agent reports in option 82, such as a switch port.
+// Unset IDs match anything, but at least one must be set.
+type RelayPin struct {
+	CircuitID string `yaml:"circuit_id"`
+	RemoteID  string `yaml:"remote_id"`
+	Address   string `yaml:"address"`
+}
+
+// IP returns the parsed pinned address.
+func (p RelayPin) IP() net.IP {
+	return net.ParseIP(p.Address).To4()
+}
+
+func (p RelayPin) validate() error {
+	if p.IP() == nil {
+		return errors.Errorf("invalid address %q", p.Address)
+	}
+
+	if p.CircuitID == "" && p.RemoteID == "" {
+		return errors.New("circuit_id or remote_id must be specified")
+	}
+
+	return nil
+}
+
+// matches returns true if the relay agent information identifies the pinned
+// attachment point.
+func (p RelayPin) matches(ri *db.RelayInfo) bool {
+	if ri == nil {
+		return false
+	}
+
+	if p.CircuitID != "" && p.CircuitID != ri.CircuitID {
+		return false
+	}
+
+	if p.RemoteID != "" && p.RemoteID != ri.RemoteID {
+		return false
+	}
+
+	return true
+}
+
+// Identities clients can be known by, see Lease.Identity.
+const (
+	// IdentityMAC identifies clients by hardware address only.
+	IdentityMAC = "mac"
+	// IdentityClientID identifies clients by the client identifier (option
+	// 61) if they send one, and by hardware address otherwise.
+	IdentityClientID = "client_id"
+)
+
 // Lease is a lease for a DHCP-allocated address.
 type Lease struct {
 	Duration    time.Duration `yaml:"duration"`
 	GracePeriod time.Duration `yaml:"grace_period"`
+	Quarantine  time.Duration `yaml:"quarantine"`
+	OfferHold   time.Duration `yaml:"offer_hold"`
+	Identity    string        `yaml:"identity"`
+	// History is how long the address a client last held is remembered after
+	// its lease ends, so it can be given the same address when it returns.
+	History time.Duration `yaml:"history"`
 }
 
 // Config is the configuration of the dhcpd service
 type Config struct {
-	DNSServers    []string `yaml:"dns_servers"`
-	Gateway       string   `yaml:"gateway"`
-	DBFile        string   `yaml:"db_file"`
-	DynamicRange  Range    `yaml:"dynamic_range"`
-	Lease         Lease    `yaml:"lease"`
-	SearchDomains []string `yaml:"search_domains"`
+	DNSServers    []string   `yaml:"dns_servers"`
+	Gateway       string     `yaml:"gateway"`
+	DBFile        string     `yaml:"db_file"`
+	DynamicRange  Range      `yaml:"dynamic_range"`
+	Lease         Lease      `yaml:"lease"`
+	SearchDomains []string   `yaml:"search_domains"`
+	Subnets       []Subnet   `yaml:"subnets"`
+	RelayPins     []RelayPin `yaml:"relay_pins"`
+	// DynamicRanges are allocated from along with DynamicRange, in address
+	// order.
+	DynamicRanges []Range `yaml:"dynamic_ranges"`
+	// Exclude lists addresses, or networks in CIDR notation, in the dynamic
+	// ranges which are never allocated.
+	Exclude []string `yaml:"exclude"`
+	// Strategy is the allocation strategy for the dynamic ranges: one of
+	// the Strategy constants.
+	Strategy string `yaml:"strategy"`
+	// StaticRoutes are sent as classless static routes (option 121), and
+	// also as Microsoft's option 249 if MSStaticRoutes is set.
+	StaticRoutes   []StaticRoute `yaml:"static_routes"`
+	MSStaticRoutes bool          `yaml:"ms_static_routes"`
+	// AlwaysSend lists the codes of options sent to clients even if they do
+	// not ask for them.
+	AlwaysSend []int `yaml:"always_send"`
+	// Options are any other options to send.
+	Options []Option `yaml:"options"`
+	// Boot configures network booting; nil disables it.
+	Boot *Boot `yaml:"boot"`
+	// Classes are evaluated in order; see Class.
+	Classes []Class `yaml:"classes"`
+	// Hosts are the host reservations.
+	Hosts []Host `yaml:"hosts"`
+	// Probe checks addresses are not in use before they are offered.
+	Probe Probe `yaml:"probe"`
+	// DDNS sends dynamic DNS updates for the leases; nil disables them.
+	DDNS *DDNS `yaml:"ddns"`
+	// Forwarders are the DNS servers queries the DNS server cannot answer
+	// are forwarded to. If empty, they go to the DNS servers which are not
+	// this host, as those are usually ldhcpd itself.
+	Forwarders []string `yaml:"forwarders"`
+	// Hooks are commands run on lease events, at most HookConcurrency at a
+	// time.
+	Hooks           []Hook `yaml:"hooks"`
+	HookConcurrency int    `yaml:"hook_concurrency"`
 
 	Certificate Certificate `yaml:"certificate"`
 }
@@ -84,10 +314,14 @@ func ParseConfig(filename string) (Config, error) {
 }
 
 func (c *Config) validateAndFix() error {
-	if err := c.DynamicRange.validate(); err != nil {
+	if err := validateRanges(c.ranges(), c.Exclude); err != nil {
 		return errors.Wrap(err, "could not validate dynamic range")
 	}
 
+	if err := validateStrategy(c.Strategy); err != nil {
+		return err
+	}
+
 	if len(c.GatewayIP()) != 4 {
 		return errors.New("gateway IP is invalid")
 	}
@@ -100,6 +334,70 @@ func (c *Config) validateAndFix() error {
 		return errors.New("DNS servers contains invalid IPs")
 	}
 
+	for _, srv := range c.Forwarders {
+		if net.ParseIP(srv).To4() == nil {
+			return errors.New("forwarders contains invalid IPs")
+		}
+	}
+
+	for i, subnet := range c.Subnets {
+		if err := subnet.validate(); err != nil {
+			return errors.Wrapf(err, "could not validate subnet %v", subnet.Network)
+		}
+
+		for _, other := range c.Subnets[:i] {
+			if subnet.IPNet().Contains(other.IPNet().IP) || other.IPNet().Contains(subnet.IPNet().IP) {
+				return errors.Errorf("subnet %v overlaps subnet %v", subnet.Network, other.Network)
+			}
+		}
+	}
+
+	for _, code := range c.AlwaysSend {
+		if code <= 0 || code >= 255 {
+			return errors.Errorf("invalid option code %d in always_send", code)
+		}
+	}
+
+	if err := validateStaticRoutes(c.StaticRoutes); err != nil {
+		return err
+	}
+
+	if err := validateOptions(c.Options); err != nil {
+		return err
+	}
+
+	if c.Boot != nil {
+		if err := c.Boot.validate(); err != nil {
+			return errors.Wrap(err, "could not validate boot configuration")
+		}
+	}
+
+	classes := map[string]bool{}
+	for _, class := range c.Classes {
+		if err := class.validate(); err != nil {
+			return errors.Wrapf(err, "could not validate class %v", class.Name)
+		}
+
+		if classes[class.Name] {
+			return errors.Errorf("class %v is defined more than once", class.Name)
+		}
+
+		classes[class.Name] = true
+	}
+
+	pinned := map[string]bool{}
+	for _, pin := range c.RelayPins {
+		if err := pin.validate(); err != nil {
+			return errors.Wrapf(err, "could not validate relay pin for %v", pin.Address)
+		}
+
+		if pinned[pin.IP().String()] {
+			return errors.Errorf("address %v is pinned more than once", pin.Address)
+		}
+
+		pinned[pin.IP().String()] = true
+	}
+
 	if c.DBFile == "" {
 		c.DBFile = defaultDBFile
 	}
@@ -120,9 +418,126 @@ func (c *Config) validateAndFix() error {
 		c.Lease.Duration = defaultLeaseDuration
 	}
 
+	if c.Lease.Quarantine == 0 {
+		c.Lease.Quarantine = defaultQuarantine
+	}
+
+	if c.Lease.OfferHold == 0 {
+		c.Lease.OfferHold = defaultOfferHold
+	}
+
+	if c.Lease.History == 0 {
+		c.Lease.History = defaultHistory
+	}
+
+	if err := c.Probe.validate(); err != nil {
+		return err
+	}
+
+	if c.Probe.Method != "" && c.Probe.Timeout == 0 {
+		c.Probe.Timeout = defaultProbeTimeout
+	}
+
+	if c.DDNS != nil {
+		if err := c.DDNS.validateAndFix(c.Lease.Duration); err != nil {
+			return errors.Wrap(err, "could not validate dynamic dns configuration")
+		}
+	}
+
+	for i := range c.Hooks {
+		if err := c.Hooks[i].validateAndFix(); err != nil {
+			return errors.Wrapf(err, "could not validate hook %v", c.Hooks[i])
+		}
+	}
+
+	if c.HookConcurrency < 0 {
+		return errors.New("hook concurrency is negative")
+	}
+
+	if len(c.Hooks) != 0 && c.HookConcurrency == 0 {
+		c.HookConcurrency = defaultHookConcurrency
+	}
+
+	switch c.Lease.Identity {
+	case "":
+		c.Lease.Identity = IdentityMAC
+	case IdentityMAC, IdentityClientID:
+	default:
+		return errors.Errorf("invalid lease identity %q", c.Lease.Identity)
+	}
+
+	if err := validateHosts(c.Hosts, c.Lease.Identity); err != nil {
+		return err
+	}
+
+	for _, host := range c.Hosts {
+		if pinned[host.IP().String()] {
+			return errors.Errorf("address %v is both pinned and reserved for host %v", host.IPAddress, host)
+		}
+	}
+
 	return nil
 }
 
+// subnetConfig returns the configuration for serving a relayed subnet: this
+// configuration with the subnet's parameters laid over it.
+func (c Config) subnetConfig(s Subnet) Config {
+	sc := c
+	sc.Subnets = nil
+	sc.DynamicRange = s.DynamicRange
+	sc.DynamicRanges = s.DynamicRanges
+	sc.Exclude = append(append([]string{}, c.Exclude...), s.Exclude...)
+
+	if s.Strategy != "" {
+		sc.Strategy = s.Strategy
+	}
+	sc.Gateway = s.Gateway
+
+	if len(s.DNSServers) != 0 {
+		sc.DNSServers = s.DNSServers
+	}
+
+	if len(s.SearchDomains) != 0 {
+		sc.SearchDomains = s.SearchDomains
+	}
+
+	if len(s.StaticRoutes) != 0 {
+		sc.StaticRoutes = s.StaticRoutes
+	}
+
+	sc.Options = append(append([]Option{}, c.Options...), s.Options...)
+
+	if s.Boot != nil {
+		sc.Boot = s.Boot
+	}
+
+	return sc
+}
+
+// ranges returns all the dynamic ranges.
+func (c Config) ranges() []Range {
+	return joinRanges(c.DynamicRange, c.DynamicRanges)
+}
+
+// validateNetwork checks the dynamic ranges lie within the network of the
+// interface, which is not known until the handler is created, and those of the
+// classes within it or a subnet.
+func (c Config) validateNetwork(network *net.IPNet) error {
+	if err := rangesWithin(c.ranges(), network); err != nil {
+		return err
+	}
+
+	return c.validateClassRanges(network)
+}
+
+func joinRanges(r Range, ranges []Range) []Range {
+	if r == (Range{}) {
+		return ranges
+	}
+
+	return append([]Range{r}, ranges...)
+}
+
 // GatewayIP returns the gateway IP
 func (c Config) GatewayIP() net.IP {
 	return net.ParseIP(c.Gateway).To4()
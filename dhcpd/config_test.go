@@ -1,7 +1,9 @@
 package dhcpd
 
 import (
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,7 +12,11 @@ func TestConfig(t *testing.T) {
 	outConfigs := map[string]Config{
 		"basic": {
 			Lease: Lease{
-				Duration: defaultLeaseDuration,
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
 			},
 			DNSServers: []string{
 				"10.0.0.1",
@@ -30,7 +36,11 @@ func TestConfig(t *testing.T) {
 		},
 		"no dns": {
 			Lease: Lease{
-				Duration: defaultLeaseDuration,
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
 			},
 			DNSServers: []string{},
 			Gateway:    "10.0.20.1",
@@ -47,7 +57,11 @@ func TestConfig(t *testing.T) {
 		},
 		"lease duration populated": {
 			Lease: Lease{
-				Duration: time.Hour,
+				Duration:   time.Hour,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
 			},
 			DNSServers: []string{
 				"10.0.0.1",
@@ -67,7 +81,11 @@ func TestConfig(t *testing.T) {
 		},
 		"db file populated": {
 			Lease: Lease{
-				Duration: defaultLeaseDuration,
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
 			},
 			DNSServers: []string{
 				"10.0.0.1",
@@ -87,7 +105,11 @@ func TestConfig(t *testing.T) {
 		},
 		"cert configuration populated": {
 			Lease: Lease{
-				Duration: defaultLeaseDuration,
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
 			},
 			DNSServers: []string{
 				"10.0.0.1",
@@ -105,6 +127,90 @@ func TestConfig(t *testing.T) {
 				KeyFile:  "server.key",
 			},
 		},
+		"subnets": {
+			Lease: Lease{
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
+			},
+			DNSServers: []string{},
+			Gateway:    "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+				},
+			},
+			DBFile: defaultDBFile,
+			Certificate: Certificate{
+				CAFile:   defaultCAFile,
+				CertFile: defaultCertFile,
+				KeyFile:  defaultKeyFile,
+			},
+		},
+		"relay pins": {
+			Lease: Lease{
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
+			},
+			DNSServers: []string{},
+			Gateway:    "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{
+				{
+					CircuitID: "eth0/1",
+					Address:   "10.0.20.10",
+				},
+				{
+					RemoteID: "switch1",
+					Address:  "10.0.20.11",
+				},
+			},
+			DBFile: defaultDBFile,
+			Certificate: Certificate{
+				CAFile:   defaultCAFile,
+				CertFile: defaultCertFile,
+				KeyFile:  defaultKeyFile,
+			},
+		},
+		"multiple ranges": {
+			Lease: Lease{
+				Duration:   defaultLeaseDuration,
+				Quarantine: defaultQuarantine,
+				OfferHold:  defaultOfferHold,
+				Identity:   IdentityMAC,
+				History:    defaultHistory,
+			},
+			DNSServers: []string{},
+			Gateway:    "10.0.20.1",
+			DynamicRanges: []Range{
+				{From: "10.0.20.50", To: "10.0.20.100"},
+				{From: "10.0.20.150", To: "10.0.20.200"},
+			},
+			Exclude: []string{"10.0.20.60", "10.0.20.64/30"},
+			DBFile:  defaultDBFile,
+			Certificate: Certificate{
+				CAFile:   defaultCAFile,
+				CertFile: defaultCertFile,
+				KeyFile:  defaultKeyFile,
+			},
+		},
 	}
 
 	validConfigs := map[string]Config{
@@ -168,6 +274,48 @@ func TestConfig(t *testing.T) {
 				KeyFile:  "server.key",
 			},
 		},
+		"subnets": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+				},
+			},
+		},
+		"relay pins": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{
+				{
+					CircuitID: "eth0/1",
+					Address:   "10.0.20.10",
+				},
+				{
+					RemoteID: "switch1",
+					Address:  "10.0.20.11",
+				},
+			},
+		},
+		"multiple ranges": {
+			Gateway: "10.0.20.1",
+			DynamicRanges: []Range{
+				{From: "10.0.20.50", To: "10.0.20.100"},
+				{From: "10.0.20.150", To: "10.0.20.200"},
+			},
+			Exclude: []string{"10.0.20.60", "10.0.20.64/30"},
+		},
 	}
 
 	invalidConfigs := map[string]Config{
@@ -216,6 +364,487 @@ func TestConfig(t *testing.T) {
 				To:   "10.0.20.50",
 			},
 		},
+		"subnet range outside network": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.31.100",
+					},
+				},
+			},
+		},
+		"subnet invalid network": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+				},
+			},
+		},
+		"overlapping subnets": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/23",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+				},
+				{
+					Network: "10.0.31.0/24",
+					Gateway: "10.0.31.1",
+					DynamicRange: Range{
+						From: "10.0.31.50",
+						To:   "10.0.31.100",
+					},
+				},
+			},
+		},
+		"bad option": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Options: []Option{
+				{Name: "mtu", Value: 70000},
+			},
+		},
+		"duplicate option": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Options: []Option{
+				{Name: "mtu", Value: 1500},
+				{Code: 26, Type: OptionTypeUint16, Value: 9000},
+			},
+		},
+		"bad subnet option": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+					Options: []Option{
+						{Name: "ntp_servers", Value: "abcdef"},
+					},
+				},
+			},
+		},
+		"bad static route": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			StaticRoutes: []StaticRoute{
+				{Destination: "10.0.50.0", NextHop: "10.0.20.2"},
+			},
+		},
+		"bad static route next hop": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			StaticRoutes: []StaticRoute{
+				{Destination: "10.0.50.0/24", NextHop: "abcdef"},
+			},
+		},
+		"bad next server": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Boot: &Boot{NextServer: "tftp.example.org", Filename: "undionly.kpxe"},
+		},
+		"bad boot architecture": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Boot: &Boot{Architectures: []BootFile{{Arch: "vax", Filename: "boot.vax"}}},
+		},
+		"long boot file": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Boot: &Boot{Filename: strings.Repeat("a", 128)},
+		},
+		"bad subnet boot": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+					Boot: &Boot{Architectures: []BootFile{{Arch: "bios"}}},
+				},
+			},
+		},
+		"class without name": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Match: ClassMatch{VendorClass: "MSFT*"}}},
+		},
+		"class matching nothing": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "all"}},
+		},
+		"bad class pattern": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "phones", Match: ClassMatch{VendorClass: "[Polycom"}}},
+		},
+		"bad class mac prefix": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "printers", Match: ClassMatch{MACPrefix: "00:zz"}}},
+		},
+		"bad class architecture": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "pxe", Match: ClassMatch{Arch: "vax"}}},
+		},
+		"bad class range": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "phones", Match: ClassMatch{VendorClass: "Polycom*"}, DynamicRange: &Range{From: "10.0.20.210", To: "10.0.20.200"}}},
+		},
+		"bad class option": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "phones", Match: ClassMatch{VendorClass: "Polycom*"}, Options: []Option{{Name: "mtu", Value: "big"}}}},
+		},
+		"duplicate class": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{
+				{Name: "phones", Match: ClassMatch{VendorClass: "Polycom*"}},
+				{Name: "phones", Match: ClassMatch{VendorClass: "Cisco*"}},
+			},
+		},
+		"host without mac or client id": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{{IPAddress: "10.0.20.10"}},
+		},
+		"host with bad mac": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{{MACAddress: "00:00:00", IPAddress: "10.0.20.10"}},
+		},
+		"host with bad ip": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{{MACAddress: "00:00:00:00:00:01", IPAddress: "10.0.20"}},
+		},
+		"host by client id with mac identity": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{{ClientID: "01:00:00:00:00:00:01", IPAddress: "10.0.20.10"}},
+		},
+		"duplicate host ip": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{
+				{MACAddress: "00:00:00:00:00:01", IPAddress: "10.0.20.10"},
+				{MACAddress: "00:00:00:00:00:02", IPAddress: "10.0.20.10"},
+			},
+		},
+		"duplicate host mac": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hosts: []Host{
+				{MACAddress: "00:00:00:00:00:01", IPAddress: "10.0.20.10"},
+				{MACAddress: "00:00:00:00:00:01", IPAddress: "10.0.20.11"},
+			},
+		},
+		"pinned host": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{{CircuitID: "port1", Address: "10.0.20.10"}},
+			Hosts:     []Host{{MACAddress: "00:00:00:00:00:01", IPAddress: "10.0.20.10"}},
+		},
+		"overlapping ranges": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			DynamicRanges: []Range{{From: "10.0.20.100", To: "10.0.20.120"}},
+		},
+		"overlapping extra ranges": {
+			Gateway: "10.0.20.1",
+			DynamicRanges: []Range{
+				{From: "10.0.20.50", To: "10.0.20.100"},
+				{From: "10.0.20.10", To: "10.0.20.60"},
+			},
+		},
+		"bad exclusion": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Exclude: []string{"10.0.20.0/33"},
+		},
+		"subnet range outside of network": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Subnets: []Subnet{
+				{
+					Network: "10.0.30.0/24",
+					Gateway: "10.0.30.1",
+					DynamicRange: Range{
+						From: "10.0.30.50",
+						To:   "10.0.30.100",
+					},
+					DynamicRanges: []Range{{From: "10.0.31.50", To: "10.0.31.100"}},
+				},
+			},
+		},
+		"bad strategy": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Strategy: "first-fit",
+		},
+		"bad class strategy": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "phones", Match: ClassMatch{VendorClass: "Polycom*"}, Strategy: "first-fit"}},
+		},
+		"class strategy without a range": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Classes: []Class{{Name: "phones", Match: ClassMatch{VendorClass: "Polycom*"}, Strategy: StrategyRandom}},
+		},
+		"bad probe method": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Probe: Probe{Method: "arp"},
+		},
+		"negative probe timeout": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Probe: Probe{Method: ProbeICMP, Timeout: -time.Second},
+		},
+		"ddns without a server": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			DDNS: &DDNS{ForwardZone: "example.com"},
+		},
+		"ddns reverse zone outside in-addr.arpa": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			DDNS: &DDNS{Server: "10.0.20.2", ForwardZone: "example.com", ReverseZone: "example.com"},
+		},
+		"hook without a command": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hooks: []Hook{{Events: []string{EventCommit}}},
+		},
+		"hook on a bad event": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Hooks: []Hook{{Command: []string{"/bin/true"}, Events: []string{"offer"}}},
+		},
+		"ddns tsig secret not base64": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			DDNS: &DDNS{Server: "10.0.20.2", ForwardZone: "example.com", TSIG: &TSIG{Name: "ldhcpd", Secret: "not base64!"}},
+		},
+		"bad always send option": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			AlwaysSend: []int{255},
+		},
+		"bad lease identity": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Lease: Lease{
+				Identity: "hostname",
+			},
+		},
+		"relay pin without ids": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{
+				{
+					Address: "10.0.20.10",
+				},
+			},
+		},
+		"relay pin invalid address": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{
+				{
+					CircuitID: "eth0/1",
+					Address:   "abcdef",
+				},
+			},
+		},
+		"duplicate relay pins": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			RelayPins: []RelayPin{
+				{
+					CircuitID: "eth0/1",
+					Address:   "10.0.20.10",
+				},
+				{
+					CircuitID: "eth0/2",
+					Address:   "10.0.20.10",
+				},
+			},
+		},
+		"invalid forwarder": {
+			Gateway: "10.0.20.1",
+			DynamicRange: Range{
+				From: "10.0.20.50",
+				To:   "10.0.20.100",
+			},
+			Forwarders: []string{"1.1.1.1", "one.one"},
+		},
 	}
 
 	for name, config := range validConfigs {
@@ -234,3 +863,101 @@ func TestConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigNetwork(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.20.0/24")
+
+	config := Config{
+		DynamicRange:  Range{From: "10.0.20.50", To: "10.0.20.100"},
+		DynamicRanges: []Range{{From: "10.0.20.150", To: "10.0.20.200"}},
+	}
+
+	if err := config.validateNetwork(network); err != nil {
+		t.Fatalf("Errored when expected success: %v", err)
+	}
+
+	config.Subnets = []Subnet{{Network: "10.0.30.0/24", DynamicRange: Range{From: "10.0.30.50", To: "10.0.30.100"}}}
+	config.Classes = []Class{
+		{Name: "phones", DynamicRange: &Range{From: "10.0.20.201", To: "10.0.20.210"}},
+		{Name: "pxe", DynamicRange: &Range{From: "10.0.30.200", To: "10.0.30.210"}},
+	}
+
+	if err := config.validateNetwork(network); err != nil {
+		t.Fatalf("Errored when expected success: %v", err)
+	}
+
+	for name, r := range map[string]Range{
+		"outside of the networks":     {From: "10.0.40.200", To: "10.0.40.210"},
+		"overlapping the network's":   {From: "10.0.20.90", To: "10.0.20.110"},
+		"overlapping the subnet's":    {From: "10.0.30.100", To: "10.0.30.110"},
+		"overlapping another class's": {From: "10.0.20.210", To: "10.0.20.220"},
+		"spanning network and subnet": {From: "10.0.20.250", To: "10.0.30.10"},
+	} {
+		bad := config
+		bad.Classes = append(append([]Class{}, config.Classes...), Class{Name: "bad", DynamicRange: &r})
+		if err := bad.validateNetwork(network); err == nil {
+			t.Fatalf("Class range %v did not error", name)
+		}
+	}
+
+	config.DynamicRanges = append(config.DynamicRanges, Range{From: "10.0.20.250", To: "10.0.21.10"})
+	if err := config.validateNetwork(network); err == nil {
+		t.Fatal("Range outside of the interface network did not error")
+	}
+}
+
+func TestOptionEncoding(t *testing.T) {
+	valid := map[string]struct {
+		option Option
+		code   uint8
+		value  []byte
+	}{
+		"ip":          {Option{Name: "broadcast_address", Value: "10.0.20.255"}, 28, []byte{10, 0, 20, 255}},
+		"ip list":     {Option{Name: "ntp_servers", Value: []interface{}{"10.0.0.1", "10.0.0.2"}}, 42, []byte{10, 0, 0, 1, 10, 0, 0, 2}},
+		"ip as list":  {Option{Name: "ntp_servers", Value: "10.0.0.1"}, 42, []byte{10, 0, 0, 1}},
+		"string":      {Option{Name: "domain_name", Value: "internal"}, 15, []byte("internal")},
+		"uint8":       {Option{Name: "default_ttl", Value: 64}, 23, []byte{64}},
+		"uint16":      {Option{Name: "mtu", Value: 1500}, 26, []byte{0x05, 0xdc}},
+		"uint32":      {Option{Code: 58, Type: OptionTypeUint32, Value: 3600}, 58, []byte{0, 0, 0x0e, 0x10}},
+		"int32":       {Option{Name: "time_offset", Value: -3600}, 2, []byte{0xff, 0xff, 0xf1, 0xf0}},
+		"bool":        {Option{Name: "ip_forwarding", Value: true}, 19, []byte{1}},
+		"domain list": {Option{Name: "domain_search", Value: []interface{}{"a.b"}}, 119, []byte{1, 'a', 1, 'b', 0}},
+		"hex":         {Option{Code: 224, Type: OptionTypeHex, Value: "01:02:ab"}, 224, []byte{1, 2, 0xab}},
+	}
+
+	for name, test := range valid {
+		code, value, err := test.option.encode()
+		if err != nil {
+			t.Fatalf("[%v] could not encode option: %v", name, err)
+		}
+
+		if code != test.code || !reflect.DeepEqual(value, test.value) {
+			t.Fatalf("[%v] option was not encoded correctly: %d %v", name, code, value)
+		}
+	}
+
+	invalid := map[string]Option{
+		"unknown name":      {Name: "frobnicate", Value: "1"},
+		"mismatched code":   {Name: "mtu", Code: 27, Value: 1500},
+		"mismatched type":   {Name: "mtu", Type: OptionTypeUint32, Value: 1500},
+		"no type":           {Code: 224, Value: "1"},
+		"unknown type":      {Code: 224, Type: "float", Value: "1"},
+		"reserved":          {Code: 53, Type: OptionTypeUint8, Value: 1},
+		"bad ip":            {Name: "router", Value: []interface{}{"10.0.0.1", "abcdef"}},
+		"out of range":      {Name: "default_ttl", Value: 256},
+		"negative":          {Name: "mtu", Value: -1},
+		"not an integer":    {Name: "mtu", Value: "1500"},
+		"empty string":      {Name: "domain_name", Value: ""},
+		"not a bool":        {Name: "ip_forwarding", Value: "yes"},
+		"bad hex":           {Code: 224, Type: OptionTypeHex, Value: "zz"},
+		"empty value":       {Code: 224, Type: OptionTypeHex, Value: ""},
+		"bad domain":        {Name: "domain_search", Value: []interface{}{1}},
+		"code out of range": {Code: 255, Type: OptionTypeUint8, Value: 1},
+	}
+
+	for name, option := range invalid {
+		if _, _, err := option.encode(); err == nil {
+			t.Fatalf("[%v] invalid option did not error", name)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package dhcpd
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDDNSTimeout   = 5 * time.Second
+	defaultTSIGAlgorithm = "hmac-sha256"
+	// tsigFudge is the clock skew, in seconds, allowed by the server when it
+	// checks our TSIG signatures.
+	tsigFudge = 300
+)
+
+// DDNS configures dynamic DNS updates (RFC 2136) of the names of clients.
+// Their A and PTR records are added when they get a lease, and removed when
+// it is released or expires. A DHCID record (RFC 4701) is kept with each A
+// record, naming the client which owns the name, so names the server did not
+// give a client, and those of other clients, are never changed (RFC 4703).
+type DDNS struct {
+	// Server is the address of the primary server of the zones, with an
+	// optional port.
+	Server string `yaml:"server"`
+	// ForwardZone is the zone the A records are added to.
+	ForwardZone string `yaml:"forward_zone"`
+	// ReverseZone is the zone the PTR records are added to, such as
+	// 20.10.in-addr.arpa. Addresses outside it get none; empty disables PTR
+	// records.
+	ReverseZone string `yaml:"reverse_zone"`
+	// TTL is the time to live of the records. It defaults to a third of the
+	// lease duration, as RFC 4702 suggests.
+	TTL     time.Duration `yaml:"ttl"`
+	Timeout time.Duration `yaml:"timeout"`
+	// TSIG signs the updates; nil sends them unsigned.
+	TSIG *TSIG `yaml:"tsig"`
+}
+
+// TSIG is a TSIG key (RFC 8945).
+type TSIG struct {
+	Name string `yaml:"name"`
+	// Algorithm is one of hmac-md5, hmac-sha1, hmac-sha256 and hmac-sha512.
+	Algorithm string `yaml:"algorithm"`
+	// Secret is the base64 encoded key.
+	Secret string `yaml:"secret"`
+}
+
+func (t *TSIG) validateAndFix() error {
+	if _, ok := dns.IsDomainName(t.Name); !ok || t.Name == "" {
+		return errors.Errorf("invalid tsig key name %q", t.Name)
+	}
+
+	if t.Algorithm == "" {
+		t.Algorithm = defaultTSIGAlgorithm
+	}
+
+	switch dns.Fqdn(strings.ToLower(t.Algorithm)) {
+	case dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512:
+	default:
+		return errors.Errorf("invalid tsig algorithm %q", t.Algorithm)
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(t.Secret); err != nil || t.Secret == "" {
+		return errors.New("tsig secret is not base64 encoded")
+	}
+
+	return nil
+}
+
+func (d *DDNS) validateAndFix(leaseDuration time.Duration) error {
+	if d.Server == "" {
+		return errors.New("no server is configured")
+	}
+
+	if _, _, err := net.SplitHostPort(d.Server); err != nil {
+		d.Server = net.JoinHostPort(d.Server, "53")
+	}
+
+	if _, ok := dns.IsDomainName(d.ForwardZone); !ok || d.ForwardZone == "" {
+		return errors.Errorf("invalid forward zone %q", d.ForwardZone)
+	}
+
+	if d.ReverseZone != "" && !dns.IsSubDomain("in-addr.arpa.", dns.Fqdn(d.ReverseZone)) {
+		return errors.Errorf("reverse zone %q is not in in-addr.arpa", d.ReverseZone)
+	}
+
+	if d.TTL < 0 || d.Timeout < 0 {
+		return errors.New("ttl and timeout may not be negative")
+	}
+
+	if d.TTL == 0 {
+		d.TTL = leaseDuration / 3
+	}
+
+	if d.TTL < time.Second {
+		d.TTL = time.Second
+	}
+
+	if d.Timeout == 0 {
+		d.Timeout = defaultDDNSTimeout
+	}
+
+	if d.TSIG != nil {
+		return d.TSIG.validateAndFix()
+	}
+
+	return nil
+}
+
+// hostLabel returns the first label of the name, lowercased, or an empty
+// string if it is not a valid DNS label.
+func hostLabel(name string) string {
+	label := strings.ToLower(strings.SplitN(name, ".", 2)[0])
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return ""
+	}
+
+	for _, c := range label {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return ""
+		}
+	}
+
+	return label
+}
+
+// clientLabel returns the label the client goes by: the first of its FQDN,
+// or else of its hostname. It returns an empty string if the client has no
+// name which is a valid DNS label.
+func clientLabel(ci db.ClientInfo) string {
+	if ci.FQDN != "" {
+		return hostLabel(ci.FQDN)
+	}
+
+	return hostLabel(ci.Hostname)
+}
+
+// ddnsName returns the name the client is given in the zone, or an empty
+// string if it has none.
+func ddnsName(zone string, ci db.ClientInfo) string {
+	label := clientLabel(ci)
+	if label == "" {
+		return ""
+	}
+
+	return dns.Fqdn(label + "." + strings.TrimSuffix(zone, "."))
+}
+
+// ddnsFlags returns the client FQDN option flags the server acts on: those
+// the client sent, or the S flag if it sent none, so the server updates both
+// records.
+func ddnsFlags(ci db.ClientInfo) uint8 {
+	if ci.FQDN == "" {
+		return fqdnFlagS
+	}
+
+	return ci.FQDNFlags
+}
+
+// fqdnReply returns the client FQDN option (RFC 4702) for the reply to the
+// client, telling it which updates the server does, or nil if the client did
+// not send one.
+func (d DDNS) fqdnReply(m *dhcpv4.DHCPv4) []byte {
+	ci := clientInfo(m)
+	if ci == nil || ci.FQDN == "" {
+		return nil
+	}
+
+	// the server takes on the A record when asked to, and never leaves the
+	// PTR record to the client.
+	flags := ci.FQDNFlags & (fqdnFlagS | fqdnFlagN | fqdnFlagE)
+	opt := []byte{flags, 255, 255}
+
+	name := strings.TrimSuffix(ddnsName(d.ForwardZone, *ci), ".")
+	if flags&fqdnFlagE == 0 {
+		return append(opt, name...)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label != "" {
+			opt = append(append(opt, byte(len(label))), label...)
+		}
+	}
+
+	return append(opt, 0)
+}
+
+// ddnsUpdater is the lease event listener sending the updates.
+type ddnsUpdater struct {
+	config DDNS
+	client *dns.Client
+}
+
+func newDDNSUpdater(config DDNS) *ddnsUpdater {
+	client := &dns.Client{Timeout: config.Timeout}
+	if config.TSIG != nil {
+		client.TsigSecret = map[string]string{dns.Fqdn(strings.ToLower(config.TSIG.Name)): config.TSIG.Secret}
+	}
+
+	return &ddnsUpdater{config: config, client: client}
+}
+
+// records returns the A record the server keeps for the lease with its DHCID
+// record, and the PTR record; a and dhcid, or ptr, are nil if it keeps none.
+func (u *ddnsUpdater) records(l *db.Lease) (a, dhcid, ptr dns.RR) {
+	name := ddnsName(u.config.ForwardZone, l.ClientInfo)
+	flags := ddnsFlags(l.ClientInfo)
+	if name == "" || flags&fqdnFlagN != 0 {
+		return nil, nil, nil
+	}
+
+	ttl := uint32(u.config.TTL / time.Second)
+
+	if flags&fqdnFlagS != 0 {
+		dhcid = u.dhcid(l, name)
+		if dhcid != nil {
+			a = &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   l.IP(),
+			}
+		}
+	}
+
+	if u.config.ReverseZone != "" {
+		arpa, err := dns.ReverseAddr(l.IPAddress)
+		if err == nil && dns.IsSubDomain(dns.Fqdn(u.config.ReverseZone), arpa) {
+			ptr = &dns.PTR{
+				Hdr: dns.RR_Header{Name: arpa, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+				Ptr: name,
+			}
+		}
+	}
+
+	return a, dhcid, ptr
+}
+
+// dhcid returns the DHCID record (RFC 4701) for the name, identifying the
+// client holding the lease by its client identifier if it has one, and
+// otherwise by its hardware address. It returns nil if the name is too long.
+func (u *ddnsUpdater) dhcid(l *db.Lease, name string) dns.RR {
+	// the identifier type, and the identifier; the hardware type of the
+	// address is taken to be ethernet.
+	typ, id := []byte{0, 0}, []byte{1}
+	if cid, err := db.ParseClientID(l.ClientID); l.ClientID != "" && err == nil {
+		typ, id = []byte{0, 1}, cid
+	} else if mac, err := l.HardwareAddr(); err == nil {
+		id = append(id, mac...)
+	}
+
+	wire := make([]byte, 256)
+	n, err := dns.PackDomainName(strings.ToLower(name), wire, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+
+	// the digest type is SHA-256.
+	digest := sha256.Sum256(append(id, wire[:n]...))
+	rdata := append(append(typ, 1), digest[:]...)
+
+	return &dns.DHCID{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeDHCID, Class: dns.ClassINET, Ttl: uint32(u.config.TTL / time.Second)},
+		Digest: base64.StdEncoding.EncodeToString(rdata),
+	}
+}
+
+func (u *ddnsUpdater) LeaseEvent(e LeaseEvent) {
+	switch e.Type {
+	case EventCommit:
+		u.add(&e.Lease)
+	case EventRenew:
+		if e.Previous != nil {
+			a, dhcid, ptr := u.records(e.Previous)
+			newA, newDHCID, newPTR := u.records(&e.Lease)
+			if sameRR(a, newA) && sameRR(dhcid, newDHCID) && sameRR(ptr, newPTR) {
+				return
+			}
+
+			u.remove(e.Previous)
+		}
+
+		u.add(&e.Lease)
+	case EventRelease, EventExpire:
+		u.remove(&e.Lease)
+	}
+}
+
+func sameRR(a, b dns.RR) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.String() == b.String()
+}
+
+func (u *ddnsUpdater) add(l *db.Lease) {
+	a, dhcid, ptr := u.records(l)
+	if a != nil && !u.addName(a, dhcid) {
+		// the address is not to be named after another host.
+		return
+	}
+
+	if ptr != nil {
+		// the server owns the reverse names of the addresses it leases.
+		m := u.newUpdate(u.config.ReverseZone)
+		m.RemoveRRset([]dns.RR{dns.Copy(ptr)})
+		m.Insert([]dns.RR{dns.Copy(ptr)})
+		u.logUpdate("add", ptr, u.send(m))
+	}
+}
+
+// addName adds the A record if its name is not in use, with the DHCID record
+// of the client. If it is, the A record replaces those at the name only if the
+// DHCID record there is the client's own (RFC 4703 section 5.3.1). It returns
+// false if the record was not added.
+func (u *ddnsUpdater) addName(a, dhcid dns.RR) bool {
+	m := u.newUpdate(u.config.ForwardZone)
+	m.NameNotUsed([]dns.RR{dns.Copy(a)})
+	m.Insert([]dns.RR{dns.Copy(a), dns.Copy(dhcid)})
+
+	err := u.send(m)
+	if rcode, ok := errors.Cause(err).(rcodeError); ok && rcode == dns.RcodeYXDomain {
+		m = u.newUpdate(u.config.ForwardZone)
+		m.Used([]dns.RR{dns.Copy(dhcid)})
+		m.RemoveRRset([]dns.RR{dns.Copy(a)})
+		m.Insert([]dns.RR{dns.Copy(a)})
+
+		err = u.send(m)
+		if rcode, ok := errors.Cause(err).(rcodeError); ok && rcode == dns.RcodeNXRrset {
+			err = errors.New("the name is in use by another host")
+		}
+	}
+
+	u.logUpdate("add", a, err)
+	return err == nil
+}
+
+func (u *ddnsUpdater) remove(l *db.Lease) {
+	a, dhcid, ptr := u.records(l)
+	if a != nil {
+		// only the client's own records are removed (RFC 4703 section
+		// 5.5).
+		m := u.newUpdate(u.config.ForwardZone)
+		m.Used([]dns.RR{dns.Copy(dhcid)})
+		m.Remove([]dns.RR{dns.Copy(a), dns.Copy(dhcid)})
+
+		err := u.send(m)
+		if rcode, ok := errors.Cause(err).(rcodeError); ok && rcode == dns.RcodeNXRrset {
+			err = errors.New("the name is in use by another host")
+		}
+
+		u.logUpdate("remove", a, err)
+	}
+
+	if ptr != nil {
+		// the address may have been given to another client since.
+		m := u.newUpdate(u.config.ReverseZone)
+		m.Remove([]dns.RR{dns.Copy(ptr)})
+		u.logUpdate("remove", ptr, u.send(m))
+	}
+}
+
+func (u *ddnsUpdater) logUpdate(op string, rr dns.RR, err error) {
+	if err != nil {
+		logrus.Warnf("Could not %v DNS record [%v]: %v", op, rr, err)
+		return
+	}
+
+	logrus.Infof("DNS update: %v record [%v]", op, rr)
+}
+
+// rcodeError is the response code of an update which failed.
+type rcodeError int
+
+func (e rcodeError) Error() string {
+	return dns.RcodeToString[int(e)]
+}
+
+func (u *ddnsUpdater) newUpdate(zone string) *dns.Msg {
+	m := &dns.Msg{}
+	m.SetUpdate(dns.Fqdn(zone))
+	return m
+}
+
+// send sends the update, signing it if configured. An update the server
+// refused returns an rcodeError.
+func (u *ddnsUpdater) send(m *dns.Msg) error {
+	if t := u.config.TSIG; t != nil {
+		m.SetTsig(dns.Fqdn(strings.ToLower(t.Name)), dns.Fqdn(strings.ToLower(t.Algorithm)), tsigFudge, time.Now().Unix())
+	}
+
+	r, _, err := u.client.Exchange(m, u.config.Server)
+	if err != nil {
+		return errors.Wrapf(err, "could not reach dns server %v", u.config.Server)
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return errors.Wrapf(rcodeError(r.Rcode), "dns server %v answered", u.config.Server)
+	}
+
+	return nil
+}
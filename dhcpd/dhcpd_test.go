@@ -23,7 +23,8 @@ func TestRealClientACK(t *testing.T) {
 
 	config := Config{
 		Lease: Lease{
-			Duration: 5 * time.Second,
+			Duration:  5 * time.Second,
+			OfferHold: 5 * time.Second,
 		},
 		DNSServers: []string{
 			"10.0.0.1",
@@ -55,12 +56,10 @@ func TestRealClientACK(t *testing.T) {
 
 	time.Sleep(6 * time.Second) // ensure lease is expired
 
-	// I know this is especially shitty behavior and could be done better. I will
-	// add a shadow lease table eventually, just making this note so if anyone
-	// reads this code they don't think I'm a massive dimwit (at least for this
-	// reason). This is just a simpler solution; maintain the lease, or lose it.
+	// the expired lease is remembered in the history, so the same address is
+	// handed out again.
 	ip = testDHCP(t)
-	if !ip.Equal(net.ParseIP("10.0.20.51")) {
+	if !ip.Equal(net.ParseIP("10.0.20.50")) {
 		dumpInterfaces()
 		t.Fatalf("Was not the expected IP: was %v", ip)
 	}
@@ -74,7 +73,8 @@ func TestParallelAcquisition(t *testing.T) {
 
 	config := Config{
 		Lease: Lease{
-			Duration: 5 * time.Second,
+			Duration:  5 * time.Second,
+			OfferHold: 5 * time.Second,
 		},
 		SearchDomains: []string{"internal"},
 		DNSServers: []string{
@@ -111,7 +111,8 @@ func TestParallelAcquisition(t *testing.T) {
 				return
 			}
 
-			offer, ack, err := c.Request(context.Background())
+			// the search list is only sent to clients which ask for it
+			offer, ack, err := c.Request(context.Background(), dhcpv4.WithRequestedOptions(dhcpv4.OptionDNSDomainSearchList))
 			if err != nil {
 				errChan <- errors.Wrap(err, "could not complete request")
 				return
@@ -6,16 +6,27 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/vishvananda/netlink"
 )
 
 const defaultBridge = "testbridge0"
 
+// byMAC returns the client with the hardware address, for tests where db is
+// the database.
+func byMAC(mac net.HardwareAddr) db.Client {
+	return db.Client{MAC: mac}
+}
+
 var initialInterfaces = map[string]net.IP{"dhcpd0": net.ParseIP("10.0.20.1"), "dhclient0": nil}
 
 func addVethPair(name string, bridge *netlink.Bridge) error {
@@ -48,6 +59,333 @@ func addVethPair(name string, bridge *netlink.Bridge) error {
 	return nil
 }
 
+// fakeConn records packets written by the handler so ServeDHCP can be
+// exercised without sockets.
+type fakeConn struct {
+	mutex   sync.Mutex
+	packets []*dhcpv4.DHCPv4
+	raw     [][]byte
+	addrs   []net.Addr
+}
+
+func (fc *fakeConn) ReadFrom(p []byte) (int, net.Addr, error) { return 0, nil, io.EOF }
+func (fc *fakeConn) Close() error                             { return nil }
+func (fc *fakeConn) LocalAddr() net.Addr                      { return &net.UDPAddr{Port: 67} }
+func (fc *fakeConn) SetDeadline(time.Time) error              { return nil }
+func (fc *fakeConn) SetReadDeadline(time.Time) error          { return nil }
+func (fc *fakeConn) SetWriteDeadline(time.Time) error         { return nil }
+
+func (fc *fakeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	m, err := dhcpv4.FromBytes(p)
+	if err != nil {
+		return 0, err
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.packets = append(fc.packets, m)
+	fc.raw = append(fc.raw, append([]byte{}, p...))
+	fc.addrs = append(fc.addrs, addr)
+
+	return len(p), nil
+}
+
+// last returns the last packet written and its destination, or nil if
+// nothing was written.
+func (fc *fakeConn) last() (*dhcpv4.DHCPv4, net.Addr) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if len(fc.packets) == 0 {
+		return nil, nil
+	}
+
+	return fc.packets[len(fc.packets)-1], fc.addrs[len(fc.addrs)-1]
+}
+
+func (fc *fakeConn) reset() {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.packets = nil
+	fc.raw = nil
+	fc.addrs = nil
+}
+
+// fakeDNSWriter records the reply to a DNS query from the address, so
+// ServeDNS can be exercised from anywhere.
+type fakeDNSWriter struct {
+	addr  net.Addr
+	reply *dns.Msg
+}
+
+func (fw *fakeDNSWriter) LocalAddr() net.Addr       { return &net.UDPAddr{Port: 53} }
+func (fw *fakeDNSWriter) RemoteAddr() net.Addr      { return fw.addr }
+func (fw *fakeDNSWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+func (fw *fakeDNSWriter) Close() error              { return nil }
+func (fw *fakeDNSWriter) TsigStatus() error         { return nil }
+func (fw *fakeDNSWriter) TsigTimersOnly(bool)       {}
+func (fw *fakeDNSWriter) Hijack()                   {}
+
+func (fw *fakeDNSWriter) WriteMsg(m *dns.Msg) error {
+	fw.reply = m
+	return nil
+}
+
+// fakeDNSServer stands in for the primary server of the zones, keeping their
+// records and recording the dynamic updates it applies. Updates must be
+// signed with its TSIG key.
+type fakeDNSServer struct {
+	server  *dns.Server
+	updates chan *dns.Msg
+
+	mutex   sync.Mutex
+	records map[string][]dns.RR
+}
+
+func startFakeDNSServer(t *testing.T, keyName, secret string) *fakeDNSServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &fakeDNSServer{updates: make(chan *dns.Msg, 16), records: map[string][]dns.RR{}}
+	started := make(chan struct{})
+	fs.server = &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{dns.Fqdn(keyName): secret},
+		Handler:    dns.HandlerFunc(fs.serve),
+		// the default rejects updates
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go fs.server.ActivateAndServe()
+	<-started
+
+	return fs
+}
+
+func (fs *fakeDNSServer) addr() string {
+	return fs.server.PacketConn.LocalAddr().String()
+}
+
+func (fs *fakeDNSServer) serve(w dns.ResponseWriter, m *dns.Msg) {
+	r := &dns.Msg{}
+	r.SetReply(m)
+
+	if t := m.IsTsig(); t == nil || w.TsigStatus() != nil || m.Opcode != dns.OpcodeUpdate {
+		r.Rcode = dns.RcodeRefused
+	} else {
+		if r.Rcode = fs.apply(m); r.Rcode == dns.RcodeSuccess {
+			fs.updates <- m
+		}
+		r.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+
+	w.WriteMsg(r)
+}
+
+// apply checks the prerequisites of the update, and applies it if they hold.
+// It returns the rcode to answer. Only the prerequisites and updates the
+// server sends are understood.
+func (fs *fakeDNSServer) apply(m *dns.Msg) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for _, rr := range m.Answer {
+		h := rr.Header()
+		switch {
+		case h.Class == dns.ClassNONE && h.Rrtype == dns.TypeANY:
+			if len(fs.records[strings.ToLower(h.Name)]) != 0 {
+				return dns.RcodeYXDomain
+			}
+		case h.Class == dns.ClassINET:
+			if fs.find(rr) < 0 {
+				return dns.RcodeNXRrset
+			}
+		default:
+			return dns.RcodeNotImplemented
+		}
+	}
+
+	for _, rr := range m.Ns {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		switch h.Class {
+		case dns.ClassINET:
+			if fs.find(rr) < 0 {
+				fs.records[name] = append(fs.records[name], dns.Copy(rr))
+			}
+		case dns.ClassANY:
+			kept := []dns.RR{}
+			for _, have := range fs.records[name] {
+				if have.Header().Rrtype != h.Rrtype {
+					kept = append(kept, have)
+				}
+			}
+			fs.records[name] = kept
+		case dns.ClassNONE:
+			if i := fs.find(rr); i >= 0 {
+				fs.records[name] = append(fs.records[name][:i], fs.records[name][i+1:]...)
+			}
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+// find returns the index of the record among those of its name, ignoring its
+// class and TTL, or -1 if it is not in the zone.
+func (fs *fakeDNSServer) find(rr dns.RR) int {
+	rr = dns.Copy(rr)
+	rr.Header().Class = dns.ClassINET
+	for i, have := range fs.records[strings.ToLower(rr.Header().Name)] {
+		if dns.IsDuplicate(have, rr) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// add puts the record, given in zone file format, in the zone.
+func (fs *fakeDNSServer) add(t *testing.T, record string) {
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	name := strings.ToLower(rr.Header().Name)
+	fs.records[name] = append(fs.records[name], rr)
+}
+
+// lookup returns the data of the records of the type at the name.
+func (fs *fakeDNSServer) lookup(name string, rrtype uint16) []string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	data := []string{}
+	for _, rr := range fs.records[strings.ToLower(name)] {
+		if rr.Header().Rrtype == rrtype {
+			data = append(data, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+
+	return data
+}
+
+// expect fails the test unless the next update applied adds the record to
+// the zone, or removes it. The record is given as "name type data"; DHCID
+// records sent with it are not considered.
+func (fs *fakeDNSServer) expect(t *testing.T, zone string, add bool, record string) {
+	t.Helper()
+
+	var m *dns.Msg
+	select {
+	case m = <-fs.updates:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update was sent for %q", record)
+	}
+
+	if m.Question[0].Name != zone {
+		t.Fatalf("update for %q was sent to zone %v, not %v", record, m.Question[0].Name, zone)
+	}
+
+	var rr dns.RR
+	for _, r := range m.Ns {
+		if r.Header().Rrtype != dns.TypeDHCID {
+			rr = r
+		}
+	}
+
+	h := rr.Header()
+	if added := h.Class == dns.ClassINET; added != add {
+		t.Fatalf("update for %q was an add: %v, not %v: %v", record, added, add, m)
+	}
+
+	var data string
+	switch rr := rr.(type) {
+	case *dns.A:
+		data = rr.A.String()
+	case *dns.PTR:
+		data = rr.Ptr
+	}
+
+	if got := fmt.Sprintf("%v %v %v", h.Name, dns.TypeToString[h.Rrtype], data); got != record {
+		t.Fatalf("update was for %q, not %q", got, record)
+	}
+}
+
+// none fails the test if an update is sent.
+func (fs *fakeDNSServer) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case m := <-fs.updates:
+		t.Fatalf("unexpected update: %v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// setupFakeHandler creates a handler serving 10.0.20.1/24 without binding to
+// an interface.
+func setupFakeHandler(t *testing.T, config Config) (*Handler, *fakeConn) {
+	db, err := config.NewDB()
+	if err != nil {
+		t.Fatalf("Error initializing database: %v", err)
+	}
+
+	handler, err := NewHandler(&net.IPNet{IP: net.ParseIP("10.0.20.1"), Mask: net.IPv4Mask(255, 255, 255, 0)}, config, db)
+	if err != nil {
+		t.Fatalf("Error initializing handler: %v", err)
+	}
+
+	return handler, &fakeConn{}
+}
+
+func newRequest(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	m, err := dhcpv4.New(append([]dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest)}, modifiers...)...)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// acquireLease takes the client through discover and request, and returns
+// the ack. The modifiers are applied to the request.
+func acquireLease(t *testing.T, h *Handler, conn *fakeConn, mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, peer, discover)
+	offer, _ := conn.last()
+	conn.reset()
+	if offer == nil || offer.MessageType() != dhcpv4.MessageTypeOffer {
+		t.Fatal("no offer was sent")
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(mac, append([]dhcpv4.Modifier{
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	}, modifiers...)...))
+	ack, _ := conn.last()
+	conn.reset()
+	if ack == nil || ack.MessageType() != dhcpv4.MessageTypeAck {
+		t.Fatal("no ack was sent")
+	}
+
+	return ack
+}
+
 func setupTest(t *testing.T) *netlink.Bridge {
 	cleanupTest(t)
 
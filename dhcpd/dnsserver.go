@@ -0,0 +1,337 @@
+package dhcpd
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// dnsTTL is the time to live of the records answered; those of leases
+	// are cut to the time left on the lease.
+	dnsTTL            = 5 * time.Minute
+	dnsForwardTimeout = 2 * time.Second
+)
+
+// dnsForwardPort is the port of the DNS servers queries are forwarded to.
+var dnsForwardPort = "53"
+
+// ServeDNS answers A and PTR queries for the clients named in the search
+// domains, from the leases and host reservations. A client goes by the first
+// label of its FQDN or hostname, or by the hostname of its reservation. Other
+// queries are forwarded to the forwarders, for clients on the served networks
+// or this host only; others are refused.
+func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		m := &dns.Msg{}
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		w.WriteMsg(m)
+		return
+	}
+
+	h.configMutex.RLock()
+	answers, found := h.lookup(r.Question[0])
+	servers := h.forwarders
+	served := h.servesAddr(w.RemoteAddr())
+	h.configMutex.RUnlock()
+
+	var m *dns.Msg
+	switch {
+	case found:
+		m = &dns.Msg{}
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Answer = answers
+	case served:
+		m = forwardDNS(r, servers, w.RemoteAddr().Network())
+	default:
+		logrus.Warnf("Refusing to forward DNS query for %v from %v, which is not on a served network", r.Question[0].Name, w.RemoteAddr())
+		m = &dns.Msg{}
+		m.SetRcode(r, dns.RcodeRefused)
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		logrus.Errorf("Error replying to DNS query: %v", err)
+	}
+}
+
+// lookup returns the answers to the question, and true if the name is one we
+// know. Known names asked for other types get no answers. The config mutex
+// must be held.
+func (h *Handler) lookup(q dns.Question) ([]dns.RR, bool) {
+	if q.Qclass != dns.ClassINET {
+		return nil, false
+	}
+
+	name := strings.ToLower(dns.Fqdn(q.Name))
+	hdr := func(rrtype uint16, ttl uint32) dns.RR_Header {
+		return dns.RR_Header{Name: q.Name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	if ip := reverseIP(name); ip != nil {
+		target, ttl := h.nameOf(ip)
+		if target == "" {
+			return nil, false
+		}
+
+		if q.Qtype != dns.TypePTR {
+			return nil, true
+		}
+
+		return []dns.RR{&dns.PTR{Hdr: hdr(dns.TypePTR, ttl), Ptr: target}}, true
+	}
+
+	for _, zone := range h.zones() {
+		if dns.CountLabel(name) != dns.CountLabel(zone)+1 || !dns.IsSubDomain(zone, name) {
+			continue
+		}
+
+		answers := []dns.RR{}
+		for ip, ttl := range h.addressesOf(strings.SplitN(name, ".", 2)[0]) {
+			answers = append(answers, &dns.A{Hdr: hdr(dns.TypeA, ttl), A: net.ParseIP(ip).To4()})
+		}
+
+		if len(answers) == 0 {
+			return nil, false
+		}
+
+		if q.Qtype != dns.TypeA {
+			return nil, true
+		}
+
+		return answers, true
+	}
+
+	return nil, false
+}
+
+// servesAddr returns true if the address is on one of the served networks,
+// or of this host. The config mutex must be held.
+func (h *Handler) servesAddr(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	if ip.IsLoopback() {
+		return true
+	}
+
+	for _, s := range append([]*scope{h.local}, h.relayed...) {
+		if s.network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zones returns the search domains of the networks, lowercased and fully
+// qualified.
+func (h *Handler) zones() []string {
+	seen := map[string]bool{}
+	zones := []string{}
+	for _, s := range append([]*scope{h.local}, h.relayed...) {
+		for _, domain := range s.config.SearchDomains {
+			zone := strings.ToLower(dns.Fqdn(domain))
+			if !seen[zone] {
+				zones = append(zones, zone)
+				seen[zone] = true
+			}
+		}
+	}
+
+	return zones
+}
+
+// addressesOf returns the addresses of the clients going by the label, and
+// the time to live of each. A label given to host reservations names only
+// those; clients cannot take it. Otherwise it names the address of the client
+// which most recently took it, going by the end of the leases.
+func (h *Handler) addressesOf(label string) map[string]uint32 {
+	addrs := map[string]uint32{}
+	for _, host := range h.config.Hosts {
+		if host.Hostname != "" && hostLabel(host.Hostname) == label {
+			addrs[host.IP().String()] = uint32(dnsTTL / time.Second)
+		}
+	}
+
+	if len(addrs) != 0 {
+		return addrs
+	}
+
+	leases, err := h.db.ListLeasesByName(label)
+	if err != nil {
+		logrus.Errorf("Could not look up leases of %q: %v", label, err)
+		return addrs
+	}
+
+	var holder *db.Lease
+	var holderTTL uint32
+	for _, l := range leases {
+		if clientLabel(l.ClientInfo) != label {
+			continue
+		}
+
+		if ttl, ok := leaseTTL(l); ok && (holder == nil || l.LeaseEnd.After(holder.LeaseEnd)) {
+			holder, holderTTL = l, ttl
+		}
+	}
+
+	if holder != nil {
+		addrs[holder.IPAddress] = holderTTL
+	}
+
+	return addrs
+}
+
+// nameOf returns the name of the client with the address, in the first search
+// domain of its network, and its time to live. The name is empty if the
+// client has none.
+func (h *Handler) nameOf(ip net.IP) (string, uint32) {
+	zone := ""
+	for _, s := range append([]*scope{h.local}, h.relayed...) {
+		if s.network.Contains(ip) && len(s.config.SearchDomains) != 0 {
+			zone = strings.ToLower(dns.Fqdn(s.config.SearchDomains[0]))
+			break
+		}
+	}
+
+	if zone == "" {
+		return "", 0
+	}
+
+	for _, host := range h.config.Hosts {
+		if host.IP().Equal(ip) {
+			if label := hostLabel(host.Hostname); label != "" {
+				return label + "." + zone, uint32(dnsTTL / time.Second)
+			}
+		}
+	}
+
+	l, err := h.db.GetLeaseByIP(ip)
+	if err != nil {
+		return "", 0
+	}
+
+	label := clientLabel(l.ClientInfo)
+	if label == "" {
+		return "", 0
+	}
+
+	// the name must lead back to the address.
+	ttl, ok := h.addressesOf(label)[ip.String()]
+	if !ok {
+		return "", 0
+	}
+
+	return label + "." + zone, ttl
+}
+
+// leaseTTL returns the time to live of the lease's records, and false if it
+// is an offer or has expired.
+func leaseTTL(l *db.Lease) (uint32, bool) {
+	if l.Offered {
+		return 0, false
+	}
+
+	ttl := dnsTTL
+	if !l.Persistent && !l.Configured {
+		left := time.Until(l.LeaseEnd)
+		if left < time.Second {
+			return 0, false
+		}
+
+		if left < ttl {
+			ttl = left
+		}
+	}
+
+	return uint32(ttl / time.Second), true
+}
+
+// reverseIP returns the address named by the name in in-addr.arpa, or nil if
+// it names none.
+func reverseIP(name string) net.IP {
+	const suffix = ".in-addr.arpa."
+	if !strings.HasSuffix(name, suffix) {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	if len(parts) != net.IPv4len {
+		return nil
+	}
+
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	return net.ParseIP(strings.Join(parts, ".")).To4()
+}
+
+// forwardServers returns the DNS servers queries are forwarded to: the
+// forwarders if any are configured, or else the DNS servers which are not
+// this host. Clients are usually told to use ldhcpd as their DNS server, and
+// it must not forward queries to itself.
+func (h *Handler) forwardServers(config Config) []net.IP {
+	if len(config.Forwarders) != 0 {
+		servers := []net.IP{}
+		for _, srv := range config.Forwarders {
+			servers = append(servers, net.ParseIP(srv).To4())
+		}
+
+		return servers
+	}
+
+	own := map[string]bool{h.ip.String(): true}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				own[ipnet.IP.String()] = true
+			}
+		}
+	} else {
+		logrus.Warnf("Could not list the addresses of this host: %v", err)
+	}
+
+	servers := []net.IP{}
+	for _, srv := range config.DNS() {
+		if srv.IsLoopback() || srv.IsUnspecified() || own[srv.String()] {
+			logrus.Infof("Not forwarding DNS queries to %v, which is this host", srv)
+			continue
+		}
+
+		servers = append(servers, srv)
+	}
+
+	return servers
+}
+
+// forwardDNS sends the query to each server in turn over the network it came
+// in on, and returns the first answer. It answers SERVFAIL if none do.
+func forwardDNS(r *dns.Msg, servers []net.IP, network string) *dns.Msg {
+	c := &dns.Client{Net: network, Timeout: dnsForwardTimeout}
+	for _, srv := range servers {
+		rep, _, err := c.Exchange(r, net.JoinHostPort(srv.String(), dnsForwardPort))
+		if err != nil {
+			logrus.Warnf("Could not forward DNS query for %v to %v: %v", r.Question[0].Name, srv, err)
+			continue
+		}
+
+		return rep
+	}
+
+	m := &dns.Msg{}
+	m.SetRcode(r, dns.RcodeServerFailure)
+	return m
+}
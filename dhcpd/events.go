@@ -0,0 +1,120 @@
+package dhcpd
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Lease event types.
+const (
+	// EventCommit is a lease granted to a client selecting our offer.
+	EventCommit = "commit"
+	// EventRenew is a lease renewed or confirmed by its client.
+	EventRenew = "renew"
+	// EventRelease is a lease released or declined by its client.
+	EventRelease = "release"
+	// EventExpire is a lease purged once it expired.
+	EventExpire = "expire"
+)
+
+// eventQueueLen is how many events may wait for a listener before more are
+// dropped.
+const eventQueueLen = 256
+
+// LeaseEvent is a change to a lease.
+type LeaseEvent struct {
+	Type  string
+	Lease db.Lease
+	// Previous is the lease as it was before a renewal, and nil for other
+	// events.
+	Previous *db.Lease
+	Time     time.Time
+}
+
+// EventListener is told of lease events.
+type EventListener interface {
+	// LeaseEvent handles the event. Each listener is given the events in
+	// order, from a goroutine of its own, so it may block without holding up
+	// the handler.
+	LeaseEvent(e LeaseEvent)
+}
+
+// dispatcher hands lease events to the listeners without waiting for them.
+// The listeners are replaced when the configuration is reloaded.
+type dispatcher struct {
+	queues []chan LeaseEvent
+	mutex  sync.RWMutex
+}
+
+// setListeners replaces the listeners. The old listeners are left to finish
+// the events already queued for them.
+func (d *dispatcher) setListeners(listeners ...EventListener) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, q := range d.queues {
+		close(q)
+	}
+
+	d.queues = nil
+	for _, l := range listeners {
+		q := make(chan LeaseEvent, eventQueueLen)
+		go func(l EventListener) {
+			for e := range q {
+				l.LeaseEvent(e)
+			}
+		}(l)
+
+		d.queues = append(d.queues, q)
+	}
+}
+
+func (d *dispatcher) dispatch(e LeaseEvent) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for _, q := range d.queues {
+		select {
+		case q <- e:
+		default:
+			logrus.Warnf("Dropping %v event for mac [%v] ip [%v]: a listener is falling behind", e.Type, e.Lease.MACAddress, e.Lease.IPAddress)
+		}
+	}
+}
+
+// close stops the listeners once they finish the events queued for them.
+func (d *dispatcher) close() {
+	d.setListeners()
+}
+
+// leaseEvent tells the listeners of the event.
+func (h *Handler) leaseEvent(typ string, l, previous *db.Lease) {
+	h.events.dispatch(LeaseEvent{Type: typ, Lease: *l, Previous: previous, Time: time.Now()})
+}
+
+// expired is the database's expire hook.
+func (h *Handler) expired(leases []*db.Lease) {
+	for _, l := range leases {
+		logrus.Infof("Lease expired for mac [%v] ip [%v]", l.MACAddress, l.IPAddress)
+		h.leaseEvent(EventExpire, l, nil)
+	}
+}
+
+// releaseLease ends the client's lease on the ip, and tells the listeners
+// unless it was only an offer.
+func (h *Handler) releaseLease(c db.Client, ip net.IP) error {
+	l, err := h.db.GetLease(c)
+	if err := h.db.ReleaseLease(c, ip); err != nil {
+		return err
+	}
+
+	if err == nil && !l.Offered && l.IP().Equal(ip) {
+		h.leaseEvent(EventRelease, l, nil)
+	}
+
+	return nil
+}
@@ -6,20 +6,27 @@ import (
 	"time"
 
 	"github.com/erikh/ldhcpd/db"
-	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-type dhcpOptions map[dhcpv4.OptionCode]dhcpv4.OptionValue
+type dhcpOptions map[uint8][]byte
 
 // Handler is the dhpcd handler for serving requests.
 type Handler struct {
-	ip          net.IP
-	options     dhcpOptions
-	config      Config
-	db          *db.DB
-	allocator   *Allocator
+	ip              net.IP
+	network         *net.IPNet
+	config          Config
+	db              *db.DB
+	local           *scope
+	relayed         []*scope
+	hostsByMAC      map[string]*Host
+	hostsByClientID map[string]*Host
+	// forwarders are the DNS servers unanswered queries are forwarded to.
+	forwarders []net.IP
+	events     dispatcher
+	// configMutex is held for writing while the configuration is reloaded.
+	configMutex sync.RWMutex
 	closed      bool
 	closedMutex sync.RWMutex
 }
@@ -34,7 +41,17 @@ func (h *Handler) purgeLeases() {
 		}
 		h.closedMutex.RUnlock()
 
-		count, err := h.db.PurgeLeases(false)
+		count, err := h.db.PurgeOffers()
+		if err != nil {
+			logrus.Errorf("While purging offers: %v", err)
+			continue
+		}
+
+		if count != 0 {
+			logrus.Infof("Periodic purge of %d lapsed offers occurred", count)
+		}
+
+		count, err = h.db.PurgeLeases(false)
 		if err != nil {
 			logrus.Errorf("While purging leases: %v", err)
 			continue
@@ -43,6 +60,50 @@ func (h *Handler) purgeLeases() {
 		if count != 0 {
 			logrus.Infof("Periodic purge of %d expired leases occurred", count)
 		}
+
+		count, err = h.db.PurgeQuarantine()
+		if err != nil {
+			logrus.Errorf("While purging quarantined addresses: %v", err)
+			continue
+		}
+
+		if count != 0 {
+			logrus.Infof("Periodic purge of %d expired quarantined addresses occurred", count)
+		}
+
+		h.configMutex.RLock()
+		before := time.Now().Add(-h.config.Lease.History)
+		h.configMutex.RUnlock()
+
+		count, err = h.db.PruneHistory(before)
+		if err != nil {
+			logrus.Errorf("While pruning lease history: %v", err)
+			continue
+		}
+
+		if count != 0 {
+			logrus.Infof("Periodic prune of %d lease history records occurred", count)
+		}
+
+		h.configMutex.RLock()
+		h.saveCursors()
+		h.configMutex.RUnlock()
+	}
+}
+
+// saveCursors records the allocation cursors of the scopes in the database.
+// The config mutex must be held.
+func (h *Handler) saveCursors() {
+	if h.local == nil {
+		return
+	}
+
+	for _, s := range append([]*scope{h.local}, h.relayed...) {
+		for _, cs := range append([]*scope{s}, s.classes...) {
+			if err := cs.allocator.saveCursor(); err != nil {
+				logrus.Errorf("While saving allocation cursors: %v", err)
+			}
+		}
 	}
 }
 
@@ -82,34 +143,105 @@ func InterfaceIP(interfaceName string) (*net.IPNet, error) {
 
 // NewHandler creates a new dhcpd handler.
 func NewHandler(ip *net.IPNet, config Config, db *db.DB) (*Handler, error) {
-
-	alloc, err := NewAllocator(db, config, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "while initializing allocator")
+	h := &Handler{
+		ip:      ip.IP.To4(),
+		network: &net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask},
+		db:      db,
 	}
 
-	h := &Handler{
-		ip:        ip.IP.To4(),
-		config:    config,
-		db:        db,
-		allocator: alloc,
-		options: dhcpOptions{
-			dhcpv4.OptionSubnetMask:       dhcpv4.IP(ip.Mask),
-			dhcpv4.OptionRouter:           dhcpv4.IP(config.GatewayIP()),
-			dhcpv4.OptionDomainNameServer: dhcpv4.IPs(config.DNS()),
-		},
+	if err := h.configure(config); err != nil {
+		return nil, err
 	}
 
+	db.SetExpireHook(h.expired)
+
 	// FIXME this should be a toggle
 	go h.purgeLeases()
 
 	return h, nil
 }
 
+// Reload replaces the configuration of the handler. The database and
+// certificate are not changed; those need a restart.
+func (h *Handler) Reload(config Config) error {
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	if config.DBFile != h.config.DBFile {
+		logrus.Warnf("The database file changed to %v; it will be used after a restart", config.DBFile)
+	}
+
+	return h.configure(config)
+}
+
+// configure builds the scopes and host reservations of the configuration.
+// The handler is left unchanged if the scopes cannot be built.
+func (h *Handler) configure(config Config) error {
+	if err := config.validateNetwork(h.network); err != nil {
+		return err
+	}
+
+	// the new allocators carry on from the cursors of the old.
+	h.saveCursors()
+
+	local, err := newScope(h.network, config, h.db, nil)
+	if err != nil {
+		return err
+	}
+
+	relayed := []*scope{}
+	for _, subnet := range config.Subnets {
+		s, err := newScope(subnet.IPNet(), config.subnetConfig(subnet), h.db, nil)
+		if err != nil {
+			return errors.Wrapf(err, "while configuring subnet %v", subnet.Network)
+		}
+
+		relayed = append(relayed, s)
+	}
+
+	hostsByMAC := map[string]*Host{}
+	hostsByClientID := map[string]*Host{}
+	for i := range config.Hosts {
+		host := &config.Hosts[i]
+		if mac := host.HardwareAddr(); mac != nil {
+			hostsByMAC[mac.String()] = host
+		}
+
+		if id := host.clientID(); id != "" {
+			hostsByClientID[id] = host
+		}
+	}
+
+	h.config = config
+	h.local = local
+	h.relayed = relayed
+	h.hostsByMAC = hostsByMAC
+	h.hostsByClientID = hostsByClientID
+	h.forwarders = h.forwardServers(config)
+
+	listeners := []EventListener{}
+	if config.DDNS != nil {
+		listeners = append(listeners, newDDNSUpdater(*config.DDNS))
+	}
+
+	if len(config.Hooks) != 0 {
+		listeners = append(listeners, newHookRunner(config.Hooks, config.HookConcurrency))
+	}
+	h.events.setListeners(listeners...)
+
+	return h.reserveHosts()
+}
+
 // Close the handler
 func (h *Handler) Close() error {
 	h.closedMutex.Lock()
 	defer h.closedMutex.Unlock()
 	h.closed = true
+	h.events.close()
+
+	h.configMutex.RLock()
+	h.saveCursors()
+	h.configMutex.RUnlock()
+
 	return h.db.Close()
 }
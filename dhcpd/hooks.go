@@ -0,0 +1,246 @@
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHookTimeout     = 10 * time.Second
+	defaultHookConcurrency = 4
+)
+
+// Hook is a command run on lease events. It is told of the event by
+// LDHCPD_ environment variables, and as a JSON object on its standard input;
+// see hookEvent.
+type Hook struct {
+	// Command is the program and its arguments. It is not run by a shell.
+	Command []string `yaml:"command"`
+	// Events are the event types the hook runs on; all of them if empty.
+	Events []string `yaml:"events"`
+	// Timeout is how long the command may run before it is killed.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (h *Hook) validateAndFix() error {
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return errors.New("no command is configured")
+	}
+
+	for _, e := range h.Events {
+		switch e {
+		case EventCommit, EventRenew, EventRelease, EventExpire:
+		default:
+			return errors.Errorf("invalid event %q", e)
+		}
+	}
+
+	if h.Timeout < 0 {
+		return errors.New("timeout is negative")
+	}
+
+	if h.Timeout == 0 {
+		h.Timeout = defaultHookTimeout
+	}
+
+	return nil
+}
+
+func (h Hook) String() string {
+	return strings.Join(h.Command, " ")
+}
+
+// runsOn returns true if the hook runs on the event type.
+func (h Hook) runsOn(typ string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+
+	for _, e := range h.Events {
+		if e == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hookEvent is what hooks are told of a lease event. The times are in RFC
+// 3339 format.
+type hookEvent struct {
+	Event         string `json:"event"`
+	MACAddress    string `json:"mac"`
+	IPAddress     string `json:"ip"`
+	ClientID      string `json:"client_id"`
+	Hostname      string `json:"hostname"`
+	FQDN          string `json:"fqdn"`
+	LeaseEnd      string `json:"lease_end"`
+	LeaseGraceEnd string `json:"lease_grace_end"`
+	Time          string `json:"time"`
+}
+
+func newHookEvent(e LeaseEvent) hookEvent {
+	return hookEvent{
+		Event:         e.Type,
+		MACAddress:    e.Lease.MACAddress,
+		IPAddress:     e.Lease.IPAddress,
+		ClientID:      e.Lease.ClientID,
+		Hostname:      e.Lease.Hostname,
+		FQDN:          e.Lease.FQDN,
+		LeaseEnd:      e.Lease.LeaseEnd.Format(time.RFC3339),
+		LeaseGraceEnd: e.Lease.LeaseGraceEnd.Format(time.RFC3339),
+		Time:          e.Time.Format(time.RFC3339),
+	}
+}
+
+// env returns the event as environment variables.
+func (he hookEvent) env() []string {
+	return []string{
+		"LDHCPD_EVENT=" + he.Event,
+		"LDHCPD_MAC=" + he.MACAddress,
+		"LDHCPD_IP=" + he.IPAddress,
+		"LDHCPD_CLIENT_ID=" + he.ClientID,
+		"LDHCPD_HOSTNAME=" + he.Hostname,
+		"LDHCPD_FQDN=" + he.FQDN,
+		"LDHCPD_LEASE_END=" + he.LeaseEnd,
+		"LDHCPD_LEASE_GRACE_END=" + he.LeaseGraceEnd,
+		"LDHCPD_TIME=" + he.Time,
+	}
+}
+
+// hookRunner is the lease event listener running the hooks. The hooks of an
+// event run one after the other, once those of earlier events for the same
+// mac or ip address finished, so they see the events of a client and of an
+// address in order. At most cap(slots) commands run at once, and events are
+// dropped if eventQueueLen are waiting to run.
+type hookRunner struct {
+	hooks []Hook
+	slots chan struct{}
+
+	mutex   sync.Mutex
+	pending int
+	// last is the last job queued for each mac and ip address.
+	last map[string]*hookJob
+}
+
+// hookJob is the running of the hooks for an event.
+type hookJob struct {
+	event hookEvent
+	keys  []string
+	// after are the jobs which must finish first.
+	after []*hookJob
+	done  chan struct{}
+}
+
+func newHookRunner(hooks []Hook, concurrency int) *hookRunner {
+	return &hookRunner{hooks: hooks, slots: make(chan struct{}, concurrency), last: map[string]*hookJob{}}
+}
+
+func (hr *hookRunner) LeaseEvent(e LeaseEvent) {
+	job := &hookJob{
+		event: newHookEvent(e),
+		keys:  []string{"mac " + e.Lease.MACAddress, "ip " + e.Lease.IPAddress},
+		done:  make(chan struct{}),
+	}
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	if hr.pending >= eventQueueLen {
+		logrus.Warnf("Dropping %v event for mac [%v] ip [%v]: too many events are waiting for their hooks", e.Type, e.Lease.MACAddress, e.Lease.IPAddress)
+		return
+	}
+
+	for _, key := range job.keys {
+		if prev := hr.last[key]; prev != nil {
+			job.after = append(job.after, prev)
+		}
+		hr.last[key] = job
+	}
+
+	hr.pending++
+	go hr.run(job)
+}
+
+// run runs the hooks for the job once the jobs before it finished.
+func (hr *hookRunner) run(job *hookJob) {
+	for _, prev := range job.after {
+		<-prev.done
+	}
+
+	he := job.event
+	for _, hook := range hr.hooks {
+		if !hook.runsOn(he.Event) {
+			continue
+		}
+
+		hr.slots <- struct{}{}
+		if err := hook.run(he); err != nil {
+			logrus.Warnf("Hook [%v] failed on %v event for mac [%v] ip [%v]: %v", hook, he.Event, he.MACAddress, he.IPAddress, err)
+		}
+		<-hr.slots
+	}
+
+	hr.mutex.Lock()
+	for _, key := range job.keys {
+		if hr.last[key] == job {
+			delete(hr.last, key)
+		}
+	}
+	hr.pending--
+	hr.mutex.Unlock()
+
+	close(job.done)
+}
+
+// run runs the hook for the event, killing it if it runs too long. The
+// command runs in a process group of its own, and the commands it started are
+// killed with it.
+func (h Hook) run(he hookEvent) error {
+	input, err := json.Marshal(he)
+	if err != nil {
+		return errors.Wrap(err, "could not encode event")
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), he.env()...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// the output is only done with once every process holding it exited, so
+	// commands left in the background are killed too.
+	timer := time.AfterFunc(h.Timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+
+	err = cmd.Wait()
+	if !timer.Stop() {
+		return errors.Errorf("killed after %v", h.Timeout)
+	}
+
+	if err != nil {
+		if out.Len() != 0 {
+			return errors.Errorf("%v: %s", err, bytes.TrimSpace(out.Bytes()))
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Host is a host reservation: the address always given to the client with
+// the hardware address or client identifier (option 61). Reservations are
+// made as persistent leases when the configuration is loaded.
+type Host struct {
+	MACAddress string `yaml:"mac"`
+	// ClientID is a client identifier formatted as for `ldhcpctl set -i`.
+	// Hosts reserved by client identifier alone are only recognized with the
+	// client_id lease identity.
+	ClientID  string `yaml:"client_id"`
+	IPAddress string `yaml:"ip"`
+	// Hostname is sent as the host name option (12).
+	Hostname string `yaml:"hostname"`
+	// Options are laid over the options of the network the host is on.
+	Options []Option `yaml:"options"`
+	// NextServer, BootFile and IPXEBootFile override the boot configuration.
+	NextServer   string `yaml:"next_server"`
+	BootFile     string `yaml:"boot_file"`
+	IPXEBootFile string `yaml:"ipxe_boot_file"`
+}
+
+func (h Host) String() string {
+	if h.MACAddress != "" {
+		return fmt.Sprintf("mac [%v]", h.MACAddress)
+	}
+
+	return fmt.Sprintf("client id [%v]", h.ClientID)
+}
+
+// IP returns the parsed address of the host.
+func (h Host) IP() net.IP {
+	return net.ParseIP(h.IPAddress).To4()
+}
+
+// HardwareAddr returns the parsed hardware address of the host, or nil if it
+// is reserved by client identifier alone.
+func (h Host) HardwareAddr() net.HardwareAddr {
+	mac, _ := net.ParseMAC(h.MACAddress)
+	return mac
+}
+
+// clientID returns the client identifier formatted as it is stored, or an
+// empty string if there is none.
+func (h Host) clientID() string {
+	id, err := db.ParseClientID(h.ClientID)
+	if err != nil {
+		return ""
+	}
+
+	return db.FormatClientID(id)
+}
+
+// client returns the client the host's lease is held by.
+func (h Host) client() db.Client {
+	return db.Client{MAC: h.HardwareAddr(), ID: h.clientID()}
+}
+
+// holds returns true if the lease is the host's.
+func (h Host) holds(l *db.Lease) bool {
+	if mac := h.HardwareAddr(); mac != nil && l.MACAddress == mac.String() {
+		return true
+	}
+
+	return h.clientID() != "" && l.ClientID == h.clientID()
+}
+
+func (h Host) boot() db.Boot {
+	return db.Boot{NextServer: h.NextServer, BootFile: h.BootFile, IPXEBootFile: h.IPXEBootFile}
+}
+
+func (h Host) validate() error {
+	if h.MACAddress == "" && h.ClientID == "" {
+		return errors.New("host has neither a mac nor a client id")
+	}
+
+	if h.MACAddress != "" && h.HardwareAddr() == nil {
+		return errors.Errorf("invalid mac %q", h.MACAddress)
+	}
+
+	if h.ClientID != "" && h.clientID() == "" {
+		return errors.Errorf("invalid client id %q", h.ClientID)
+	}
+
+	if h.IP() == nil {
+		return errors.Errorf("invalid ip %q", h.IPAddress)
+	}
+
+	if strings.ContainsAny(h.Hostname, " \t\r\n") {
+		return errors.Errorf("invalid hostname %q", h.Hostname)
+	}
+
+	boot := Boot{NextServer: h.NextServer, Filename: h.BootFile, IPXEFilename: h.IPXEBootFile}
+	if err := boot.validate(); err != nil {
+		return err
+	}
+
+	return validateOptions(h.Options)
+}
+
+func validateHosts(hosts []Host, identity string) error {
+	seen := map[string]Host{}
+	for _, host := range hosts {
+		if err := host.validate(); err != nil {
+			return errors.Wrapf(err, "could not validate host %v", host)
+		}
+
+		if host.MACAddress == "" && identity != IdentityClientID {
+			return errors.Errorf("host %v is reserved by client id alone, which needs the %v lease identity", host, IdentityClientID)
+		}
+
+		keys := []string{"ip " + host.IP().String()}
+		if mac := host.HardwareAddr(); mac != nil {
+			keys = append(keys, "mac "+mac.String())
+		}
+
+		if id := host.clientID(); id != "" {
+			keys = append(keys, "client id "+id)
+		}
+
+		for _, k := range keys {
+			if other, ok := seen[k]; ok {
+				return errors.Errorf("hosts %v and %v are both reserved by %v", other, host, k)
+			}
+
+			seen[k] = host
+		}
+	}
+
+	return nil
+}
+
+// HostConflict is a host reservation which could not be made because its
+// address is leased to another client.
+type HostConflict struct {
+	Host  Host
+	Lease *db.Lease
+}
+
+func (hc HostConflict) String() string {
+	kind := "dynamically"
+	if hc.Lease.Persistent {
+		kind = "persistently"
+	}
+
+	return fmt.Sprintf("ip [%v] reserved for host %v is %v leased to mac [%v] until %v", hc.Host.IPAddress, hc.Host, kind, hc.Lease.MACAddress, hc.Lease.LeaseEnd.Format(time.RFC3339))
+}
+
+// reconcileHosts makes the configured host reservations into leases, and
+// removes those of hosts no longer configured. A reservation is not made
+// while its address is leased to another client; those are returned.
+func (h *Handler) reconcileHosts() ([]HostConflict, error) {
+	now := time.Now()
+	conflicts := []HostConflict{}
+	keep := []net.IP{}
+
+	for _, host := range h.config.Hosts {
+		c := host.client()
+
+		// a host reserved by client id alone has its mac recorded once it is
+		// seen; keep it.
+		if c.MAC == nil {
+			if l, err := h.db.GetLease(c); err == nil {
+				c.MAC = l.Client().MAC
+			}
+		}
+
+		l, err := h.db.GetLeaseByIP(host.IP())
+		if err == nil && !host.holds(l) && !l.Configured && (l.Persistent || (!l.Offered && l.LeaseEnd.After(now))) {
+			conflicts = append(conflicts, HostConflict{Host: host, Lease: l})
+			continue
+		}
+
+		leaseEnd := now.Add(h.config.Lease.Duration)
+		if err := h.db.SetReservation(c, host.IP(), host.boot(), leaseEnd, leaseEnd.Add(h.config.Lease.GracePeriod)); err != nil {
+			return conflicts, errors.Wrapf(err, "could not reserve ip [%v] for host %v", host.IPAddress, host)
+		}
+
+		keep = append(keep, host.IP())
+	}
+
+	count, err := h.db.PurgeReservations(keep)
+	if err != nil {
+		return conflicts, errors.Wrap(err, "could not remove reservations of hosts no longer configured")
+	}
+
+	if count != 0 {
+		logrus.Infof("Removed %d reservations of hosts no longer configured", count)
+	}
+
+	return conflicts, nil
+}
+
+// reserveHosts reconciles the host reservations and reports the conflicts.
+func (h *Handler) reserveHosts() error {
+	conflicts, err := h.reconcileHosts()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range conflicts {
+		logrus.Warnf("Host reservation conflict: %v", c)
+	}
+
+	if len(conflicts) != 0 {
+		logrus.Warnf("%d of %d host reservations could not be made; they will be retried when the configuration is reloaded", len(conflicts), len(h.config.Hosts))
+	} else if len(h.config.Hosts) != 0 {
+		logrus.Infof("Reserved addresses for %d hosts", len(h.config.Hosts))
+	}
+
+	return nil
+}
+
+// hostFor returns the host reservation of the client, or nil if it has none.
+func (h *Handler) hostFor(m *dhcpv4.DHCPv4) *Host {
+	if h.config.Lease.Identity == IdentityClientID {
+		if id := clientID(m); id != "" {
+			if host, ok := h.hostsByClientID[id]; ok {
+				return host
+			}
+		}
+	}
+
+	return h.hostsByMAC[m.ClientHWAddr.String()]
+}
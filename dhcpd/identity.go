@@ -0,0 +1,29 @@
+package dhcpd
+
+import (
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// clientID returns the formatted client identifier (option 61) of the
+// message, or an empty string if there is none.
+func clientID(m *dhcpv4.DHCPv4) string {
+	id := m.Options.Get(dhcpv4.OptionClientIdentifier)
+	if len(id) == 0 {
+		return ""
+	}
+
+	return db.FormatClientID(id)
+}
+
+// client returns the client sending the message: it is identified by its
+// client identifier, if it has one, when leases are identified that way, and
+// otherwise by its hardware address.
+func (h *Handler) client(m *dhcpv4.DHCPv4) db.Client {
+	c := db.Client{MAC: m.ClientHWAddr}
+	if h.config.Lease.Identity == IdentityClientID {
+		c.ID = clientID(m)
+	}
+
+	return c
+}
@@ -0,0 +1,266 @@
+package dhcpd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/pkg/errors"
+)
+
+// Option value types.
+const (
+	OptionTypeIP         = "ip"
+	OptionTypeIPList     = "ip-list"
+	OptionTypeString     = "string"
+	OptionTypeUint8      = "uint8"
+	OptionTypeUint16     = "uint16"
+	OptionTypeUint32     = "uint32"
+	OptionTypeInt32      = "int32"
+	OptionTypeBool       = "bool"
+	OptionTypeDomainList = "domain-list"
+	OptionTypeHex        = "hex"
+)
+
+type optionDefinition struct {
+	code uint8
+	typ  string
+}
+
+// namedOptions are the options which may be set by name instead of code.
+// Their type is implied.
+var namedOptions = map[string]optionDefinition{
+	"subnet_mask":          {1, OptionTypeIP},
+	"time_offset":          {2, OptionTypeInt32},
+	"router":               {3, OptionTypeIPList},
+	"time_servers":         {4, OptionTypeIPList},
+	"dns_servers":          {6, OptionTypeIPList},
+	"log_servers":          {7, OptionTypeIPList},
+	"hostname":             {12, OptionTypeString},
+	"domain_name":          {15, OptionTypeString},
+	"ip_forwarding":        {19, OptionTypeBool},
+	"default_ttl":          {23, OptionTypeUint8},
+	"mtu":                  {26, OptionTypeUint16},
+	"broadcast_address":    {28, OptionTypeIP},
+	"ntp_servers":          {42, OptionTypeIPList},
+	"netbios_name_servers": {44, OptionTypeIPList},
+	"netbios_node_type":    {46, OptionTypeUint8},
+	"renewal_time":         {58, OptionTypeUint32},
+	"rebinding_time":       {59, OptionTypeUint32},
+	"tftp_server_name":     {66, OptionTypeString},
+	"bootfile_name":        {67, OptionTypeString},
+	"smtp_servers":         {69, OptionTypeIPList},
+	"pop3_servers":         {70, OptionTypeIPList},
+	"posix_timezone":       {100, OptionTypeString},
+	"tzdb_timezone":        {101, OptionTypeString},
+	"domain_search":        {119, OptionTypeDomainList},
+}
+
+// reservedOptions are managed by the server and cannot be configured.
+var reservedOptions = map[uint8]bool{
+	dhcpv4.OptionPad.Code():                    true,
+	dhcpv4.OptionRequestedIPAddress.Code():     true,
+	dhcpv4.OptionIPAddressLeaseTime.Code():     true,
+	dhcpv4.OptionOptionOverload.Code():         true,
+	dhcpv4.OptionDHCPMessageType.Code():        true,
+	dhcpv4.OptionServerIdentifier.Code():       true,
+	dhcpv4.OptionParameterRequestList.Code():   true,
+	dhcpv4.OptionMessage.Code():                true,
+	dhcpv4.OptionMaximumDHCPMessageSize.Code(): true,
+	dhcpv4.OptionClientIdentifier.Code():       true,
+	dhcpv4.OptionRelayAgentInformation.Code():  true,
+	dhcpv4.OptionEnd.Code():                    true,
+}
+
+// Option is a DHCP option set in the configuration, by code or by name. The
+// type must be given for options set by code. Options set here take
+// precedence over those derived from the rest of the configuration.
+type Option struct {
+	Code int    `yaml:"code"`
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Value is a scalar, or a list for the list types.
+	Value interface{} `yaml:"value"`
+	// AlwaysSend sends the option to clients even if they do not ask for
+	// it; see Config.AlwaysSend.
+	AlwaysSend bool `yaml:"always_send"`
+}
+
+func (o Option) String() string {
+	if o.Name != "" {
+		return o.Name
+	}
+
+	return dhcpv4.GenericOptionCode(o.Code).String()
+}
+
+// definition resolves the code and type of the option.
+func (o Option) definition() (optionDefinition, error) {
+	if o.Name != "" {
+		def, ok := namedOptions[o.Name]
+		if !ok {
+			return def, errors.Errorf("unknown option name %q", o.Name)
+		}
+
+		if o.Code != 0 && o.Code != int(def.code) {
+			return def, errors.Errorf("option %q is code %d, not %d", o.Name, def.code, o.Code)
+		}
+
+		if o.Type != "" && o.Type != def.typ {
+			return def, errors.Errorf("option %q is of type %v, not %v", o.Name, def.typ, o.Type)
+		}
+
+		return def, nil
+	}
+
+	if o.Code <= 0 || o.Code >= math.MaxUint8 {
+		return optionDefinition{}, errors.Errorf("invalid option code %d", o.Code)
+	}
+
+	if o.Type == "" {
+		return optionDefinition{}, errors.Errorf("option %d needs a type", o.Code)
+	}
+
+	return optionDefinition{code: uint8(o.Code), typ: o.Type}, nil
+}
+
+// encode returns the option code and its value as sent on the wire.
+func (o Option) encode() (uint8, []byte, error) {
+	def, err := o.definition()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if reservedOptions[def.code] {
+		return 0, nil, errors.Errorf("option %d is managed by the server and cannot be set", def.code)
+	}
+
+	var b []byte
+
+	switch def.typ {
+	case OptionTypeIP:
+		b, err = encodeIPs([]interface{}{o.Value})
+	case OptionTypeIPList:
+		b, err = encodeIPs(optionList(o.Value))
+	case OptionTypeString:
+		s, ok := o.Value.(string)
+		if !ok || s == "" {
+			return 0, nil, errors.New("value is not a string")
+		}
+		b = []byte(s)
+	case OptionTypeUint8:
+		b, err = encodeInt(o.Value, 0, math.MaxUint8, 1)
+	case OptionTypeUint16:
+		b, err = encodeInt(o.Value, 0, math.MaxUint16, 2)
+	case OptionTypeUint32:
+		b, err = encodeInt(o.Value, 0, math.MaxUint32, 4)
+	case OptionTypeInt32:
+		b, err = encodeInt(o.Value, math.MinInt32, math.MaxInt32, 4)
+	case OptionTypeBool:
+		v, ok := o.Value.(bool)
+		if !ok {
+			return 0, nil, errors.New("value is not a bool")
+		}
+
+		b = []byte{0}
+		if v {
+			b[0] = 1
+		}
+	case OptionTypeDomainList:
+		domains := []string{}
+		for _, v := range optionList(o.Value) {
+			s, ok := v.(string)
+			if !ok || s == "" {
+				return 0, nil, errors.Errorf("invalid domain %v", v)
+			}
+
+			domains = append(domains, s)
+		}
+
+		b = (&rfc1035label.Labels{Labels: domains}).ToBytes()
+	case OptionTypeHex:
+		s, ok := o.Value.(string)
+		if !ok {
+			return 0, nil, errors.New("value is not a string")
+		}
+
+		b, err = hex.DecodeString(strings.Replace(s, ":", "", -1))
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "invalid hex value")
+		}
+	default:
+		return 0, nil, errors.Errorf("unknown type %q", def.typ)
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(b) == 0 {
+		return 0, nil, errors.New("value is empty")
+	}
+
+	return def.code, b, nil
+}
+
+// optionList returns the list value, or a list of the scalar value.
+func optionList(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+
+	if list, ok := value.([]string); ok {
+		values := []interface{}{}
+		for _, v := range list {
+			values = append(values, v)
+		}
+		return values
+	}
+
+	return []interface{}{value}
+}
+
+func encodeIPs(values []interface{}) ([]byte, error) {
+	b := []byte{}
+	for _, v := range values {
+		s, _ := v.(string)
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, errors.Errorf("invalid ip %v", v)
+		}
+
+		b = append(b, ip...)
+	}
+
+	return b, nil
+}
+
+func encodeInt(value interface{}, min, max int64, size int) ([]byte, error) {
+	var i int64
+
+	switch v := value.(type) {
+	case int:
+		i = int64(v)
+	case int64:
+		i = v
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, errors.Errorf("value %d is out of range", v)
+		}
+		i = int64(v)
+	default:
+		return nil, errors.Errorf("value %v is not an integer", value)
+	}
+
+	if i < min || i > max {
+		return nil, errors.Errorf("value %d is out of range", i)
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b[8-size:], nil
+}
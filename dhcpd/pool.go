@@ -0,0 +1,206 @@
+package dhcpd
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/pkg/errors"
+)
+
+// pool is the set of addresses allocated from dynamically: the dynamic
+// ranges, in address order, less the excluded addresses.
+type pool struct {
+	ranges   [][2]net.IP
+	excluded []*net.IPNet
+}
+
+// newPool makes the pool of the ranges and exclusions, which must be valid.
+func newPool(ranges []Range, exclude []string) *pool {
+	p := &pool{}
+
+	for _, r := range ranges {
+		from, to := r.Dimensions()
+		p.ranges = append(p.ranges, [2]net.IP{from, to})
+	}
+
+	sort.Slice(p.ranges, func(i, j int) bool {
+		return dhcp4.IPLess(p.ranges[i][0], p.ranges[j][0])
+	})
+
+	for _, e := range exclude {
+		network, _ := parseExclude(e)
+		p.excluded = append(p.excluded, network)
+	}
+
+	return p
+}
+
+// String names the pool by its ranges, in address order.
+func (p *pool) String() string {
+	ranges := []string{}
+	for _, r := range p.ranges {
+		ranges = append(ranges, r[0].String()+"-"+r[1].String())
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+// first returns the lowest address in the ranges.
+func (p *pool) first() net.IP {
+	return p.ranges[0][0]
+}
+
+// next returns the address following ip in the ranges. It wraps around to
+// the first address past the end, and returns true if it did.
+func (p *pool) next(ip net.IP) (net.IP, bool) {
+	ip = dhcp4.IPAdd(ip, 1)
+	for _, r := range p.ranges {
+		if dhcp4.IPLess(ip, r[0]) {
+			return r[0], false
+		}
+
+		if dhcp4.IPInRange(r[0], r[1], ip) {
+			return ip, false
+		}
+	}
+
+	return p.first(), true
+}
+
+// segments returns the ranges in the order they are walked from start, which
+// must be in the pool: from start to the end of its range, the ranges after
+// it, wrapping around, and then the beginning of its range.
+func (p *pool) segments(start net.IP) [][2]net.IP {
+	i := 0
+	for j, r := range p.ranges {
+		if dhcp4.IPInRange(r[0], r[1], start) {
+			i = j
+			break
+		}
+	}
+
+	segments := [][2]net.IP{{start, p.ranges[i][1]}}
+	for j := 1; j < len(p.ranges); j++ {
+		segments = append(segments, p.ranges[(i+j)%len(p.ranges)])
+	}
+
+	if !start.Equal(p.ranges[i][0]) {
+		segments = append(segments, [2]net.IP{p.ranges[i][0], dhcp4.IPAdd(start, -1)})
+	}
+
+	return segments
+}
+
+// size returns the count of addresses in the ranges, excluded or not.
+func (p *pool) size() uint64 {
+	n := uint64(0)
+	for _, r := range p.ranges {
+		n += rangeLen(r)
+	}
+
+	return n
+}
+
+// at returns the address at the index into the ranges, which must be less
+// than size.
+func (p *pool) at(i uint64) net.IP {
+	for _, r := range p.ranges {
+		if i < rangeLen(r) {
+			return dhcp4.IPAdd(r[0], int(i))
+		}
+
+		i -= rangeLen(r)
+	}
+
+	return nil
+}
+
+func rangeLen(r [2]net.IP) uint64 {
+	return uint64(binary.BigEndian.Uint32(r[1])-binary.BigEndian.Uint32(r[0])) + 1
+}
+
+// contains returns true if the ip may be allocated from the pool.
+func (p *pool) contains(ip net.IP) bool {
+	for _, r := range p.ranges {
+		if dhcp4.IPInRange(r[0], r[1], ip) {
+			return !p.isExcluded(ip)
+		}
+	}
+
+	return false
+}
+
+func (p *pool) isExcluded(ip net.IP) bool {
+	for _, network := range p.excluded {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseExclude parses an exclusion: a single address, or a network in CIDR
+// notation.
+func parseExclude(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil || network.IP.To4() == nil {
+			return nil, errors.Errorf("invalid exclusion %q", s)
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, errors.Errorf("invalid exclusion %q", s)
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, nil
+}
+
+// validateRanges checks the dynamic ranges and exclusions of a network.
+// There must be at least one range, and they may not overlap.
+func validateRanges(ranges []Range, exclude []string) error {
+	if len(ranges) == 0 {
+		return errors.New("no dynamic range is configured")
+	}
+
+	for i, r := range ranges {
+		if err := r.validate(); err != nil {
+			return err
+		}
+
+		from, to := r.Dimensions()
+		for _, other := range ranges[:i] {
+			otherFrom, otherTo := other.Dimensions()
+			if !dhcp4.IPLess(to, otherFrom) && !dhcp4.IPLess(otherTo, from) {
+				return errors.Errorf("dynamic range %v overlaps dynamic range %v", r, other)
+			}
+		}
+	}
+
+	for _, e := range exclude {
+		if _, err := parseExclude(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rangesWithin checks the dynamic ranges lie within the network.
+func rangesWithin(ranges []Range, network *net.IPNet) error {
+	for _, r := range ranges {
+		from, to := r.Dimensions()
+		if !network.Contains(from) || !network.Contains(to) {
+			return errors.Errorf("dynamic range %v is outside of network %v", r, network)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package dhcpd
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Probe methods.
+const (
+	// ProbeICMP sends an ICMP echo request to the address.
+	ProbeICMP = "icmp"
+)
+
+// Probe is how an address is checked for use before it is offered, to catch
+// hosts configured by hand with an address in the dynamic ranges.
+type Probe struct {
+	// Method is the probe method, or empty not to probe.
+	Method string `yaml:"method"`
+	// Timeout is how long to wait for an answer.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (p Probe) validate() error {
+	switch p.Method {
+	case "", ProbeICMP:
+	default:
+		return errors.Errorf("invalid probe method %q", p.Method)
+	}
+
+	if p.Timeout < 0 {
+		return errors.New("probe timeout is negative")
+	}
+
+	return nil
+}
+
+// Prober checks whether an address is in use.
+type Prober interface {
+	// Probe returns true if the address answers within the timeout.
+	Probe(ip net.IP, timeout time.Duration) (bool, error)
+}
+
+// newProber makes the prober for the method, or returns nil if there is none.
+func newProber(method string) Prober {
+	switch method {
+	case ProbeICMP:
+		return &icmpProber{id: os.Getpid() & 0xffff}
+	default:
+		return nil
+	}
+}
+
+// icmpProber pings the address. It needs a raw socket, and so root or
+// CAP_NET_RAW.
+type icmpProber struct {
+	id  int
+	seq uint32
+}
+
+func (p *icmpProber) Probe(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, errors.Wrap(err, "could not listen for icmp")
+	}
+	defer conn.Close()
+
+	seq := int(atomic.AddUint32(&p.seq, 1) & 0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: []byte("ldhcpd")},
+	}
+
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return false, errors.Wrap(err, "could not make icmp echo request")
+	}
+
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		return false, errors.Wrapf(err, "could not send icmp echo request to ip [%v]", ip)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+
+	// the socket sees all icmp traffic to the host; only our reply counts.
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return false, nil
+			}
+
+			return false, errors.Wrap(err, "could not read icmp echo reply")
+		}
+
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == p.id && echo.Seq == seq {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package dhcpd
+
+import (
+	"net"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// relayInfo returns the relay agent information (option 82) the relay agent
+// attached to the message, or nil if there is none. Replies echo the option
+// back to the relay; dhcpv4.NewReplyFromRequest takes care of that.
+func relayInfo(m *dhcpv4.DHCPv4) *db.RelayInfo {
+	opts := m.RelayAgentInfo()
+	if opts == nil {
+		return nil
+	}
+
+	return &db.RelayInfo{
+		CircuitID:    string(opts.Get(dhcpv4.AgentCircuitIDSubOption)),
+		RemoteID:     string(opts.Get(dhcpv4.AgentRemoteIDSubOption)),
+		SubscriberID: string(opts.Get(dhcpv4.SubscriberIDSubOption)),
+	}
+}
+
+// linkSelection returns the address from the link selection sub-option (RFC
+// 3527), which names the client's subnet when the relay agent's own address
+// (giaddr) is not on it. It returns nil if the sub-option is not present.
+func linkSelection(m *dhcpv4.DHCPv4) net.IP {
+	opts := m.RelayAgentInfo()
+	if opts == nil {
+		return nil
+	}
+
+	ip := net.IP(opts.Get(dhcpv4.LinkSelectionSubOption))
+	if len(ip) != net.IPv4len {
+		return nil
+	}
+
+	return ip
+}
+
+// pinFor returns the scope's relay pin matching the relay agent information,
+// or nil if there is none.
+func (s *scope) pinFor(ri *db.RelayInfo) *RelayPin {
+	for i := range s.pins {
+		if s.pins[i].matches(ri) {
+			return &s.pins[i]
+		}
+	}
+
+	return nil
+}
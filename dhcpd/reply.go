@@ -0,0 +1,232 @@
+package dhcpd
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minMessageSize is the size of message every client must accept (RFC
+	// 2131 section 2), and what we assume if it does not tell us otherwise.
+	minMessageSize = 576
+	// ipUDPHeaderLen is the part of the maximum message size (option 57)
+	// taken by the IP and UDP headers.
+	ipUDPHeaderLen = 28
+	// optionsOffset is where the options start in a message: after the fixed
+	// fields and magic cookie.
+	optionsOffset = 240
+	snameOffset   = 44
+	snameLen      = 64
+	fileOffset    = 108
+	fileLen       = 128
+	bootpMinLen   = 300
+)
+
+// Option overload (option 52) values, RFC 2132 section 9.3.
+const (
+	overloadFile  = 1
+	overloadSname = 2
+)
+
+// mandatoryOptions are always sent: the client needs them to make sense of
+// the reply at all, or RFC 3046, RFC 4702 and RFC 6842 require them in
+// answer to the client's.
+var mandatoryOptions = []dhcpv4.OptionCode{
+	dhcpv4.OptionDHCPMessageType,
+	dhcpv4.OptionServerIdentifier,
+	dhcpv4.OptionIPAddressLeaseTime,
+	dhcpv4.OptionRenewTimeValue,
+	dhcpv4.OptionRebindingTimeValue,
+	dhcpv4.OptionMessage,
+	dhcpv4.OptionClientIdentifier,
+	dhcpv4.OptionRelayAgentInformation,
+	dhcpv4.OptionFQDN,
+}
+
+// replyOptions returns the codes of the reply's options to send, most
+// important first: the mandatory options, those the client asked for in
+// its parameter request list (option 55) in the order it asked, then those
+// configured to always be sent. A client without a parameter request list
+// gets everything.
+func (h *Handler) replyOptions(m, rep *dhcpv4.DHCPv4) []uint8 {
+	config := h.config
+	if s := h.scopeFor(m); s != nil {
+		config = s.config
+	}
+
+	codes := []uint8{}
+	seen := map[uint8]bool{}
+	add := func(code uint8) {
+		if _, ok := rep.Options[code]; ok && !seen[code] {
+			codes = append(codes, code)
+			seen[code] = true
+		}
+	}
+
+	for _, code := range mandatoryOptions {
+		add(code.Code())
+	}
+
+	prl := m.ParameterRequestList()
+	for _, code := range prl {
+		add(code.Code())
+	}
+
+	for _, code := range config.AlwaysSend {
+		add(uint8(code))
+	}
+
+	options := config.Options
+	if host := h.hostFor(m); host != nil {
+		options = append(append([]Option{}, options...), host.Options...)
+	}
+
+	for _, o := range options {
+		if code, _, err := o.encode(); err == nil && o.AlwaysSend {
+			add(code)
+		}
+	}
+
+	if prl == nil {
+		for code := 0; code <= math.MaxUint8; code++ {
+			add(uint8(code))
+		}
+	}
+
+	return codes
+}
+
+// marshalReply encodes the reply to the request, sending only the options
+// the client should get (see replyOptions) and fitting them into the
+// maximum message size it accepts. Options which do not fit in the options
+// field go into the unused sname and file fields with option overload (RFC
+// 2132 section 9.3); those that do not fit at all are left out, least
+// important first.
+func (h *Handler) marshalReply(m, rep *dhcpv4.DHCPv4) []byte {
+	size := minMessageSize
+	if mms, err := m.MaxMessageSize(); err == nil && int(mms) > size {
+		size = int(mms)
+	}
+
+	// every area ends with an end option.
+	main := &optionArea{room: size - ipUDPHeaderLen - optionsOffset - 1}
+	file := &optionArea{}
+	sname := &optionArea{}
+
+	codes := h.replyOptions(m, rep)
+	if encodedLen(rep, codes) > main.room {
+		// room for the overload option itself
+		main.room -= 3
+		if rep.BootFileName == "" {
+			file.room = fileLen - 1
+		}
+
+		if rep.ServerHostName == "" {
+			sname.room = snameLen - 1
+		}
+	}
+
+	for _, code := range codes {
+		opt := encodeOption(code, rep.Options[code])
+
+		switch {
+		case main.fit(opt):
+		case file.fit(opt):
+		case sname.fit(opt):
+		default:
+			logrus.Warnf("Leaving option %v out of reply to mac [%v]: it does not fit in %d bytes", dhcpv4.GenericOptionCode(code), m.ClientHWAddr, size)
+		}
+	}
+
+	overload := uint8(0)
+	if file.buf.Len() != 0 {
+		overload |= overloadFile
+	}
+
+	if sname.buf.Len() != 0 {
+		overload |= overloadSname
+	}
+
+	if overload != 0 {
+		main.buf.Write([]byte{dhcpv4.OptionOptionOverload.Code(), 1, overload})
+	}
+
+	// the fixed fields and magic cookie are encoded as usual.
+	b := append([]byte{}, rep.ToBytes()[:optionsOffset]...)
+
+	if overload&overloadFile != 0 {
+		copy(b[fileOffset:fileOffset+fileLen], file.terminated(fileLen))
+	}
+
+	if overload&overloadSname != 0 {
+		copy(b[snameOffset:snameOffset+snameLen], sname.terminated(snameLen))
+	}
+
+	b = append(b, main.buf.Bytes()...)
+	b = append(b, dhcpv4.OptionEnd.Code())
+
+	// BOOTP clients and relays expect at least 300 bytes; see
+	// dhcpv4.DHCPv4.ToBytes.
+	if len(b) < bootpMinLen {
+		b = append(b, make([]byte, bootpMinLen-len(b))...)
+	}
+
+	return b
+}
+
+// optionArea is a part of the message options are placed in.
+type optionArea struct {
+	buf  bytes.Buffer
+	room int
+}
+
+// fit places the encoded option in the area if there is room for it.
+func (a *optionArea) fit(opt []byte) bool {
+	if len(opt) > a.room-a.buf.Len() {
+		return false
+	}
+
+	a.buf.Write(opt)
+	return true
+}
+
+// terminated returns the area's options ended by an end option and padded
+// to its size.
+func (a *optionArea) terminated(size int) []byte {
+	b := make([]byte, size)
+	n := copy(b, a.buf.Bytes())
+	b[n] = dhcpv4.OptionEnd.Code()
+	return b
+}
+
+// encodeOption encodes an option, splitting values longer than an option
+// can hold into several options as RFC 3396 describes.
+func encodeOption(code uint8, value []byte) []byte {
+	b := []byte{}
+	for {
+		n := len(value)
+		if n > math.MaxUint8 {
+			n = math.MaxUint8
+		}
+
+		b = append(b, code, uint8(n))
+		b = append(b, value[:n]...)
+		value = value[n:]
+
+		if len(value) == 0 {
+			return b
+		}
+	}
+}
+
+func encodedLen(rep *dhcpv4.DHCPv4, codes []uint8) int {
+	l := 0
+	for _, code := range codes {
+		l += len(encodeOption(code, rep.Options[code]))
+	}
+
+	return l
+}
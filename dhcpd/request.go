@@ -0,0 +1,238 @@
+package dhcpd
+
+import (
+	"net"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus"
+)
+
+// requestState is the client state a DHCPREQUEST was generated in, per RFC
+// 2131 section 4.3.2.
+type requestState int
+
+const (
+	requestStateUnknown requestState = iota
+	requestStateSelecting
+	requestStateInitReboot
+	// requestStateRenewing covers both RENEWING and REBINDING. They differ
+	// only in whether the request was unicast or broadcast, which the listener
+	// does not tell us, and are served identically.
+	requestStateRenewing
+)
+
+func (rs requestState) String() string {
+	switch rs {
+	case requestStateSelecting:
+		return "SELECTING"
+	case requestStateInitReboot:
+		return "INIT-REBOOT"
+	case requestStateRenewing:
+		return "RENEWING/REBINDING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func unspecified(ip net.IP) bool {
+	return ip == nil || ip.IsUnspecified()
+}
+
+// classifyRequest determines the client state from the server identifier,
+// requested address and ciaddr fields.
+func classifyRequest(m *dhcpv4.DHCPv4) requestState {
+	switch {
+	case !unspecified(m.ServerIdentifier()):
+		return requestStateSelecting
+	case !unspecified(m.RequestedIPAddress()):
+		return requestStateInitReboot
+	case !unspecified(m.ClientIPAddr):
+		return requestStateRenewing
+	default:
+		return requestStateUnknown
+	}
+}
+
+// sendACK acknowledges the lease on the ip, and tells the event listeners.
+// previous is the lease the client is renewing or confirming, or nil if it is
+// committing to a new one.
+func (h *Handler) sendACK(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4, ip net.IP, leaseTime time.Duration, previous *db.Lease) {
+	rep, err := h.configureReply(s, m, dhcpv4.MessageTypeAck)
+	if err != nil {
+		logrus.Errorf("While configuring request reply: %v", err)
+		return
+	}
+
+	rep.YourIPAddr = ip
+	rep.UpdateOption(dhcpv4.OptIPAddressLeaseTime(leaseTime))
+
+	c := h.client(m)
+	if id := clientID(m); id != "" {
+		if err := h.db.SetClientID(c, id); err != nil {
+			logrus.Warnf("Could not record client id for mac [%v]: %v", m.ClientHWAddr, err)
+		}
+	}
+
+	// renewals are unicast to us without passing through the relay agent;
+	// keep what it told us earlier.
+	if ri := relayInfo(m); ri != nil {
+		if err := h.db.SetRelayInfo(c, *ri); err != nil {
+			logrus.Warnf("Could not record relay agent information for mac [%v]: %v", m.ClientHWAddr, err)
+		}
+	}
+
+	// clients need not repeat these when renewing.
+	if ci := clientInfo(m); ci != nil {
+		if err := h.db.SetClientInfo(c, *ci); err != nil {
+			logrus.Warnf("Could not record client information for mac [%v]: %v", m.ClientHWAddr, err)
+		}
+	}
+
+	if l, err := h.db.GetLease(c); err == nil {
+		if previous == nil {
+			h.leaseEvent(EventCommit, l, nil)
+		} else {
+			h.leaseEvent(EventRenew, l, previous)
+		}
+	}
+
+	if _, err := conn.WriteTo(h.marshalReply(m, rep), replyAddr(m, peer)); err != nil {
+		logrus.Errorf("Error replying to DHCP request: %v", err)
+	}
+}
+
+func (h *Handler) serveRequest(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4) {
+	state := classifyRequest(m)
+	logrus.Infof("received request for %v from %v in state %v", m.RequestedIPAddress(), m.ClientHWAddr, state)
+
+	switch state {
+	case requestStateSelecting:
+		h.serveSelecting(conn, peer, s, m)
+	case requestStateInitReboot:
+		h.serveInitReboot(conn, peer, s, m)
+	case requestStateRenewing:
+		h.serveRenewing(conn, peer, s, m)
+	default:
+		logrus.Warnf("Ignoring malformed request from mac [%v]", m.ClientHWAddr)
+	}
+}
+
+// serveSelecting handles a client responding to an offer. The server
+// identifier tells us if it chose ours.
+func (h *Handler) serveSelecting(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4) {
+	if !m.ServerIdentifier().Equal(h.ip) {
+		logrus.Infof("mac [%v] selected server %v; dropping our offer", m.ClientHWAddr, m.ServerIdentifier())
+		if l, err := h.db.GetLease(h.client(m)); err == nil && l.Offered {
+			if err := h.db.ReleaseLease(h.client(m), l.IP()); err != nil {
+				logrus.Warnf("Could not drop offer for mac [%v] ip [%v]: %v", m.ClientHWAddr, l.IP(), err)
+			}
+		}
+		return
+	}
+
+	requested := m.RequestedIPAddress()
+	if unspecified(requested) {
+		logrus.Warnf("Ignoring request from mac [%v] without a requested address", m.ClientHWAddr)
+		return
+	}
+
+	if !s.network.Contains(requested) {
+		h.sendNAK(conn, m, "requested address is not on this network")
+		return
+	}
+
+	c := h.client(m)
+	if l, err := h.db.GetLeaseByIP(requested); err == nil && !c.Holds(l) {
+		h.sendNAK(conn, m, "requested address is in use")
+		return
+	}
+
+	// refuse before allocating: Allocate would commit an offer of another
+	// address, or lease one, to a client which is then refused.
+	if l, err := h.db.GetLease(c); err == nil {
+		if !l.IP().Equal(requested) {
+			h.sendNAK(conn, m, "requested address was not offered")
+			return
+		}
+	} else if !s.allocator.available(requested) {
+		h.sendNAK(conn, m, "requested address is not available")
+		return
+	}
+
+	ip, err := s.allocator.Allocate(c, true, requested)
+	if err != nil {
+		logrus.Errorf("Error allocating IP for %v: %v", m.ClientHWAddr, err)
+		h.sendNAK(conn, m, "could not allocate requested address")
+		return
+	}
+
+	if !requested.Equal(ip) {
+		h.sendNAK(conn, m, "requested address is not available")
+		return
+	}
+
+	logrus.Infof("Lease obtained for mac [%v] ip [%v]", m.ClientHWAddr, ip)
+	h.sendACK(conn, peer, s, m, ip, s.config.Lease.Duration, nil)
+}
+
+// serveInitReboot verifies the address a rebooted client remembers. The
+// lease is confirmed, not extended.
+func (h *Handler) serveInitReboot(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4) {
+	requested := m.RequestedIPAddress()
+
+	if !s.network.Contains(requested) {
+		h.sendNAK(conn, m, "requested address is not on this network")
+		return
+	}
+
+	l, err := h.db.GetLease(h.client(m))
+	if err != nil || l.Offered {
+		if owner, err := h.db.GetLeaseByIP(requested); err == nil && !h.client(m).Holds(owner) {
+			h.sendNAK(conn, m, "requested address is in use")
+			return
+		}
+
+		// RFC 2131 4.3.2: with no record of the client, remain silent.
+		logrus.Infof("No record of mac [%v]; ignoring init-reboot request", m.ClientHWAddr)
+		return
+	}
+
+	if !l.IP().Equal(requested) {
+		h.sendNAK(conn, m, "requested address is not leased to this client")
+		return
+	}
+
+	remaining := time.Until(l.LeaseEnd)
+	if remaining <= 0 {
+		h.sendNAK(conn, m, "lease has expired")
+		return
+	}
+
+	logrus.Infof("Lease confirmed for mac [%v] ip [%v]", m.ClientHWAddr, requested)
+	h.sendACK(conn, peer, s, m, requested, remaining, l)
+}
+
+// serveRenewing extends the lease on the address in ciaddr.
+func (h *Handler) serveRenewing(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4) {
+	l, err := h.db.GetLease(h.client(m))
+	if err != nil || l.Offered {
+		logrus.Infof("No record of mac [%v]; ignoring renewal", m.ClientHWAddr)
+		return
+	}
+
+	if !l.IP().Equal(m.ClientIPAddr) {
+		h.sendNAK(conn, m, "address is not leased to this client")
+		return
+	}
+
+	if _, err := s.allocator.Renew(h.client(m)); err != nil {
+		logrus.Errorf("Error renewing lease for %v: %v", m.ClientHWAddr, err)
+		h.sendNAK(conn, m, "could not renew lease")
+		return
+	}
+
+	logrus.Infof("Lease renewed for mac [%v] ip [%v]", m.ClientHWAddr, m.ClientIPAddr)
+	h.sendACK(conn, peer, s, m, m.ClientIPAddr, s.config.Lease.Duration, l)
+}
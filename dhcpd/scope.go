@@ -0,0 +1,176 @@
+package dhcpd
+
+import (
+	"net"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/pkg/errors"
+)
+
+// optionMSClasslessStaticRoute is Microsoft's pre-standard code for the
+// classless static routes option.
+const optionMSClasslessStaticRoute = 249
+
+// scope is a network the handler hands out addresses on: either the network
+// of the interface it listens on, or a subnet reached through a relay agent.
+type scope struct {
+	network   *net.IPNet
+	config    Config
+	options   dhcpOptions
+	allocator *Allocator
+	pins      []RelayPin
+	// class is the name of the class served, if any, and classes are the
+	// scopes serving each of config.Classes on the network.
+	class   string
+	classes []*scope
+}
+
+// newScope builds the scope serving the network. alloc is the allocator of
+// the scope, or nil to make one for the configuration's dynamic ranges.
+func newScope(network *net.IPNet, config Config, db *db.DB, alloc *Allocator) (*scope, error) {
+	if alloc == nil {
+		var err error
+		alloc, err = NewAllocator(db, config, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "while initializing allocator")
+		}
+	}
+
+	options := dhcpOptions{
+		dhcpv4.OptionSubnetMask.Code():       dhcpv4.IP(network.Mask).ToBytes(),
+		dhcpv4.OptionRouter.Code():           dhcpv4.IP(config.GatewayIP()).ToBytes(),
+		dhcpv4.OptionDomainNameServer.Code(): dhcpv4.IPs(config.DNS()).ToBytes(),
+	}
+
+	if routes := staticRoutes(config); routes != nil {
+		options[dhcpv4.OptionClasslessStaticRoute.Code()] = routes.ToBytes()
+		if config.MSStaticRoutes {
+			options[optionMSClasslessStaticRoute] = routes.ToBytes()
+		}
+	}
+
+	// configured options come later, so a subnet's override the top-level
+	// ones.
+	for _, o := range config.Options {
+		code, value, err := o.encode()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid option %v", o)
+		}
+
+		options[code] = value
+	}
+
+	pins := []RelayPin{}
+	for _, pin := range config.RelayPins {
+		if network.Contains(pin.IP()) {
+			pins = append(pins, pin)
+		}
+	}
+
+	classes := []*scope{}
+	for _, class := range config.Classes {
+		cc, ownRange := config.classConfig(class, network)
+
+		// a class without a range of its own on the network allocates from
+		// the network's, alongside the clients in no class.
+		var classAlloc *Allocator
+		if !ownRange {
+			classAlloc = alloc.withConfig(cc)
+		}
+
+		cs, err := newScope(network, cc, db, classAlloc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while configuring class %v", class.Name)
+		}
+
+		cs.class = class.Name
+		classes = append(classes, cs)
+	}
+
+	return &scope{
+		network:   network,
+		pins:      pins,
+		config:    config,
+		allocator: alloc,
+		options:   options,
+		classes:   classes,
+	}, nil
+}
+
+// staticRoutes returns the configured static routes, or nil if there are
+// none. Clients receiving classless static routes ignore the router option
+// (RFC 3442), so the gateway is added to them as the default route.
+func staticRoutes(config Config) dhcpv4.Routes {
+	if len(config.StaticRoutes) == 0 {
+		return nil
+	}
+
+	routes := dhcpv4.Routes{}
+	hasDefault := false
+	for _, r := range config.StaticRoutes {
+		route := r.Route()
+		if ones, _ := route.Dest.Mask.Size(); ones == 0 {
+			hasDefault = true
+		}
+
+		routes = append(routes, route)
+	}
+
+	if !hasDefault {
+		routes = append(routes, &dhcpv4.Route{
+			Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Router: config.GatewayIP(),
+		})
+	}
+
+	return routes
+}
+
+// scopeFor returns the scope the message should be served from: that of the
+// client's class on its network. It returns nil if the message arrived
+// through a relay we have no subnet for.
+func (h *Handler) scopeFor(m *dhcpv4.DHCPv4) *scope {
+	s := h.networkScope(m)
+	if s == nil {
+		return nil
+	}
+
+	return s.classify(m)
+}
+
+// networkScope returns the scope of the network the client is on, or nil if
+// the message arrived through a relay we have no subnet for. Clients on
+// relayed subnets unicast renewals to us directly, so those are matched by
+// ciaddr. A relay may name the client's subnet with the link selection
+// sub-option, which takes precedence over its own address.
+func (h *Handler) networkScope(m *dhcpv4.DHCPv4) *scope {
+	addr := m.GatewayIPAddr
+	if ls := linkSelection(m); ls != nil && !unspecified(addr) {
+		addr = ls
+	} else if unspecified(addr) {
+		if unspecified(m.ClientIPAddr) || h.local.network.Contains(m.ClientIPAddr) {
+			return h.local
+		}
+
+		addr = m.ClientIPAddr
+	}
+
+	for _, s := range h.relayed {
+		if s.network.Contains(addr) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// replyAddr returns where replies to the message go: relayed messages are
+// answered through the relay agent.
+func replyAddr(m *dhcpv4.DHCPv4, peer net.Addr) net.Addr {
+	if !unspecified(m.GatewayIPAddr) {
+		return &net.UDPAddr{IP: m.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	}
+
+	return peer
+}
@@ -2,13 +2,14 @@ package dhcpd
 
 import (
 	"net"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/rfc1035label"
 	"github.com/sirupsen/logrus"
 )
 
-func (h *Handler) configureReply(m *dhcpv4.DHCPv4, mt dhcpv4.MessageType) (*dhcpv4.DHCPv4, error) {
+func (h *Handler) configureReply(s *scope, m *dhcpv4.DHCPv4, mt dhcpv4.MessageType) (*dhcpv4.DHCPv4, error) {
 	rep, err := dhcpv4.NewReplyFromRequest(m)
 	if err != nil {
 		return nil, err
@@ -16,80 +17,231 @@ func (h *Handler) configureReply(m *dhcpv4.DHCPv4, mt dhcpv4.MessageType) (*dhcp
 
 	rep.UpdateOption(dhcpv4.OptMessageType(mt))
 	rep.UpdateOption(dhcpv4.OptServerIdentifier(h.ip))
-	rep.UpdateOption(dhcpv4.OptIPAddressLeaseTime(h.config.Lease.Duration))
-	if len(h.config.SearchDomains) != 0 {
-		rep.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: h.config.SearchDomains}))
+	rep.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.config.Lease.Duration))
+	if len(s.config.SearchDomains) != 0 {
+		rep.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: s.config.SearchDomains}))
 	}
 
-	for opt, val := range h.options {
-		rep.UpdateOption(dhcpv4.Option{Code: opt, Value: val})
+	for code, val := range s.options {
+		rep.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), val))
 	}
 
+	if host := h.hostFor(m); host != nil {
+		if host.Hostname != "" {
+			rep.UpdateOption(dhcpv4.OptHostName(host.Hostname))
+		}
+
+		for _, o := range host.Options {
+			if code, val, err := o.encode(); err == nil {
+				rep.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), val))
+			}
+		}
+	}
+
+	if h.config.DDNS != nil {
+		if opt := h.config.DDNS.fqdnReply(m); opt != nil {
+			rep.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, opt))
+		}
+	}
+
+	h.configureBoot(s, m, rep)
+
+	return rep, nil
+}
+
+// configureNAK builds a DHCPNAK for the request. Per RFC 2131 the NAK carries
+// no lease parameters; only the server identifier and an optional message.
+func (h *Handler) configureNAK(m *dhcpv4.DHCPv4, reason string) (*dhcpv4.DHCPv4, error) {
+	rep, err := dhcpv4.NewReplyFromRequest(m)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	rep.UpdateOption(dhcpv4.OptServerIdentifier(h.ip))
+	rep.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionMessage, []byte(reason)))
+
 	return rep, nil
 }
 
+// sendNAK rejects the request. The client may not have a usable address at
+// this point, so the NAK is always broadcast, or sent to the relay agent with
+// the broadcast flag set.
+func (h *Handler) sendNAK(conn net.PacketConn, m *dhcpv4.DHCPv4, reason string) {
+	logrus.Infof("Sending NAK to mac [%v]: %v", m.ClientHWAddr, reason)
+
+	rep, err := h.configureNAK(m, reason)
+	if err != nil {
+		logrus.Errorf("While configuring NAK: %v", err)
+		return
+	}
+
+	var addr net.Addr = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	if !unspecified(m.GatewayIPAddr) {
+		rep.SetBroadcast()
+		addr = replyAddr(m, nil)
+	}
+
+	if _, err := conn.WriteTo(h.marshalReply(m, rep), addr); err != nil {
+		logrus.Errorf("Error sending DHCP NAK: %v", err)
+	}
+}
+
+// serveDiscover offers the client an address. The config mutex is held for
+// reading, and let go while the address is probed: that may take seconds, and
+// a reload waiting for the mutex would hold up every other request. The scope
+// and its allocator stay usable if the configuration is reloaded meanwhile.
+func (h *Handler) serveDiscover(conn net.PacketConn, peer net.Addr, s *scope, m *dhcpv4.DHCPv4) {
+	logrus.Infof("received discover from %v", m.ClientHWAddr)
+
+	c := h.client(m)
+
+	// a client which moved networks cannot keep its old address.
+	if l, err := h.db.GetLease(c); err == nil && !l.Persistent && !s.network.Contains(l.IP()) {
+		logrus.Infof("mac [%v] moved off the network of ip [%v]; releasing it", m.ClientHWAddr, l.IP())
+		if err := h.releaseLease(c, l.IP()); err != nil {
+			logrus.Warnf("Could not release lease for mac [%v] ip [%v]: %v", m.ClientHWAddr, l.IP(), err)
+		}
+	}
+
+	var ip net.IP
+	if pin := s.pinFor(relayInfo(m)); pin != nil {
+		pinned, err := s.allocator.OfferPinned(c, *pin)
+		if err != nil {
+			logrus.Warnf("Could not offer pinned ip [%v] to mac [%v]; allocating normally: %v", pin.Address, m.ClientHWAddr, err)
+		}
+		ip = pinned
+	}
+
+	if ip == nil {
+		h.configMutex.RUnlock()
+		offered, err := s.allocator.Offer(c, m.RequestedIPAddress())
+		h.configMutex.RLock()
+		if err != nil {
+			logrus.Errorf("Error allocating IP for %v: %v", m.ClientHWAddr, err)
+			return
+		}
+		ip = offered
+	}
+
+	logrus.Infof("Generated offer for mac [%v] ip [%v]", m.ClientHWAddr, ip)
+
+	rep, err := h.configureReply(s, m, dhcpv4.MessageTypeOffer)
+	if err != nil {
+		logrus.Errorf("While configuring discover reply: %v", err)
+		return
+	}
+
+	rep.YourIPAddr = ip
+
+	if _, err := conn.WriteTo(h.marshalReply(m, rep), replyAddr(m, peer)); err != nil {
+		logrus.Errorf("Error replying to DHCP discover: %v", err)
+		return
+	}
+}
+
 // ServeDHCP returns a dhcp response for a dhcp request.
 func (h *Handler) ServeDHCP(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
 	if h.closed {
 		return
 	}
 
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+
+	s := h.scopeFor(m)
+	if s == nil {
+		logrus.Warnf("Ignoring %v from mac [%v] relayed by %v: no subnet configured for the relay", m.MessageType(), m.ClientHWAddr, m.GatewayIPAddr)
+		return
+	}
+
+	if s.class != "" {
+		logrus.Infof("mac [%v] is in class %v", m.ClientHWAddr, s.class)
+	}
+
 	switch m.MessageType() {
 	case dhcpv4.MessageTypeDiscover:
-		logrus.Infof("received discover from %v", m.ClientHWAddr)
+		h.serveDiscover(conn, peer, s, m)
+	case dhcpv4.MessageTypeRequest:
+		h.serveRequest(conn, peer, s, m)
+	case dhcpv4.MessageTypeInform:
+		logrus.Infof("received inform from %v (%v)", m.ClientHWAddr, m.ClientIPAddr)
+
+		if m.ClientIPAddr == nil || m.ClientIPAddr.IsUnspecified() {
+			logrus.Warnf("Ignoring inform from mac [%v] without a client address", m.ClientHWAddr)
+			return
+		}
 
-		ip, err := h.allocator.Allocate(m.ClientHWAddr, true, nil)
+		rep, err := h.configureReply(s, m, dhcpv4.MessageTypeAck)
 		if err != nil {
-			logrus.Errorf("Error allocating IP for %v: %v", m.ClientHWAddr, err)
+			logrus.Errorf("While configuring inform reply: %v", err)
 			return
 		}
 
-		logrus.Infof("Generated lease for mac [%v] ip [%v]", m.ClientHWAddr, ip)
+		// RFC 2131 4.3.5: the client already has an address; do not hand out
+		// one, or a lease time for it.
+		delete(rep.Options, dhcpv4.OptionIPAddressLeaseTime.Code())
+		rep.YourIPAddr = net.IPv4zero
 
-		rep, err := h.configureReply(m, dhcpv4.MessageTypeOffer)
-		if err != nil {
-			logrus.Errorf("While configuring discover reply: %v", err)
+		if _, err := conn.WriteTo(h.marshalReply(m, rep), replyAddr(m, &net.UDPAddr{IP: m.ClientIPAddr, Port: dhcpv4.ClientPort})); err != nil {
+			logrus.Errorf("Error replying to DHCP inform: %v", err)
 			return
 		}
+	case dhcpv4.MessageTypeRelease:
+		logrus.Infof("received release for %v from %v", m.ClientIPAddr, m.ClientHWAddr)
 
-		rep.YourIPAddr = ip
+		if !m.ServerIdentifier().Equal(h.ip) {
+			logrus.Warnf("Ignoring release from mac [%v] addressed to server %v", m.ClientHWAddr, m.ServerIdentifier())
+			return
+		}
 
-		if _, err := conn.WriteTo(rep.ToBytes(), peer); err != nil {
-			logrus.Errorf("Error replying to DHCP discover: %v", err)
+		if m.ClientIPAddr == nil || m.ClientIPAddr.IsUnspecified() {
+			logrus.Warnf("Ignoring release from mac [%v] without a client address", m.ClientHWAddr)
 			return
 		}
-	case dhcpv4.MessageTypeRequest:
-		logrus.Infof("received request for %v from %v", m.ClientHWAddr, m.ClientHWAddr)
 
-		preferredIP := net.IP(m.Options[uint8(dhcpv4.OptionRequestedIPAddress)])
-		if preferredIP == nil {
-			preferredIP = m.ClientIPAddr
+		if err := h.releaseLease(h.client(m), m.ClientIPAddr); err != nil {
+			logrus.Warnf("Could not release lease for mac [%v] ip [%v]: %v", m.ClientHWAddr, m.ClientIPAddr, err)
+			return
 		}
 
-		ip, err := h.allocator.Allocate(m.ClientHWAddr, true, preferredIP)
-		if err != nil {
-			logrus.Errorf("Error allocating IP for %v: %v", m.ClientHWAddr, err)
-			// FIXME NAK here
+		logrus.Infof("Released lease for mac [%v] ip [%v]", m.ClientHWAddr, m.ClientIPAddr)
+	case dhcpv4.MessageTypeDecline:
+		logrus.Infof("received decline for %v from %v", m.RequestedIPAddress(), m.ClientHWAddr)
+
+		if !m.ServerIdentifier().Equal(h.ip) {
+			logrus.Warnf("Ignoring decline from mac [%v] addressed to server %v", m.ClientHWAddr, m.ServerIdentifier())
 			return
 		}
 
-		logrus.Infof("Lease obtained for mac [%v] ip [%v]", m.ClientHWAddr, ip)
+		ip := m.RequestedIPAddress()
+		if ip == nil || ip.IsUnspecified() {
+			logrus.Warnf("Ignoring decline from mac [%v] without a requested address", m.ClientHWAddr)
+			return
+		}
 
-		rep, err := h.configureReply(m, dhcpv4.MessageTypeAck)
-		if err != nil {
-			logrus.Errorf("While configuring discover reply: %v", err)
+		// only the client given the address may decline it, or any client
+		// could take addresses out of the pool.
+		l, err := h.db.GetLease(h.client(m))
+		if err != nil || l.Persistent || !l.IP().Equal(ip) {
+			logrus.Warnf("Ignoring decline from mac [%v] for ip [%v], which was not leased or offered to it", m.ClientHWAddr, ip)
 			return
 		}
 
-		rep.YourIPAddr = ip
+		if !s.allocator.pool.contains(ip) {
+			logrus.Warnf("Ignoring decline from mac [%v] for ip [%v], which is not in the dynamic range", m.ClientHWAddr, ip)
+			return
+		}
 
-		if _, err := conn.WriteTo(rep.ToBytes(), peer); err != nil {
-			logrus.Errorf("Error replying to DHCP request: %v", err)
+		if err := h.db.DeclineLease(h.client(m), ip, time.Now().Add(h.config.Lease.Quarantine)); err != nil {
+			logrus.Errorf("Could not quarantine ip [%v]: %v", ip, err)
 			return
 		}
-	case dhcpv4.MessageTypeRelease:
-		logrus.Info("received release")
-	case dhcpv4.MessageTypeDecline:
-		logrus.Info("received decline")
+
+		if !l.Offered {
+			h.leaseEvent(EventRelease, l, nil)
+		}
+
+		logrus.Infof("Quarantined ip [%v] for %v after decline from mac [%v]", ip, h.config.Lease.Quarantine, m.ClientHWAddr)
 	}
 }
@@ -0,0 +1,2301 @@
+package dhcpd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/erikh/ldhcpd/db"
+	"github.com/erikh/ldhcpd/testutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/krolaw/dhcp4"
+	"github.com/miekg/dns"
+)
+
+func TestRequestNAK(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration: time.Minute,
+		},
+		DNSServers: []string{
+			"10.0.0.1",
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.50",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	expect := func(name string, mt dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatalf("[%v] no reply was sent", name)
+		}
+
+		if rep.MessageType() != mt {
+			t.Fatalf("[%v] expected %v, got %v", name, mt, rep.MessageType())
+		}
+
+		if !rep.ServerIdentifier().Equal(h.ip) {
+			t.Fatalf("[%v] server identifier was not set", name)
+		}
+
+		conn.reset()
+		return rep
+	}
+
+	selecting := func(mac net.HardwareAddr, ip string) *dhcpv4.DHCPv4 {
+		return newRequest(
+			mac,
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP(ip))),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+		)
+	}
+
+	h.ServeDHCP(conn, peer, selecting(testutil.FakeMAC, "10.0.30.50"))
+	rep := expect("outside subnet", dhcpv4.MessageTypeNak)
+	if rep.YourIPAddr != nil && !rep.YourIPAddr.IsUnspecified() {
+		t.Fatalf("NAK carried an address: %v", rep.YourIPAddr)
+	}
+
+	if rep.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+		t.Fatal("NAK carried a lease time")
+	}
+
+	h.ServeDHCP(conn, peer, selecting(testutil.FakeMAC, "10.0.20.50"))
+	rep = expect("valid request", dhcpv4.MessageTypeAck)
+	if !rep.YourIPAddr.Equal(net.ParseIP("10.0.20.50")) {
+		t.Fatalf("ACK was for the wrong address: %v", rep.YourIPAddr)
+	}
+
+	h.ServeDHCP(conn, peer, selecting(testutil.FakeMAC2, "10.0.20.50"))
+	expect("owned by another mac", dhcpv4.MessageTypeNak)
+
+	// in the subnet, free, but outside the exhausted dynamic range.
+	h.ServeDHCP(conn, peer, selecting(testutil.FakeMAC2, "10.0.20.60"))
+	expect("allocator refused", dhcpv4.MessageTypeNak)
+
+	// the client has a lease, but asks for a different address.
+	h.ServeDHCP(conn, peer, selecting(testutil.FakeMAC, "10.0.20.60"))
+	expect("different from existing lease", dhcpv4.MessageTypeNak)
+}
+
+func TestRelease(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	ip, err := h.local.allocator.Allocate(db.Client{MAC: testutil.FakeMAC}, false, nil)
+	if err != nil {
+		t.Fatalf("Error allocating: %v", err)
+	}
+
+	release := func(serverID net.IP) *dhcpv4.DHCPv4 {
+		return newRequest(
+			testutil.FakeMAC,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+			dhcpv4.WithClientIP(ip),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID)),
+		)
+	}
+
+	h.ServeDHCP(conn, peer, release(net.ParseIP("10.0.20.2")))
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err != nil {
+		t.Fatal("release addressed to another server freed the lease")
+	}
+
+	h.ServeDHCP(conn, peer, release(h.ip))
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("release did not free the lease")
+	}
+
+	if rep, _ := conn.last(); rep != nil {
+		t.Fatalf("release was replied to: %v", rep.MessageType())
+	}
+
+	// the released address must be immediately available to other clients.
+	ip2, err := h.local.allocator.Allocate(db.Client{MAC: testutil.FakeMAC2}, false, ip)
+	if err != nil {
+		t.Fatalf("Error allocating: %v", err)
+	}
+
+	if !ip.Equal(ip2) {
+		t.Fatalf("released address was not handed out again: %v", ip2)
+	}
+}
+
+func TestDecline(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:   time.Minute,
+			Quarantine: time.Hour,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.51",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	ip, err := h.local.allocator.Allocate(db.Client{MAC: testutil.FakeMAC}, false, nil)
+	if err != nil {
+		t.Fatalf("Error allocating: %v", err)
+	}
+
+	decline := func(mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+		return newRequest(
+			mac,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDecline),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+		)
+	}
+
+	h.ServeDHCP(conn, peer, decline(testutil.FakeMAC2))
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err != nil {
+		t.Fatal("decline from another client dropped the binding")
+	}
+
+	quarantined, err := h.db.IsQuarantined(ip)
+	if err != nil {
+		t.Fatalf("Error checking quarantine: %v", err)
+	}
+
+	if quarantined {
+		t.Fatal("decline from another client quarantined the address")
+	}
+
+	h.ServeDHCP(conn, peer, decline(testutil.FakeMAC))
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("decline did not drop the binding")
+	}
+
+	quarantined, err = h.db.IsQuarantined(ip)
+	if err != nil {
+		t.Fatalf("Error checking quarantine: %v", err)
+	}
+
+	if !quarantined {
+		t.Fatal("declined address was not quarantined")
+	}
+
+	if _, err := h.db.GetHistory(db.Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("declined lease was recorded in the history")
+	}
+
+	for _, mac := range []net.HardwareAddr{testutil.FakeMAC, testutil.FakeMAC2} {
+		ip2, err := h.local.allocator.Allocate(db.Client{MAC: mac}, false, ip)
+		if err != nil {
+			t.Fatalf("Error allocating: %v", err)
+		}
+
+		if ip.Equal(ip2) {
+			t.Fatal("allocator handed out a quarantined address")
+		}
+
+		if err := h.db.RemoveLease(db.Client{MAC: mac}); err != nil {
+			t.Fatalf("Error removing lease: %v", err)
+		}
+	}
+
+	if err := h.db.RemoveQuarantine(ip); err != nil {
+		t.Fatalf("Error clearing quarantine: %v", err)
+	}
+
+	ip2, err := h.local.allocator.Allocate(db.Client{MAC: testutil.FakeMAC}, false, ip)
+	if err != nil {
+		t.Fatalf("Error allocating: %v", err)
+	}
+
+	if !ip.Equal(ip2) {
+		t.Fatalf("cleared address was not handed out: %v", ip2)
+	}
+}
+
+func TestInform(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration: time.Minute,
+		},
+		DNSServers: []string{
+			"10.0.0.1",
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	clientIP := net.ParseIP("10.0.20.5").To4()
+
+	h.ServeDHCP(conn, &net.UDPAddr{IP: clientIP, Port: dhcpv4.ClientPort}, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeInform),
+		dhcpv4.WithClientIP(clientIP),
+	))
+
+	rep, addr := conn.last()
+	if rep == nil {
+		t.Fatal("inform was not answered")
+	}
+
+	if rep.MessageType() != dhcpv4.MessageTypeAck {
+		t.Fatalf("inform was answered with %v", rep.MessageType())
+	}
+
+	if !rep.YourIPAddr.IsUnspecified() {
+		t.Fatalf("inform reply carried an address: %v", rep.YourIPAddr)
+	}
+
+	if rep.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+		t.Fatal("inform reply carried a lease time")
+	}
+
+	if len(rep.DNS()) != 1 || !rep.DNS()[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("inform reply did not carry DNS servers: %v", rep.DNS())
+	}
+
+	if udp := addr.(*net.UDPAddr); !udp.IP.Equal(clientIP) || udp.Port != dhcpv4.ClientPort {
+		t.Fatalf("inform reply was not unicast to the client: %v", addr)
+	}
+
+	leases, err := h.db.ListLeases()
+	if err != nil {
+		t.Fatalf("Error listing leases: %v", err)
+	}
+
+	if len(leases) != 0 {
+		t.Fatal("inform created a lease")
+	}
+}
+
+func TestRequestStates(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	expect := func(name string, mt dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+		rep, _ := conn.last()
+		conn.reset()
+
+		if mt == dhcpv4.MessageTypeNone {
+			if rep != nil {
+				t.Fatalf("[%v] expected silence, got %v", name, rep.MessageType())
+			}
+
+			return nil
+		}
+
+		if rep == nil {
+			t.Fatalf("[%v] no reply was sent", name)
+		}
+
+		if rep.MessageType() != mt {
+			t.Fatalf("[%v] expected %v, got %v", name, mt, rep.MessageType())
+		}
+
+		return rep
+	}
+
+	ip := net.ParseIP("10.0.20.50").To4()
+
+	otherServer := newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("10.0.20.2"))),
+	)
+
+	if state := classifyRequest(otherServer); state != requestStateSelecting {
+		t.Fatalf("request was classified as %v", state)
+	}
+
+	h.ServeDHCP(conn, peer, otherServer)
+	expect("selecting another server", dhcpv4.MessageTypeNone)
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("request for another server created a lease")
+	}
+
+	initReboot := newRequest(testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)))
+	if state := classifyRequest(initReboot); state != requestStateInitReboot {
+		t.Fatalf("request was classified as %v", state)
+	}
+
+	h.ServeDHCP(conn, peer, initReboot)
+	expect("init-reboot without record", dhcpv4.MessageTypeNone)
+
+	h.ServeDHCP(conn, peer, newRequest(testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.0.30.1")))))
+	expect("init-reboot off network", dhcpv4.MessageTypeNak)
+
+	end := time.Now().Add(30 * time.Second)
+	if err := h.db.SetLease(db.Client{MAC: testutil.FakeMAC}, ip, true, false, end, end); err != nil {
+		t.Fatalf("Error setting lease: %v", err)
+	}
+
+	h.ServeDHCP(conn, peer, initReboot)
+	rep := expect("init-reboot with record", dhcpv4.MessageTypeAck)
+	if rep.IPAddressLeaseTime(0) > 30*time.Second {
+		t.Fatalf("init-reboot extended the lease: %v", rep.IPAddressLeaseTime(0))
+	}
+
+	l, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("Error getting lease: %v", err)
+	}
+
+	if l.LeaseEnd.Unix() != end.Unix() {
+		t.Fatal("init-reboot extended the lease")
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.0.20.51")))))
+	expect("init-reboot wrong address", dhcpv4.MessageTypeNak)
+
+	renewing := newRequest(testutil.FakeMAC, dhcpv4.WithClientIP(ip))
+	if state := classifyRequest(renewing); state != requestStateRenewing {
+		t.Fatalf("request was classified as %v", state)
+	}
+
+	h.ServeDHCP(conn, peer, renewing)
+	rep = expect("renewing", dhcpv4.MessageTypeAck)
+	if !rep.YourIPAddr.Equal(ip) {
+		t.Fatalf("renewal acked the wrong address: %v", rep.YourIPAddr)
+	}
+
+	l, err = h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("Error getting lease: %v", err)
+	}
+
+	if !l.LeaseEnd.After(end) {
+		t.Fatal("renewal did not extend the lease")
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(testutil.FakeMAC, dhcpv4.WithClientIP(net.ParseIP("10.0.20.51"))))
+	expect("renewing wrong address", dhcpv4.MessageTypeNak)
+
+	h.ServeDHCP(conn, peer, newRequest(testutil.FakeMAC2, dhcpv4.WithClientIP(net.ParseIP("10.0.20.51"))))
+	expect("renewing without record", dhcpv4.MessageTypeNone)
+}
+
+func TestOffers(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Hour,
+			OfferHold: 500 * time.Millisecond,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	discover := func(mac net.HardwareAddr) net.IP {
+		m, err := dhcpv4.NewDiscovery(mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, peer, m)
+		rep, _ := conn.last()
+		conn.reset()
+
+		if rep == nil || rep.MessageType() != dhcpv4.MessageTypeOffer {
+			t.Fatal("discover was not answered with an offer")
+		}
+
+		return rep.YourIPAddr
+	}
+
+	ip := discover(testutil.FakeMAC)
+
+	l, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("offer was not recorded: %v", err)
+	}
+
+	if !l.Offered || l.LeaseEnd.After(time.Now().Add(config.Lease.OfferHold)) {
+		t.Fatal("offer was recorded as a full lease")
+	}
+
+	// requests for another address are refused, leaving the offer alone
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(dhcp4.IPAdd(ip, 1))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+
+	if rep, _ := conn.last(); rep == nil || rep.MessageType() != dhcpv4.MessageTypeNak {
+		t.Fatal("request for an address which was not offered was not refused")
+	}
+	conn.reset()
+
+	if after, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err != nil || !after.Offered || !after.IP().Equal(ip) || !after.LeaseEnd.Equal(l.LeaseEnd) {
+		t.Fatalf("refused request changed the offer: %+v: %v", after, err)
+	}
+
+	// as are those of clients without an offer for addresses out of the
+	// dynamic range, which get nothing
+	other := testutil.RandomMAC()
+	h.ServeDHCP(conn, peer, newRequest(
+		other,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.0.20.20"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+
+	if rep, _ := conn.last(); rep == nil || rep.MessageType() != dhcpv4.MessageTypeNak {
+		t.Fatal("request for an address out of the dynamic range was not refused")
+	}
+	conn.reset()
+
+	if _, err := h.db.GetLease(db.Client{MAC: other}); err == nil {
+		t.Fatal("refused client was given a lease")
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+
+	if rep, _ := conn.last(); rep == nil || rep.MessageType() != dhcpv4.MessageTypeAck {
+		t.Fatal("request for offer was not acknowledged")
+	}
+	conn.reset()
+
+	l, err = h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatalf("lease was not recorded: %v", err)
+	}
+
+	if l.Offered || l.LeaseEnd.Before(time.Now().Add(time.Minute)) {
+		t.Fatal("offer was not promoted to a lease")
+	}
+
+	discover(testutil.FakeMAC2)
+
+	// the purge loop runs every second.
+	time.Sleep(2 * time.Second)
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC2}); err == nil {
+		t.Fatal("lapsed offer was not reclaimed")
+	}
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err != nil {
+		t.Fatal("committed lease was reclaimed")
+	}
+
+	// reloads are not held up while an offer is probed
+	prober := &fakeProber{wait: make(chan struct{})}
+	h.local.allocator.prober = prober
+
+	offered := make(chan struct{})
+	go func() {
+		defer close(offered)
+		discover(testutil.RandomMAC())
+	}()
+
+	for prober.probes() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- h.Reload(config) }()
+
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		close(prober.wait)
+		t.Fatal("reload waited for the probe")
+	}
+
+	close(prober.wait)
+	<-offered
+}
+
+func TestRelay(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{
+			"10.0.0.1",
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/23",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.31.50",
+					To:   "10.0.31.100",
+				},
+				DNSServers: []string{
+					"10.0.30.2",
+				},
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	relay := net.ParseIP("10.0.30.1").To4()
+	peer := &net.UDPAddr{IP: relay, Port: dhcpv4.ServerPort}
+
+	expect := func(name string, mt dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+		rep, addr := conn.last()
+		conn.reset()
+
+		if rep == nil {
+			t.Fatalf("[%v] no reply was sent", name)
+		}
+
+		if rep.MessageType() != mt {
+			t.Fatalf("[%v] expected %v, got %v", name, mt, rep.MessageType())
+		}
+
+		if udp := addr.(*net.UDPAddr); !udp.IP.Equal(relay) || udp.Port != dhcpv4.ServerPort {
+			t.Fatalf("[%v] reply was not sent to the relay: %v", name, addr)
+		}
+
+		if !rep.GatewayIPAddr.Equal(relay) {
+			t.Fatalf("[%v] reply did not carry giaddr", name)
+		}
+
+		return rep
+	}
+
+	discover, err := dhcpv4.NewDiscovery(testutil.FakeMAC, dhcpv4.WithRelay(relay))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, peer, discover)
+	rep := expect("discover", dhcpv4.MessageTypeOffer)
+
+	if !rep.YourIPAddr.Equal(net.ParseIP("10.0.31.50")) {
+		t.Fatalf("offer was not from the subnet's range: %v", rep.YourIPAddr)
+	}
+
+	if rep.SubnetMask().String() != net.CIDRMask(23, 32).String() {
+		t.Fatalf("offer carried the wrong mask: %v", rep.SubnetMask())
+	}
+
+	if len(rep.Router()) != 1 || !rep.Router()[0].Equal(relay) {
+		t.Fatalf("offer carried the wrong router: %v", rep.Router())
+	}
+
+	if len(rep.DNS()) != 1 || !rep.DNS()[0].Equal(net.ParseIP("10.0.30.2")) {
+		t.Fatalf("offer carried the wrong DNS servers: %v", rep.DNS())
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithRelay(relay),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(rep.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+	expect("request", dhcpv4.MessageTypeAck)
+
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC2,
+		dhcpv4.WithRelay(relay),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.0.20.50"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+	rep = expect("request off network", dhcpv4.MessageTypeNak)
+	if !rep.IsBroadcast() {
+		t.Fatal("relayed NAK did not set the broadcast flag")
+	}
+
+	unknown, err := dhcpv4.NewDiscovery(testutil.FakeMAC2, dhcpv4.WithRelay(net.ParseIP("10.0.40.1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, peer, unknown)
+	if rep, _ := conn.last(); rep != nil {
+		t.Fatal("discover from an unknown relay was answered")
+	}
+}
+
+func TestRelayAgentInfo(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{
+			"10.0.0.1",
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/24",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.30.50",
+					To:   "10.0.30.100",
+				},
+			},
+		},
+		RelayPins: []RelayPin{
+			{
+				CircuitID: "eth0/2",
+				RemoteID:  "switch1",
+				Address:   "10.0.30.10",
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	relay := net.ParseIP("10.0.30.1").To4()
+	peer := &net.UDPAddr{IP: relay, Port: dhcpv4.ServerPort}
+
+	agentInfo := func(circuit string, extra ...dhcpv4.Option) dhcpv4.Modifier {
+		return dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(append([]dhcpv4.Option{
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit)),
+			dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte("switch1")),
+			dhcpv4.OptGeneric(dhcpv4.SubscriberIDSubOption, []byte("subscriber")),
+		}, extra...)...))
+	}
+
+	expect := func(name string, mt dhcpv4.MessageType, circuit string) *dhcpv4.DHCPv4 {
+		rep, _ := conn.last()
+		conn.reset()
+
+		if rep == nil {
+			t.Fatalf("[%v] no reply was sent", name)
+		}
+
+		if rep.MessageType() != mt {
+			t.Fatalf("[%v] expected %v, got %v", name, mt, rep.MessageType())
+		}
+
+		ri := rep.RelayAgentInfo()
+		if ri == nil {
+			t.Fatalf("[%v] relay agent information was not echoed", name)
+		}
+
+		if string(ri.Get(dhcpv4.AgentCircuitIDSubOption)) != circuit {
+			t.Fatalf("[%v] echoed the wrong circuit id: %q", name, ri.Get(dhcpv4.AgentCircuitIDSubOption))
+		}
+
+		return rep
+	}
+
+	acquire := func(name string, mac net.HardwareAddr, circuit string, extra ...dhcpv4.Option) net.IP {
+		discover, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithRelay(relay), agentInfo(circuit, extra...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, peer, discover)
+		rep := expect(name+" discover", dhcpv4.MessageTypeOffer, circuit)
+
+		h.ServeDHCP(conn, peer, newRequest(
+			mac,
+			dhcpv4.WithRelay(relay),
+			agentInfo(circuit, extra...),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(rep.YourIPAddr)),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+		))
+		expect(name+" request", dhcpv4.MessageTypeAck, circuit)
+
+		return rep.YourIPAddr
+	}
+
+	if ip := acquire("unpinned", testutil.FakeMAC, "eth0/1"); !ip.Equal(net.ParseIP("10.0.30.50")) {
+		t.Fatalf("unpinned client was not allocated from the range: %v", ip)
+	}
+
+	l, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.CircuitID != "eth0/1" || l.RemoteID != "switch1" || l.SubscriberID != "subscriber" {
+		t.Fatalf("relay agent information was not recorded: %+v", l.RelayInfo)
+	}
+
+	// the client moves to the pinned port
+	if ip := acquire("pinned", testutil.FakeMAC, "eth0/2"); !ip.Equal(net.ParseIP("10.0.30.10")) {
+		t.Fatalf("client was not allocated the pinned address: %v", ip)
+	}
+
+	if _, err := h.db.GetLeaseByIP(net.ParseIP("10.0.30.50")); err == nil {
+		t.Fatal("lease on the old address was not given up")
+	}
+
+	// and is replaced by another
+	if ip := acquire("replacement", testutil.FakeMAC2, "eth0/2"); !ip.Equal(net.ParseIP("10.0.30.10")) {
+		t.Fatalf("replacement client was not allocated the pinned address: %v", ip)
+	}
+
+	if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err == nil {
+		t.Fatal("replaced client kept its lease")
+	}
+
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithRelay(relay),
+		agentInfo("eth0/1"),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("10.0.20.50"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+	expect("nak", dhcpv4.MessageTypeNak, "eth0/1")
+
+	// a relay outside the subnet names it with the link selection sub-option
+	relay = net.ParseIP("10.0.40.1").To4()
+	if ip := acquire("link selection", testutil.FakeMAC, "eth1/1", dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, net.ParseIP("10.0.30.0").To4())); !ip.Equal(net.ParseIP("10.0.30.51")) {
+		t.Fatalf("link selection did not select the subnet: %v", ip)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	for _, identity := range []string{IdentityMAC, IdentityClientID} {
+		func() {
+			config := Config{
+				Lease: Lease{
+					Duration:  time.Minute,
+					OfferHold: time.Minute,
+					Identity:  identity,
+				},
+				DNSServers: []string{
+					"10.0.0.1",
+				},
+				Gateway: "10.0.20.1",
+				DynamicRange: Range{
+					From: "10.0.20.50",
+					To:   "10.0.20.100",
+				},
+				DBFile: "test.db",
+			}
+			defer os.Remove("test.db")
+
+			h, conn := setupFakeHandler(t, config)
+			defer h.Close()
+
+			peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+			withClientID := func(id string) dhcpv4.Modifier {
+				b, err := db.ParseClientID(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return dhcpv4.WithOption(dhcpv4.OptClientIdentifier(b))
+			}
+
+			acquire := func(mac net.HardwareAddr, id string) net.IP {
+				discover, err := dhcpv4.NewDiscovery(mac, withClientID(id))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				h.ServeDHCP(conn, peer, discover)
+				rep, _ := conn.last()
+				conn.reset()
+				if rep == nil || rep.MessageType() != dhcpv4.MessageTypeOffer {
+					t.Fatalf("[%v] discover was not offered an address", identity)
+				}
+
+				h.ServeDHCP(conn, peer, newRequest(
+					mac,
+					withClientID(id),
+					dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(rep.YourIPAddr)),
+					dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+				))
+				rep, _ = conn.last()
+				conn.reset()
+				if rep == nil || rep.MessageType() != dhcpv4.MessageTypeAck {
+					t.Fatalf("[%v] request was not acknowledged", identity)
+				}
+
+				return rep.YourIPAddr
+			}
+
+			first := acquire(testutil.FakeMAC, "01:00:00:00:00:00:01")
+
+			l, err := h.db.GetLease(db.Client{ID: "01:00:00:00:00:00:01"})
+			if err != nil {
+				t.Fatalf("[%v] client id was not recorded: %v", identity, err)
+			}
+
+			if l.MACAddress != testutil.FakeMAC.String() {
+				t.Fatalf("[%v] client id was recorded for the wrong lease: %v", identity, l.MACAddress)
+			}
+
+			// the same client, through another interface
+			second := acquire(testutil.FakeMAC2, "01:00:00:00:00:00:01")
+
+			switch identity {
+			case IdentityMAC:
+				if second.Equal(first) {
+					t.Fatalf("[%v] client with another mac was given the same address", identity)
+				}
+			case IdentityClientID:
+				if !second.Equal(first) {
+					t.Fatalf("[%v] client with the same client id was given another address: %v", identity, second)
+				}
+
+				if _, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC}); err == nil {
+					t.Fatalf("[%v] lease was not moved to the new mac", identity)
+				}
+			}
+
+			// two clients behind one mac, such as virtual machines on a bridge
+			shared := testutil.RandomMAC()
+			third := acquire(shared, "01:00:00:00:00:00:03")
+			fourth := acquire(shared, "01:00:00:00:00:00:04")
+			if (identity == IdentityClientID) == third.Equal(fourth) {
+				t.Fatalf("[%v] clients sharing a mac were handled incorrectly: %v and %v", identity, third, fourth)
+			}
+
+			if identity == IdentityClientID {
+				if l, err := h.db.GetLease(db.Client{MAC: shared, ID: "01:00:00:00:00:00:03"}); err != nil || !l.IP().Equal(third) {
+					t.Fatalf("[%v] lease of the first client sharing a mac was lost: %+v: %v", identity, l, err)
+				}
+			}
+
+			if err := h.db.SetLease(db.Client{ID: "01:00:00:00:00:00:02"}, net.ParseIP("10.0.20.10"), false, true, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			mac := testutil.RandomMAC()
+			reserved := acquire(mac, "01:00:00:00:00:00:02")
+			if (identity == IdentityClientID) != reserved.Equal(net.ParseIP("10.0.20.10")) {
+				t.Fatalf("[%v] lease reserved by client id was handled incorrectly: %v", identity, reserved)
+			}
+
+			if identity == IdentityClientID {
+				if l, err := h.db.GetLease(db.Client{ID: "01:00:00:00:00:00:02"}); err != nil || l.MACAddress != mac.String() {
+					t.Fatalf("[%v] mac of the client was not recorded: %+v: %v", identity, l, err)
+				}
+			}
+		}()
+	}
+}
+
+func TestReplyOptions(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{},
+		Gateway:    "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		AlwaysSend: []int{int(dhcpv4.OptionRouter.Code())},
+		DBFile:     "test.db",
+	}
+	defer os.Remove("test.db")
+
+	// enough DNS servers and search domains to overflow a minimum size
+	// message
+	for i := 0; i < 60; i++ {
+		config.DNSServers = append(config.DNSServers, fmt.Sprintf("10.0.1.%d", i+1))
+	}
+
+	for i := 0; i < 8; i++ {
+		config.SearchDomains = append(config.SearchDomains, fmt.Sprintf("a%d.internal", i))
+	}
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	// offer returns the raw offer and its options, including those in the
+	// overloaded sname and file fields.
+	offer := func(modifiers ...dhcpv4.Modifier) ([]byte, dhcpv4.Options) {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(testutil.FakeMAC, modifiers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, peer, discover)
+		if len(conn.raw) == 0 {
+			t.Fatal("no reply was sent")
+		}
+
+		b := conn.raw[len(conn.raw)-1]
+		opts := dhcpv4.Options{}
+		if err := opts.FromBytes(b[optionsOffset:]); err != nil {
+			t.Fatalf("could not parse options: %v", err)
+		}
+
+		overload := opts.Get(dhcpv4.OptionOptionOverload)
+		if len(overload) == 1 && overload[0]&overloadFile != 0 {
+			if err := opts.FromBytes(b[fileOffset : fileOffset+fileLen]); err != nil {
+				t.Fatalf("could not parse options in file: %v", err)
+			}
+		}
+
+		if len(overload) == 1 && overload[0]&overloadSname != 0 {
+			if err := opts.FromBytes(b[snameOffset : snameOffset+snameLen]); err != nil {
+				t.Fatalf("could not parse options in sname: %v", err)
+			}
+		}
+
+		return b, opts
+	}
+
+	// replaces the parameter request list dhcpv4.NewDiscovery makes
+	prl := func(codes ...dhcpv4.OptionCode) dhcpv4.Modifier {
+		return dhcpv4.WithOption(dhcpv4.OptParameterRequestList(codes...))
+	}
+
+	has := func(opts dhcpv4.Options, codes ...dhcpv4.OptionCode) bool {
+		for _, code := range codes {
+			if !opts.Has(code) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	_, opts := offer(prl(dhcpv4.OptionSubnetMask))
+	if !has(opts, dhcpv4.OptionDHCPMessageType, dhcpv4.OptionServerIdentifier, dhcpv4.OptionIPAddressLeaseTime) {
+		t.Fatal("mandatory options were not sent")
+	}
+
+	if !has(opts, dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter) {
+		t.Fatal("requested or always sent options were not sent")
+	}
+
+	if has(opts, dhcpv4.OptionDomainNameServer) || has(opts, dhcpv4.OptionDNSDomainSearchList) {
+		t.Fatal("options which were not requested were sent")
+	}
+
+	b, opts := offer(prl(dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList))
+	if len(b) > minMessageSize-ipUDPHeaderLen {
+		t.Fatalf("reply was larger than the minimum message size: %d", len(b))
+	}
+
+	if !has(opts, dhcpv4.OptionOptionOverload, dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList) {
+		t.Fatal("options were not overloaded into the sname and file fields")
+	}
+
+	b, opts = offer(
+		prl(dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList),
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(1500)),
+	)
+	if len(b) <= minMessageSize-ipUDPHeaderLen {
+		t.Fatalf("reply did not use the larger maximum message size: %d", len(b))
+	}
+
+	if has(opts, dhcpv4.OptionOptionOverload) || !has(opts, dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList) {
+		t.Fatal("options were overloaded when they fit")
+	}
+
+	// too many search domains to fit anywhere: the least important options
+	// are left out, but the reply is still sent.
+	h.local.config.SearchDomains = nil
+	for i := 0; i < 40; i++ {
+		h.local.config.SearchDomains = append(h.local.config.SearchDomains, fmt.Sprintf("a%d.internal", i))
+	}
+
+	b, opts = offer(prl(dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList))
+	if len(b) > minMessageSize-ipUDPHeaderLen {
+		t.Fatalf("reply was larger than the minimum message size: %d", len(b))
+	}
+
+	if !has(opts, dhcpv4.OptionDomainNameServer) || has(opts, dhcpv4.OptionDNSDomainSearchList) {
+		t.Fatal("the wrong options were left out")
+	}
+}
+
+func TestConfiguredOptions(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{
+			"10.0.0.1",
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Options: []Option{
+			{Name: "mtu", Value: 9000, AlwaysSend: true},
+			{Name: "ntp_servers", Value: []interface{}{"10.0.0.5"}},
+			{Name: "dns_servers", Value: []interface{}{"10.0.0.53"}},
+		},
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/24",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.30.50",
+					To:   "10.0.30.100",
+				},
+				Options: []Option{
+					{Name: "mtu", Value: 1500},
+					{Name: "posix_timezone", Value: "UTC0", AlwaysSend: true},
+				},
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	offer := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(mac, append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionNTPServers))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, discover)
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatal("no reply was sent")
+		}
+
+		return rep
+	}
+
+	rep := offer(testutil.FakeMAC)
+	if mtu, err := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, rep.Options); err != nil || mtu != 9000 {
+		t.Fatalf("mtu was not sent: %v %v", mtu, err)
+	}
+
+	if ntp := rep.NTPServers(); len(ntp) != 1 || !ntp[0].Equal(net.ParseIP("10.0.0.5")) {
+		t.Fatalf("ntp servers were not sent: %v", ntp)
+	}
+
+	if dns := rep.DNS(); len(dns) != 1 || !dns[0].Equal(net.ParseIP("10.0.0.53")) {
+		t.Fatalf("configured dns servers did not take precedence: %v", dns)
+	}
+
+	rep = offer(testutil.FakeMAC2, dhcpv4.WithRelay(net.ParseIP("10.0.30.1")))
+	if mtu, err := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, rep.Options); err != nil || mtu != 1500 {
+		t.Fatalf("subnet mtu did not take precedence: %v %v", mtu, err)
+	}
+
+	if tz := rep.Options.Get(dhcpv4.OptionIEEE10031TZString); string(tz) != "UTC0" {
+		t.Fatalf("subnet option was not sent: %q", tz)
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{},
+		Gateway:    "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		StaticRoutes: []StaticRoute{
+			{Destination: "10.0.50.0/24", NextHop: "10.0.20.2"},
+			{Destination: "192.168.0.0/16", NextHop: "10.0.20.3"},
+		},
+		MSStaticRoutes: true,
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/24",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.30.50",
+					To:   "10.0.30.100",
+				},
+				StaticRoutes: []StaticRoute{
+					{Destination: "0.0.0.0/0", NextHop: "10.0.30.254"},
+				},
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	offer := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(mac, append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionClasslessStaticRoute, dhcpv4.GenericOptionCode(optionMSClasslessStaticRoute)))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, discover)
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatal("no reply was sent")
+		}
+
+		return rep
+	}
+
+	routeStrings := func(routes []*dhcpv4.Route) []string {
+		s := []string{}
+		for _, r := range routes {
+			s = append(s, r.String())
+		}
+
+		return s
+	}
+
+	rep := offer(testutil.FakeMAC)
+	expected := []string{
+		"route to 10.0.50.0/24 via 10.0.20.2",
+		"route to 192.168.0.0/16 via 10.0.20.3",
+		"route to 0.0.0.0/0 via 10.0.20.1",
+	}
+
+	if routes := routeStrings(rep.ClasslessStaticRoute()); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("classless static routes were incorrect: %v", routes)
+	}
+
+	var ms dhcpv4.Routes
+	if err := ms.FromBytes(rep.Options.Get(dhcpv4.GenericOptionCode(optionMSClasslessStaticRoute))); err != nil {
+		t.Fatal(err)
+	}
+
+	if routes := routeStrings(ms); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("microsoft classless static routes were incorrect: %v", routes)
+	}
+
+	// the subnet's routes replace the top-level ones, and already have a
+	// default route
+	rep = offer(testutil.FakeMAC2, dhcpv4.WithRelay(net.ParseIP("10.0.30.1")))
+	if routes := routeStrings(rep.ClasslessStaticRoute()); !reflect.DeepEqual(routes, []string{"route to 0.0.0.0/0 via 10.0.30.254"}) {
+		t.Fatalf("subnet classless static routes were incorrect: %v", routes)
+	}
+}
+
+func TestNetworkBoot(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{},
+		Gateway:    "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Boot: &Boot{
+			NextServer:     "10.0.20.5",
+			TFTPServerName: "tftp.example.org",
+			Filename:       "default.kpxe",
+			Architectures: []BootFile{
+				{Arch: "bios", Filename: "undionly.kpxe"},
+				{Arch: "efi-bc", Filename: "ipxe.efi"},
+				{Arch: "9", Filename: "ipxe.efi"},
+			},
+			IPXEFilename: "http://10.0.20.5/boot.ipxe",
+		},
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/24",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.30.50",
+					To:   "10.0.30.100",
+				},
+				Boot: &Boot{
+					NextServer: "10.0.30.5",
+					Filename:   "subnet.kpxe",
+				},
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	offer := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(mac, append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionTFTPServerName, dhcpv4.OptionBootfileName))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, discover)
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatal("no reply was sent")
+		}
+
+		return rep
+	}
+
+	check := func(name string, rep *dhcpv4.DHCPv4, nextServer, filename string) {
+		if !rep.ServerIPAddr.Equal(net.ParseIP(nextServer)) {
+			t.Fatalf("%v: next server was %v, not %v", name, rep.ServerIPAddr, nextServer)
+		}
+
+		if rep.BootFileName != filename {
+			t.Fatalf("%v: boot file was %q, not %q", name, rep.BootFileName, filename)
+		}
+
+		if rep.BootFileNameOption() != filename {
+			t.Fatalf("%v: boot file option was %q, not %q", name, rep.BootFileNameOption(), filename)
+		}
+	}
+
+	ipxe := dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionUserClassInformation, []byte("iPXE")))
+
+	table := []struct {
+		name      string
+		modifiers []dhcpv4.Modifier
+		filename  string
+	}{
+		{"no architecture", nil, "default.kpxe"},
+		{"bios", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC))}, "undionly.kpxe"},
+		{"efi", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64))}, "ipxe.efi"},
+		{"unknown architecture", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(11))}, "default.kpxe"},
+		{"ipxe", []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC)), ipxe}, "http://10.0.20.5/boot.ipxe"},
+	}
+
+	for _, test := range table {
+		rep := offer(testutil.RandomMAC(), test.modifiers...)
+		check(test.name, rep, "10.0.20.5", test.filename)
+
+		if rep.TFTPServerName() != "tftp.example.org" {
+			t.Fatalf("%v: tftp server name was %q", test.name, rep.TFTPServerName())
+		}
+	}
+
+	// the subnet's boot configuration replaces the top-level one
+	rep := offer(testutil.FakeMAC, dhcpv4.WithRelay(net.ParseIP("10.0.30.1")), ipxe)
+	check("subnet", rep, "10.0.30.5", "subnet.kpxe")
+
+	if rep.Options.Has(dhcpv4.OptionTFTPServerName) {
+		t.Fatal("tftp server name was sent for subnet without one")
+	}
+
+	// the reservation's boot configuration is laid over the pool's
+	end := time.Now().Add(time.Hour)
+	if err := h.db.SetLease(db.Client{MAC: testutil.FakeMAC2}, net.ParseIP("10.0.20.10"), false, true, end, end); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.db.SetBoot(db.Client{MAC: testutil.FakeMAC2}, db.Boot{NextServer: "10.0.20.6", BootFile: "host.kpxe"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check("host", offer(testutil.FakeMAC2, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC))), "10.0.20.6", "host.kpxe")
+	check("host ipxe", offer(testutil.FakeMAC2, ipxe), "10.0.20.6", "http://10.0.20.5/boot.ipxe")
+}
+
+func TestClasses(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		DNSServers: []string{},
+		Gateway:    "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Subnets: []Subnet{
+			{
+				Network: "10.0.30.0/24",
+				Gateway: "10.0.30.1",
+				DynamicRange: Range{
+					From: "10.0.30.50",
+					To:   "10.0.30.100",
+				},
+			},
+		},
+		Classes: []Class{
+			{
+				Name:          "phones",
+				Match:         ClassMatch{VendorClass: "Polycom*"},
+				Options:       []Option{{Name: "ntp_servers", Value: "10.0.20.9"}},
+				LeaseDuration: 10 * time.Minute,
+				DynamicRange:  &Range{From: "10.0.20.200", To: "10.0.20.210"},
+			},
+			{
+				Name:    "printers",
+				Match:   ClassMatch{MACPrefix: "00:00:5e"},
+				Options: []Option{{Name: "mtu", Value: 1400}},
+			},
+			{
+				Name:          "kiosks",
+				Match:         ClassMatch{Hostname: "kiosk-*"},
+				LeaseDuration: 5 * time.Minute,
+			},
+			{
+				Name:         "pxe",
+				Match:        ClassMatch{Arch: "efi-x86_64", CircuitID: "rack1/*"},
+				DynamicRange: &Range{From: "10.0.30.200", To: "10.0.30.210"},
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	offer := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(mac, append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionNTPServers, dhcpv4.OptionInterfaceMTU))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, discover)
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatal("no reply was sent")
+		}
+
+		return rep
+	}
+
+	phone := dhcpv4.WithOption(dhcpv4.OptClassIdentifier("Polycom-VVX-400"))
+	printerMAC := net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x02}
+
+	pxe := func(circuit string) []dhcpv4.Modifier {
+		return []dhcpv4.Modifier{
+			dhcpv4.WithRelay(net.ParseIP("10.0.30.1")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit)))),
+		}
+	}
+
+	table := []struct {
+		name      string
+		mac       net.HardwareAddr
+		modifiers []dhcpv4.Modifier
+		ip        string
+		leaseTime time.Duration
+		ntp       bool
+		mtu       uint16
+	}{
+		{"unclassified", testutil.RandomMAC(), nil, "10.0.20.50", time.Minute, false, 0},
+		{"phone", testutil.RandomMAC(), []dhcpv4.Modifier{phone}, "10.0.20.200", 10 * time.Minute, true, 0},
+		{"printer", printerMAC, nil, "10.0.20.51", time.Minute, false, 1400},
+		// the first class matching wins
+		{"printer phone", net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x03}, []dhcpv4.Modifier{phone}, "10.0.20.201", 10 * time.Minute, true, 0},
+		{"kiosk", testutil.RandomMAC(), []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptHostName("kiosk-lobby"))}, "10.0.20.52", 5 * time.Minute, false, 0},
+		{"not a kiosk", testutil.RandomMAC(), []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptHostName("desk-kiosk"))}, "10.0.20.53", time.Minute, false, 0},
+		{"pxe", testutil.RandomMAC(), pxe("rack1/port3"), "10.0.30.200", time.Minute, false, 0},
+		{"pxe in another rack", testutil.RandomMAC(), pxe("rack2/port3"), "10.0.30.50", time.Minute, false, 0},
+	}
+
+	for _, test := range table {
+		rep := offer(test.mac, test.modifiers...)
+
+		if !rep.YourIPAddr.Equal(net.ParseIP(test.ip)) {
+			t.Fatalf("%v: offered %v, not %v", test.name, rep.YourIPAddr, test.ip)
+		}
+
+		if lt := rep.IPAddressLeaseTime(0); lt != test.leaseTime {
+			t.Fatalf("%v: lease time was %v, not %v", test.name, lt, test.leaseTime)
+		}
+
+		if ntp := rep.NTPServers(); (len(ntp) != 0) != test.ntp {
+			t.Fatalf("%v: ntp servers were %v", test.name, ntp)
+		}
+
+		mtu, _ := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, rep.Options)
+		if mtu != test.mtu {
+			t.Fatalf("%v: mtu was %d, not %d", test.name, mtu, test.mtu)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	printer := testutil.RandomMAC()
+	laptop := testutil.RandomMAC()
+	dynamic := testutil.RandomMAC()
+
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+			Identity:  IdentityClientID,
+		},
+		DNSServers: []string{},
+		Gateway:    "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Hosts: []Host{
+			{
+				MACAddress: printer.String(),
+				IPAddress:  "10.0.20.10",
+				Hostname:   "printer",
+				Options:    []Option{{Name: "mtu", Value: 1400}},
+				BootFile:   "printer.kpxe",
+			},
+			{
+				ClientID:  "01:AA:BB:CC:DD:EE:FF",
+				IPAddress: "10.0.20.11",
+			},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	offer := func(mac net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		conn.reset()
+		discover, err := dhcpv4.NewDiscovery(mac, append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionHostName, dhcpv4.OptionInterfaceMTU))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, discover)
+		rep, _ := conn.last()
+		if rep == nil {
+			t.Fatal("no reply was sent")
+		}
+
+		return rep
+	}
+
+	l, err := h.db.GetLease(db.Client{MAC: printer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.IPAddress != "10.0.20.10" || !l.Persistent || !l.Configured || l.BootFile != "printer.kpxe" {
+		t.Fatalf("host reservation was incorrect: %+v", l)
+	}
+
+	rep := offer(printer)
+	if !rep.YourIPAddr.Equal(net.ParseIP("10.0.20.10")) {
+		t.Fatalf("host was offered %v", rep.YourIPAddr)
+	}
+
+	if rep.HostName() != "printer" {
+		t.Fatalf("host name was %q", rep.HostName())
+	}
+
+	if mtu, _ := dhcpv4.GetUint16(dhcpv4.OptionInterfaceMTU, rep.Options); mtu != 1400 {
+		t.Fatalf("host mtu was %d", mtu)
+	}
+
+	if rep.BootFileName != "printer.kpxe" {
+		t.Fatalf("host boot file was %q", rep.BootFileName)
+	}
+
+	laptopID := dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}))
+	rep = offer(laptop, laptopID)
+	if !rep.YourIPAddr.Equal(net.ParseIP("10.0.20.11")) {
+		t.Fatalf("host reserved by client id was offered %v", rep.YourIPAddr)
+	}
+
+	conn.reset()
+	h.ServeDHCP(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, newRequest(
+		laptop,
+		laptopID,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(rep.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	))
+	if rep, _ := conn.last(); rep == nil || rep.MessageType() != dhcpv4.MessageTypeAck {
+		t.Fatal("host reserved by client id was not acknowledged")
+	}
+
+	// a dynamic lease on a newly reserved address is a conflict, and the
+	// reservation waits for it
+	end := time.Now().Add(time.Hour)
+	if err := h.db.SetLease(db.Client{MAC: dynamic}, net.ParseIP("10.0.20.12"), true, false, end, end); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Hosts = append(config.Hosts[1:], Host{MACAddress: testutil.RandomMAC().String(), IPAddress: "10.0.20.12"})
+	if err := h.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+
+	conflicts, err := h.reconcileHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conflicts) != 1 || conflicts[0].Lease.MACAddress != dynamic.String() {
+		t.Fatalf("conflicts were incorrect: %v", conflicts)
+	}
+
+	if _, err := h.db.GetLease(db.Client{MAC: printer}); err == nil {
+		t.Fatal("reservation of removed host was kept")
+	}
+
+	// the reservation by client id keeps the mac it was seen with
+	if l, err := h.db.GetLease(db.Client{ID: "01:aa:bb:cc:dd:ee:ff"}); err != nil || l.MACAddress != laptop.String() || l.IPAddress != "10.0.20.11" {
+		t.Fatalf("host reserved by client id was not kept: %+v: %v", l, err)
+	}
+
+	if err := h.db.ReleaseLease(db.Client{MAC: dynamic}, net.ParseIP("10.0.20.12")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := h.db.GetLeaseByIP(net.ParseIP("10.0.20.12")); err != nil || l.MACAddress != config.Hosts[1].MACAddress || !l.Configured {
+		t.Fatalf("host was not reserved once its address was free: %+v: %v", l, err)
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	discover, err := dhcpv4.NewDiscovery(testutil.FakeMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, peer, discover)
+	rep, _ := conn.last()
+	conn.reset()
+	if rep == nil || rep.MessageType() != dhcpv4.MessageTypeOffer {
+		t.Fatal("no offer was sent")
+	}
+
+	// the FQDN is in wire format, as the E flag says
+	fqdn := append([]byte{fqdnFlagE, 0, 0}, "\x06laptop\x07example\x03com\x00"...)
+
+	h.ServeDHCP(conn, peer, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(rep.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+		dhcpv4.WithOption(dhcpv4.OptHostName("laptop")),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, fqdn)),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0")),
+	))
+	if ack, _ := conn.last(); ack == nil || ack.MessageType() != dhcpv4.MessageTypeAck {
+		t.Fatal("no ack was sent")
+	}
+	conn.reset()
+
+	expected := db.ClientInfo{Hostname: "laptop", FQDN: "laptop.example.com", FQDNFlags: fqdnFlagE, VendorClass: "MSFT 5.0"}
+
+	l, err := h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.ClientInfo != expected {
+		t.Fatalf("client information was not recorded: %+v", l.ClientInfo)
+	}
+
+	// renewals need not repeat it, or all of it
+	for _, modifiers := range [][]dhcpv4.Modifier{
+		nil,
+		{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.1"))},
+	} {
+		h.ServeDHCP(conn, &net.UDPAddr{IP: rep.YourIPAddr, Port: dhcpv4.ClientPort}, newRequest(
+			testutil.FakeMAC,
+			append([]dhcpv4.Modifier{dhcpv4.WithClientIP(rep.YourIPAddr)}, modifiers...)...,
+		))
+		if ack, _ := conn.last(); ack == nil || ack.MessageType() != dhcpv4.MessageTypeAck {
+			t.Fatal("no ack was sent for the renewal")
+		}
+
+		if len(modifiers) != 0 {
+			expected.VendorClass = "MSFT 5.1"
+		}
+
+		l, err = h.db.GetLease(db.Client{MAC: testutil.FakeMAC})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l.ClientInfo != expected {
+			t.Fatalf("client information was lost on renewal: %+v", l.ClientInfo)
+		}
+	}
+
+	for opt, name := range map[string]string{
+		"\x00\x00\x00host.example.com.":     "host.example.com",
+		"\x04\x00\x00\x04host\x00":          "host",
+		"\x04\x00\x00\x09host":              "",
+		"\x00\x00":                          "",
+		"\x05\xff\xff\x04host\x03lan\x00xx": "host.lan",
+	} {
+		if parsed := parseFQDN([]byte(opt)); parsed != name {
+			t.Fatalf("FQDN %q was parsed as %q, not %q", opt, parsed, name)
+		}
+	}
+}
+
+func TestDDNS(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("ldhcpd test secret"))
+	fs := startFakeDNSServer(t, "ldhcpd.", secret)
+	defer fs.server.Shutdown()
+
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DDNS: &DDNS{
+			Server:      fs.addr(),
+			ForwardZone: "example.com",
+			ReverseZone: "20.0.10.in-addr.arpa",
+			TTL:         time.Minute,
+			Timeout:     time.Second,
+			TSIG:        &TSIG{Name: "ldhcpd", Algorithm: "hmac-sha256", Secret: secret},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	const (
+		forward = "example.com."
+		reverse = "20.0.10.in-addr.arpa."
+	)
+
+	renew := func(mac net.HardwareAddr, ip net.IP, modifiers ...dhcpv4.Modifier) {
+		h.ServeDHCP(conn, &net.UDPAddr{IP: ip, Port: dhcpv4.ClientPort}, newRequest(mac, append([]dhcpv4.Modifier{
+			dhcpv4.WithClientIP(ip),
+		}, modifiers...)...))
+		ack, _ := conn.last()
+		conn.reset()
+		if ack == nil || ack.MessageType() != dhcpv4.MessageTypeAck {
+			t.Fatal("no ack was sent for the renewal")
+		}
+	}
+
+	// a client without the FQDN option gets both records
+	ack := acquireLease(t, h, conn, testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptHostName("Laptop")))
+	if ack.YourIPAddr.String() != "10.0.20.50" {
+		t.Fatalf("unexpected address %v", ack.YourIPAddr)
+	}
+
+	fs.expect(t, forward, true, "laptop.example.com. A 10.0.20.50")
+	fs.expect(t, reverse, true, "50.20.0.10.in-addr.arpa. PTR laptop.example.com.")
+
+	if got := fs.lookup("laptop.example.com.", dns.TypeDHCID); len(got) != 1 {
+		t.Fatalf("unexpected DHCID records %v", got)
+	}
+
+	if opt := ack.Options.Get(dhcpv4.OptionFQDN); opt != nil {
+		t.Fatalf("a client FQDN option was sent to a client without one: %v", opt)
+	}
+
+	renew(testutil.FakeMAC, ack.YourIPAddr)
+	fs.none(t)
+
+	renew(testutil.FakeMAC, ack.YourIPAddr, dhcpv4.WithOption(dhcpv4.OptHostName("desktop")))
+	fs.expect(t, forward, false, "laptop.example.com. A 10.0.20.50")
+	fs.expect(t, reverse, false, "50.20.0.10.in-addr.arpa. PTR laptop.example.com.")
+	fs.expect(t, forward, true, "desktop.example.com. A 10.0.20.50")
+	fs.expect(t, reverse, true, "50.20.0.10.in-addr.arpa. PTR desktop.example.com.")
+
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(testutil.FakeMAC),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(ack.YourIPAddr),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, &net.UDPAddr{IP: ack.YourIPAddr, Port: dhcpv4.ClientPort}, release)
+	fs.expect(t, forward, false, "desktop.example.com. A 10.0.20.50")
+	fs.expect(t, reverse, false, "50.20.0.10.in-addr.arpa. PTR desktop.example.com.")
+
+	for _, name := range []string{"laptop.example.com.", "desktop.example.com."} {
+		if got := append(fs.lookup(name, dns.TypeA), fs.lookup(name, dns.TypeDHCID)...); len(got) != 0 {
+			t.Fatalf("records of %v were left: %v", name, got)
+		}
+	}
+
+	// names of other hosts are left alone
+	fs.add(t, "www.example.com. 60 IN A 10.0.20.5")
+	acquireLease(t, h, conn, testutil.RandomMAC(), dhcpv4.WithOption(dhcpv4.OptHostName("www")))
+	fs.none(t)
+
+	if got := fs.lookup("www.example.com.", dns.TypeA); len(got) != 1 || got[0] != "10.0.20.5" {
+		t.Fatalf("the record of another host was replaced: %v", got)
+	}
+
+	// as are those of other clients
+	ack = acquireLease(t, h, conn, testutil.RandomMAC(), dhcpv4.WithOption(dhcpv4.OptHostName("printer")))
+	printer := ack.YourIPAddr.String()
+	arpa, _ := dns.ReverseAddr(printer)
+	fs.expect(t, forward, true, "printer.example.com. A "+printer)
+	fs.expect(t, reverse, true, arpa+" PTR printer.example.com.")
+
+	mac := testutil.RandomMAC()
+	ack = acquireLease(t, h, conn, mac, dhcpv4.WithOption(dhcpv4.OptHostName("printer")))
+	fs.none(t)
+
+	if _, err := h.db.RenewLease(db.Client{MAC: mac}, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.db.PurgeLeases(false); err != nil {
+		t.Fatal(err)
+	}
+
+	arpa, _ = dns.ReverseAddr(ack.YourIPAddr.String())
+	fs.expect(t, reverse, false, arpa+" PTR printer.example.com.")
+
+	if got := fs.lookup("printer.example.com.", dns.TypeA); len(got) != 1 || got[0] != printer {
+		t.Fatalf("the record of another client was changed: %v", got)
+	}
+
+	// a client's own name is taken back, as after a restart
+	l, err := h.db.GetLeaseByIP(net.ParseIP(printer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.IPAddress = "10.0.20.99"
+	newDDNSUpdater(*config.DDNS).add(l)
+	fs.expect(t, forward, true, "printer.example.com. A 10.0.20.99")
+	fs.expect(t, reverse, true, "99.20.0.10.in-addr.arpa. PTR printer.example.com.")
+
+	if got := fs.lookup("printer.example.com.", dns.TypeA); len(got) != 1 || got[0] != "10.0.20.99" {
+		t.Fatalf("unexpected records of the client: %v", got)
+	}
+
+	// without the S flag the client updates its A record itself
+	fqdn := append([]byte{0, 0, 0}, "phone.example.com"...)
+	ack = acquireLease(t, h, conn, testutil.FakeMAC2, dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, fqdn)))
+	arpa, _ = dns.ReverseAddr(ack.YourIPAddr.String())
+	fs.expect(t, reverse, true, arpa+" PTR phone.example.com.")
+
+	if opt := ack.Options.Get(dhcpv4.OptionFQDN); string(opt) != "\x00\xff\xffphone.example.com" {
+		t.Fatalf("unexpected client FQDN option in reply: %q", opt)
+	}
+
+	if _, err := h.db.RenewLease(db.Client{MAC: testutil.FakeMAC2}, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.db.PurgeLeases(false); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.expect(t, reverse, false, arpa+" PTR phone.example.com.")
+
+	// with the N flag nothing is updated
+	fqdn = append([]byte{fqdnFlagN, 0, 0}, "tablet.example.com"...)
+	acquireLease(t, h, conn, testutil.RandomMAC(), dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, fqdn)))
+	fs.none(t)
+
+	// the DHCID examples of RFC 4701 section 3.6
+	u := newDDNSUpdater(DDNS{})
+	for _, test := range []struct {
+		lease  db.Lease
+		name   string
+		digest string
+	}{
+		{db.Lease{MACAddress: "01:02:03:04:05:06"}, "client.example.com.", "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY="},
+		{db.Lease{MACAddress: "01:02:03:04:05:06", ClientID: "01:07:08:09:0a:0b:0c"}, "chi.example.com.", "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No="},
+	} {
+		if got := u.dhcid(&test.lease, test.name).(*dns.DHCID).Digest; got != test.digest {
+			t.Fatalf("unexpected DHCID for %v: %v, not %v", test.name, got, test.digest)
+		}
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	serveDNS := func(handler dns.Handler) *dns.Server {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started := make(chan struct{})
+		s := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+		go s.ActivateAndServe()
+		<-started
+
+		return s
+	}
+
+	// the upstream server knows one name
+	upstream := serveDNS(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(r)
+		if r.Question[0].Name == "example.org." {
+			rr, _ := dns.NewRR("example.org. 60 IN A 192.0.2.1")
+			m.Answer = []dns.RR{rr}
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	}))
+	defer upstream.Shutdown()
+
+	_, port, _ := net.SplitHostPort(upstream.PacketConn.LocalAddr().String())
+	dnsForwardPort = port
+	defer func() { dnsForwardPort = "53" }()
+
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		DNSServers:    []string{"10.0.20.1"},
+		Forwarders:    []string{"127.0.0.1"},
+		SearchDomains: []string{"internal"},
+		Hosts: []Host{
+			{MACAddress: testutil.RandomMAC().String(), IPAddress: "10.0.20.10", Hostname: "printer"},
+		},
+		DBFile: "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	s := serveDNS(h)
+	defer s.Shutdown()
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		m := &dns.Msg{}
+		m.SetQuestion(name, qtype)
+
+		r, _, err := (&dns.Client{}).Exchange(m, s.PacketConn.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("could not query %v: %v", name, err)
+		}
+
+		return r
+	}
+
+	answer := func(name string, qtype uint16, expected string) {
+		t.Helper()
+
+		r := query(name, qtype)
+		if r.Rcode != dns.RcodeSuccess || !r.Authoritative || len(r.Answer) != 1 {
+			t.Fatalf("unexpected answer to %v: %v", name, r)
+		}
+
+		var got string
+		switch rr := r.Answer[0].(type) {
+		case *dns.A:
+			got = rr.A.String()
+		case *dns.PTR:
+			got = rr.Ptr
+		}
+
+		if got != expected {
+			t.Fatalf("%v was answered with %v, not %v", name, got, expected)
+		}
+	}
+
+	ack := acquireLease(t, h, conn, testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	if ack.YourIPAddr.String() != "10.0.20.50" {
+		t.Fatalf("unexpected address %v", ack.YourIPAddr)
+	}
+
+	answer("laptop.internal.", dns.TypeA, "10.0.20.50")
+	answer("LAPTOP.Internal.", dns.TypeA, "10.0.20.50")
+	answer("50.20.0.10.in-addr.arpa.", dns.TypePTR, "laptop.internal.")
+	answer("printer.internal.", dns.TypeA, "10.0.20.10")
+	answer("10.20.0.10.in-addr.arpa.", dns.TypePTR, "printer.internal.")
+
+	if r := query("laptop.internal.", dns.TypeAAAA); r.Rcode != dns.RcodeSuccess || !r.Authoritative || len(r.Answer) != 0 {
+		t.Fatalf("unexpected answer for a known name without the type: %v", r)
+	}
+
+	// the rest is forwarded
+	if r := query("example.org.", dns.TypeA); r.Rcode != dns.RcodeSuccess || r.Authoritative || len(r.Answer) != 1 {
+		t.Fatalf("query was not forwarded: %v", r)
+	}
+
+	if r := query("desktop.internal.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("unknown name was not forwarded: %v", r)
+	}
+
+	// only for clients on the served networks
+	for addr, rcode := range map[string]int{"10.0.20.60": dns.RcodeSuccess, "192.0.2.7": dns.RcodeRefused} {
+		m := &dns.Msg{}
+		m.SetQuestion("example.org.", dns.TypeA)
+
+		fw := &fakeDNSWriter{addr: &net.UDPAddr{IP: net.ParseIP(addr), Port: 5353}}
+		h.ServeDNS(fw, m)
+		if fw.reply == nil || fw.reply.Rcode != rcode {
+			t.Fatalf("unexpected answer to a query from %v: %v", addr, fw.reply)
+		}
+	}
+
+	// clients cannot take the names of reservations
+	ack2 := acquireLease(t, h, conn, testutil.RandomMAC(), dhcpv4.WithOption(dhcpv4.OptHostName("printer")))
+	answer("printer.internal.", dns.TypeA, "10.0.20.10")
+
+	arpa, _ := dns.ReverseAddr(ack2.YourIPAddr.String())
+	if r := query(arpa, dns.TypePTR); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("address of a client is answered with the name of a reservation: %v", r)
+	}
+
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(testutil.FakeMAC),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(ack.YourIPAddr),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, &net.UDPAddr{IP: ack.YourIPAddr, Port: dhcpv4.ClientPort}, release)
+
+	if r := query("laptop.internal.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("released client is still answered for: %v", r)
+	}
+
+	if r := query("50.20.0.10.in-addr.arpa.", dns.TypePTR); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("released address is still answered for: %v", r)
+	}
+
+	// a name taken by two clients names the one which took it last
+	ack = acquireLease(t, h, conn, testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	ack2 = acquireLease(t, h, conn, testutil.FakeMAC2, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	if _, err := h.db.RenewLease(db.Client{MAC: testutil.FakeMAC2}, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	answer("laptop.internal.", dns.TypeA, ack2.YourIPAddr.String())
+
+	arpa, _ = dns.ReverseAddr(ack2.YourIPAddr.String())
+	answer(arpa, dns.TypePTR, "laptop.internal.")
+
+	arpa, _ = dns.ReverseAddr(ack.YourIPAddr.String())
+	if r := query(arpa, dns.TypePTR); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("address of a former holder of a name is answered: %v", r)
+	}
+
+	// without forwarders, queries are not forwarded to the DNS servers which
+	// are this host, here a listener counting the queries it is sent
+	var mutex sync.Mutex
+	queries := 0
+	self := serveDNS(dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mutex.Lock()
+		queries++
+		mutex.Unlock()
+		h.ServeDNS(w, r)
+	}))
+	defer self.Shutdown()
+
+	_, port, _ = net.SplitHostPort(self.PacketConn.LocalAddr().String())
+	dnsForwardPort = port
+
+	config.DNSServers = []string{"127.0.0.1", "10.0.20.1"}
+	config.Forwarders = nil
+	if err := h.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+	r, _, err := (&dns.Client{}).Exchange(m, self.PacketConn.LocalAddr().String())
+	if err != nil || r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("unexpected answer to a query with no forwarders: %v: %v", r, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if queries != 1 {
+		t.Fatalf("query was forwarded to this host: it was sent %d queries", queries)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ldhcpd-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each event is written to a file named for it, the environment first
+	script := fmt.Sprintf(`f=%s/$LDHCPD_EVENT-$LDHCPD_MAC; env | grep ^LDHCPD_ | sort > $f.tmp; cat >> $f.tmp; mv $f.tmp $f`, dir)
+
+	config := Config{
+		Lease: Lease{
+			Duration:  time.Minute,
+			OfferHold: time.Minute,
+		},
+		Gateway: "10.0.20.1",
+		DynamicRange: Range{
+			From: "10.0.20.50",
+			To:   "10.0.20.100",
+		},
+		Hooks: []Hook{
+			{Command: []string{"sh", "-c", script}, Timeout: 5 * time.Second},
+			{Command: []string{"sleep", "10"}, Events: []string{EventCommit}, Timeout: 100 * time.Millisecond},
+		},
+		HookConcurrency: 2,
+		DBFile:          "test.db",
+	}
+	defer os.Remove("test.db")
+
+	h, conn := setupFakeHandler(t, config)
+	defer h.Close()
+
+	// wait returns the environment and json object the hook was given for the
+	// event.
+	wait := func(event string, mac net.HardwareAddr) (map[string]string, map[string]string) {
+		t.Helper()
+
+		var content []byte
+		for i := 0; i < 100; i++ {
+			if content, err = ioutil.ReadFile(fmt.Sprintf("%s/%s-%s", dir, event, mac)); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if err != nil {
+			t.Fatalf("hook did not run on %v for mac [%v]", event, mac)
+		}
+
+		env := map[string]string{}
+		obj := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if strings.HasPrefix(line, "{") {
+				if err := json.Unmarshal([]byte(line), &obj); err != nil {
+					t.Fatalf("hook was given invalid json %q: %v", line, err)
+				}
+				continue
+			}
+
+			parts := strings.SplitN(line, "=", 2)
+			env[parts[0]] = parts[1]
+		}
+
+		return env, obj
+	}
+
+	start := time.Now()
+	ack := acquireLease(t, h, conn, testutil.FakeMAC, dhcpv4.WithOption(dhcpv4.OptHostName("laptop")))
+	if time.Since(start) > time.Second {
+		t.Fatal("serving the request waited for the hooks")
+	}
+
+	env, obj := wait(EventCommit, testutil.FakeMAC)
+	if env["LDHCPD_IP"] != ack.YourIPAddr.String() || env["LDHCPD_HOSTNAME"] != "laptop" || env["LDHCPD_MAC"] != testutil.FakeMAC.String() {
+		t.Fatalf("unexpected hook environment: %v", env)
+	}
+
+	if obj["event"] != EventCommit || obj["ip"] != ack.YourIPAddr.String() || obj["hostname"] != "laptop" || obj["lease_end"] != env["LDHCPD_LEASE_END"] {
+		t.Fatalf("unexpected hook input: %v", obj)
+	}
+
+	if end, err := time.Parse(time.RFC3339, obj["lease_end"]); err != nil || end.Before(time.Now()) {
+		t.Fatalf("unexpected lease end %q: %v", obj["lease_end"], err)
+	}
+
+	h.ServeDHCP(conn, &net.UDPAddr{IP: ack.YourIPAddr, Port: dhcpv4.ClientPort}, newRequest(
+		testutil.FakeMAC,
+		dhcpv4.WithClientIP(ack.YourIPAddr),
+	))
+	if env, _ := wait(EventRenew, testutil.FakeMAC); env["LDHCPD_IP"] != ack.YourIPAddr.String() {
+		t.Fatalf("unexpected hook environment on renewal: %v", env)
+	}
+
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(testutil.FakeMAC),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(ack.YourIPAddr),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(h.ip)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.ServeDHCP(conn, &net.UDPAddr{IP: ack.YourIPAddr, Port: dhcpv4.ClientPort}, release)
+	if env, _ := wait(EventRelease, testutil.FakeMAC); env["LDHCPD_IP"] != ack.YourIPAddr.String() {
+		t.Fatalf("unexpected hook environment on release: %v", env)
+	}
+
+	ack = acquireLease(t, h, conn, testutil.FakeMAC2)
+	if _, err := h.db.RenewLease(db.Client{MAC: testutil.FakeMAC2}, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.db.PurgeLeases(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if env, _ := wait(EventExpire, testutil.FakeMAC2); env["LDHCPD_IP"] != ack.YourIPAddr.String() {
+		t.Fatalf("unexpected hook environment on expiry: %v", env)
+	}
+
+	// commands running too long are killed
+	hook := Hook{Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond}
+	start = time.Now()
+	if err := hook.run(hookEvent{}); err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("hook was not killed after its timeout: %v", err)
+	}
+
+	// as are the commands they leave behind
+	hook = Hook{Command: []string{"sh", "-c", "sleep 10 & echo started"}, Timeout: 100 * time.Millisecond}
+	start = time.Now()
+	if err := hook.run(hookEvent{}); err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("command started by a hook was not killed after its timeout: %v", err)
+	}
+
+	// the events of a client run in order, without holding up others
+	order := func(mac string) string {
+		content, _ := ioutil.ReadFile(fmt.Sprintf("%s/order-%s", dir, mac))
+		return string(content)
+	}
+
+	script = fmt.Sprintf(`[ $LDHCPD_EVENT = commit ] && sleep 1; echo $LDHCPD_EVENT >> %s/order-$LDHCPD_MAC`, dir)
+	hr := newHookRunner([]Hook{{Command: []string{"sh", "-c", script}, Timeout: 5 * time.Second}}, 2)
+	hr.LeaseEvent(LeaseEvent{Type: EventCommit, Lease: db.Lease{MACAddress: "slow", IPAddress: "10.0.20.50"}})
+	hr.LeaseEvent(LeaseEvent{Type: EventRelease, Lease: db.Lease{MACAddress: "slow", IPAddress: "10.0.20.50"}})
+	hr.LeaseEvent(LeaseEvent{Type: EventRelease, Lease: db.Lease{MACAddress: "fast", IPAddress: "10.0.20.51"}})
+
+	for i := 0; i < 100 && order("fast") == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if order("fast") != "release\n" || order("slow") != "" {
+		t.Fatalf("events of one client held up another's: %q %q", order("fast"), order("slow"))
+	}
+
+	for i := 0; i < 100 && order("slow") != "commit\nrelease\n"; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if got := order("slow"); got != "commit\nrelease\n" {
+		t.Fatalf("events of a client ran out of order: %q", got)
+	}
+}
@@ -0,0 +1,96 @@
+package dhcpd
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/pkg/errors"
+)
+
+// Allocation strategies.
+const (
+	// StrategySequential hands out addresses in order, continuing after the
+	// last one handed out. It is the default.
+	StrategySequential = "sequential"
+	// StrategyRandom hands out addresses at random.
+	StrategyRandom = "random"
+	// StrategyHash hands out the address picked by hashing the mac, so a
+	// client gets the same address whenever it is free, even after the
+	// server restarts.
+	StrategyHash = "hash"
+)
+
+// Strategy decides where in the pool the Allocator starts looking for a free
+// address for a mac; it tries each address from there on in turn. Calls are
+// serialized by the Allocator.
+type Strategy interface {
+	// Start returns the address of the pool to try first.
+	Start(mac net.HardwareAddr) net.IP
+	// Allocated is told of each address allocated.
+	Allocated(ip net.IP)
+}
+
+func validateStrategy(name string) error {
+	switch name {
+	case "", StrategySequential, StrategyRandom, StrategyHash:
+		return nil
+	default:
+		return errors.Errorf("invalid allocation strategy %q", name)
+	}
+}
+
+// newStrategy makes the named strategy for the pool. The sequential strategy
+// starts at initial.
+func newStrategy(name string, p *pool, initial net.IP) Strategy {
+	switch name {
+	case StrategyRandom:
+		return &randomStrategy{pool: p, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	case StrategyHash:
+		return &hashStrategy{pool: p}
+	default:
+		lastIP := dhcp4.IPAdd(initial, -1)
+		return &sequentialStrategy{pool: p, lastIP: lastIP, savedIP: lastIP}
+	}
+}
+
+type sequentialStrategy struct {
+	pool   *pool
+	lastIP net.IP
+	// savedIP is the lastIP last recorded in the database.
+	savedIP net.IP
+}
+
+func (s *sequentialStrategy) Start(mac net.HardwareAddr) net.IP {
+	ip, _ := s.pool.next(s.lastIP)
+	return ip
+}
+
+func (s *sequentialStrategy) Allocated(ip net.IP) {
+	s.lastIP = ip
+}
+
+type randomStrategy struct {
+	pool *pool
+	rand *rand.Rand
+}
+
+func (s *randomStrategy) Start(mac net.HardwareAddr) net.IP {
+	return s.pool.at(uint64(s.rand.Int63n(int64(s.pool.size()))))
+}
+
+func (s *randomStrategy) Allocated(ip net.IP) {}
+
+type hashStrategy struct {
+	pool *pool
+}
+
+func (s *hashStrategy) Start(mac net.HardwareAddr) net.IP {
+	h := fnv.New64a()
+	h.Write(mac)
+	return s.pool.at(h.Sum64() % s.pool.size())
+}
+
+func (s *hashStrategy) Allocated(ip net.IP) {}
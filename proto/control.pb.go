@@ -78,6 +78,101 @@ func (x *MACAddress) GetAddress() string {
 	return ""
 }
 
+// ClientID is a client identifier (option 61) as colon separated hex bytes.
+type ClientID struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ID string `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+}
+
+func (x *ClientID) Reset() {
+	*x = ClientID{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClientID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientID) ProtoMessage() {}
+
+func (x *ClientID) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientID.ProtoReflect.Descriptor instead.
+func (*ClientID) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ClientID) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+type IPAddress struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Address string `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+}
+
+func (x *IPAddress) Reset() {
+	*x = IPAddress{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IPAddress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IPAddress) ProtoMessage() {}
+
+func (x *IPAddress) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IPAddress.ProtoReflect.Descriptor instead.
+func (*IPAddress) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *IPAddress) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
 type Lease struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -89,12 +184,27 @@ type Lease struct {
 	Dynamic       bool                 `protobuf:"varint,4,opt,name=Dynamic,proto3" json:"Dynamic,omitempty"` // ignored for SetLease
 	Persistent    bool                 `protobuf:"varint,5,opt,name=Persistent,proto3" json:"Persistent,omitempty"`
 	LeaseGraceEnd *timestamp.Timestamp `protobuf:"bytes,6,opt,name=LeaseGraceEnd,proto3" json:"LeaseGraceEnd,omitempty"`
+	Offered       bool                 `protobuf:"varint,7,opt,name=Offered,proto3" json:"Offered,omitempty"` // ignored for SetLease
+	// relay agent information (option 82); ignored for SetLease
+	CircuitID    []byte `protobuf:"bytes,8,opt,name=CircuitID,proto3" json:"CircuitID,omitempty"`
+	RemoteID     []byte `protobuf:"bytes,9,opt,name=RemoteID,proto3" json:"RemoteID,omitempty"`
+	SubscriberID []byte `protobuf:"bytes,10,opt,name=SubscriberID,proto3" json:"SubscriberID,omitempty"`
+	// the MACAddress may be left empty when setting a lease by ClientID
+	ClientID string `protobuf:"bytes,11,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	// network boot configuration of the host, overriding the server's
+	NextServer   string `protobuf:"bytes,12,opt,name=NextServer,proto3" json:"NextServer,omitempty"`
+	BootFile     string `protobuf:"bytes,13,opt,name=BootFile,proto3" json:"BootFile,omitempty"`
+	IPXEBootFile string `protobuf:"bytes,14,opt,name=IPXEBootFile,proto3" json:"IPXEBootFile,omitempty"`
+	// what the client last told us about itself; ignored for SetLease
+	Hostname    string `protobuf:"bytes,15,opt,name=Hostname,proto3" json:"Hostname,omitempty"`
+	FQDN        string `protobuf:"bytes,16,opt,name=FQDN,proto3" json:"FQDN,omitempty"`
+	VendorClass string `protobuf:"bytes,17,opt,name=VendorClass,proto3" json:"VendorClass,omitempty"`
 }
 
 func (x *Lease) Reset() {
 	*x = Lease{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_control_proto_msgTypes[1]
+		mi := &file_control_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -107,7 +217,378 @@ func (x *Lease) String() string {
 func (*Lease) ProtoMessage() {}
 
 func (x *Lease) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[1]
+	mi := &file_control_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Lease.ProtoReflect.Descriptor instead.
+func (*Lease) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Lease) GetMACAddress() string {
+	if x != nil {
+		return x.MACAddress
+	}
+	return ""
+}
+
+func (x *Lease) GetIPAddress() string {
+	if x != nil {
+		return x.IPAddress
+	}
+	return ""
+}
+
+func (x *Lease) GetLeaseEnd() *timestamp.Timestamp {
+	if x != nil {
+		return x.LeaseEnd
+	}
+	return nil
+}
+
+func (x *Lease) GetDynamic() bool {
+	if x != nil {
+		return x.Dynamic
+	}
+	return false
+}
+
+func (x *Lease) GetPersistent() bool {
+	if x != nil {
+		return x.Persistent
+	}
+	return false
+}
+
+func (x *Lease) GetLeaseGraceEnd() *timestamp.Timestamp {
+	if x != nil {
+		return x.LeaseGraceEnd
+	}
+	return nil
+}
+
+func (x *Lease) GetOffered() bool {
+	if x != nil {
+		return x.Offered
+	}
+	return false
+}
+
+func (x *Lease) GetCircuitID() []byte {
+	if x != nil {
+		return x.CircuitID
+	}
+	return nil
+}
+
+func (x *Lease) GetRemoteID() []byte {
+	if x != nil {
+		return x.RemoteID
+	}
+	return nil
+}
+
+func (x *Lease) GetSubscriberID() []byte {
+	if x != nil {
+		return x.SubscriberID
+	}
+	return nil
+}
+
+func (x *Lease) GetClientID() string {
+	if x != nil {
+		return x.ClientID
+	}
+	return ""
+}
+
+func (x *Lease) GetNextServer() string {
+	if x != nil {
+		return x.NextServer
+	}
+	return ""
+}
+
+func (x *Lease) GetBootFile() string {
+	if x != nil {
+		return x.BootFile
+	}
+	return ""
+}
+
+func (x *Lease) GetIPXEBootFile() string {
+	if x != nil {
+		return x.IPXEBootFile
+	}
+	return ""
+}
+
+func (x *Lease) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Lease) GetFQDN() string {
+	if x != nil {
+		return x.FQDN
+	}
+	return ""
+}
+
+func (x *Lease) GetVendorClass() string {
+	if x != nil {
+		return x.VendorClass
+	}
+	return ""
+}
+
+// LeaseFilter selects the leases listed. An empty filter, which is
+// indistinguishable from google.protobuf.Empty on the wire, lists them all.
+type LeaseFilter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// a shell glob matched against the hostname without regard to case
+	Hostname string `protobuf:"bytes,1,opt,name=Hostname,proto3" json:"Hostname,omitempty"`
+}
+
+func (x *LeaseFilter) Reset() {
+	*x = LeaseFilter{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LeaseFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaseFilter) ProtoMessage() {}
+
+func (x *LeaseFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaseFilter.ProtoReflect.Descriptor instead.
+func (*LeaseFilter) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LeaseFilter) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+type Leases struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	List []*Lease `protobuf:"bytes,1,rep,name=List,proto3" json:"List,omitempty"`
+}
+
+func (x *Leases) Reset() {
+	*x = Leases{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Leases) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Leases) ProtoMessage() {}
+
+func (x *Leases) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Leases.ProtoReflect.Descriptor instead.
+func (*Leases) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Leases) GetList() []*Lease {
+	if x != nil {
+		return x.List
+	}
+	return nil
+}
+
+type QuarantinedAddress struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	IPAddress  string               `protobuf:"bytes,1,opt,name=IPAddress,proto3" json:"IPAddress,omitempty"`
+	MACAddress string               `protobuf:"bytes,2,opt,name=MACAddress,proto3" json:"MACAddress,omitempty"`
+	Until      *timestamp.Timestamp `protobuf:"bytes,3,opt,name=Until,proto3" json:"Until,omitempty"`
+}
+
+func (x *QuarantinedAddress) Reset() {
+	*x = QuarantinedAddress{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QuarantinedAddress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuarantinedAddress) ProtoMessage() {}
+
+func (x *QuarantinedAddress) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuarantinedAddress.ProtoReflect.Descriptor instead.
+func (*QuarantinedAddress) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *QuarantinedAddress) GetIPAddress() string {
+	if x != nil {
+		return x.IPAddress
+	}
+	return ""
+}
+
+func (x *QuarantinedAddress) GetMACAddress() string {
+	if x != nil {
+		return x.MACAddress
+	}
+	return ""
+}
+
+func (x *QuarantinedAddress) GetUntil() *timestamp.Timestamp {
+	if x != nil {
+		return x.Until
+	}
+	return nil
+}
+
+type QuarantinedAddresses struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	List []*QuarantinedAddress `protobuf:"bytes,1,rep,name=List,proto3" json:"List,omitempty"`
+}
+
+func (x *QuarantinedAddresses) Reset() {
+	*x = QuarantinedAddresses{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QuarantinedAddresses) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuarantinedAddresses) ProtoMessage() {}
+
+func (x *QuarantinedAddresses) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuarantinedAddresses.ProtoReflect.Descriptor instead.
+func (*QuarantinedAddresses) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QuarantinedAddresses) GetList() []*QuarantinedAddress {
+	if x != nil {
+		return x.List
+	}
+	return nil
+}
+
+// Cursor is where allocation from a pool of dynamic ranges is.
+type Cursor struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pool     string `protobuf:"bytes,1,opt,name=Pool,proto3" json:"Pool,omitempty"`
+	Strategy string `protobuf:"bytes,2,opt,name=Strategy,proto3" json:"Strategy,omitempty"`
+	// the address last allocated
+	IPAddress string               `protobuf:"bytes,3,opt,name=IPAddress,proto3" json:"IPAddress,omitempty"`
+	Updated   *timestamp.Timestamp `protobuf:"bytes,4,opt,name=Updated,proto3" json:"Updated,omitempty"`
+}
+
+func (x *Cursor) Reset() {
+	*x = Cursor{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Cursor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cursor) ProtoMessage() {}
+
+func (x *Cursor) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -118,78 +599,71 @@ func (x *Lease) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Lease.ProtoReflect.Descriptor instead.
-func (*Lease) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use Cursor.ProtoReflect.Descriptor instead.
+func (*Cursor) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *Lease) GetMACAddress() string {
+func (x *Cursor) GetPool() string {
 	if x != nil {
-		return x.MACAddress
+		return x.Pool
 	}
 	return ""
 }
 
-func (x *Lease) GetIPAddress() string {
+func (x *Cursor) GetStrategy() string {
 	if x != nil {
-		return x.IPAddress
+		return x.Strategy
 	}
 	return ""
 }
 
-func (x *Lease) GetLeaseEnd() *timestamp.Timestamp {
-	if x != nil {
-		return x.LeaseEnd
-	}
-	return nil
-}
-
-func (x *Lease) GetDynamic() bool {
-	if x != nil {
-		return x.Dynamic
-	}
-	return false
-}
-
-func (x *Lease) GetPersistent() bool {
+func (x *Cursor) GetIPAddress() string {
 	if x != nil {
-		return x.Persistent
+		return x.IPAddress
 	}
-	return false
+	return ""
 }
 
-func (x *Lease) GetLeaseGraceEnd() *timestamp.Timestamp {
+func (x *Cursor) GetUpdated() *timestamp.Timestamp {
 	if x != nil {
-		return x.LeaseGraceEnd
+		return x.Updated
 	}
 	return nil
 }
 
-type Leases struct {
+type Stats struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	List []*Lease `protobuf:"bytes,1,rep,name=List,proto3" json:"List,omitempty"`
+	// Leases counts all leases, including offers
+	Leases      int64     `protobuf:"varint,1,opt,name=Leases,proto3" json:"Leases,omitempty"`
+	Offered     int64     `protobuf:"varint,2,opt,name=Offered,proto3" json:"Offered,omitempty"`
+	Persistent  int64     `protobuf:"varint,3,opt,name=Persistent,proto3" json:"Persistent,omitempty"`
+	Configured  int64     `protobuf:"varint,4,opt,name=Configured,proto3" json:"Configured,omitempty"`
+	Quarantined int64     `protobuf:"varint,5,opt,name=Quarantined,proto3" json:"Quarantined,omitempty"`
+	History     int64     `protobuf:"varint,6,opt,name=History,proto3" json:"History,omitempty"`
+	Cursors     []*Cursor `protobuf:"bytes,7,rep,name=Cursors,proto3" json:"Cursors,omitempty"`
 }
 
-func (x *Leases) Reset() {
-	*x = Leases{}
+func (x *Stats) Reset() {
+	*x = Stats{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_control_proto_msgTypes[2]
+		mi := &file_control_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *Leases) String() string {
+func (x *Stats) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Leases) ProtoMessage() {}
+func (*Stats) ProtoMessage() {}
 
-func (x *Leases) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[2]
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -200,14 +674,56 @@ func (x *Leases) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Leases.ProtoReflect.Descriptor instead.
-func (*Leases) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{9}
 }
 
-func (x *Leases) GetList() []*Lease {
+func (x *Stats) GetLeases() int64 {
 	if x != nil {
-		return x.List
+		return x.Leases
+	}
+	return 0
+}
+
+func (x *Stats) GetOffered() int64 {
+	if x != nil {
+		return x.Offered
+	}
+	return 0
+}
+
+func (x *Stats) GetPersistent() int64 {
+	if x != nil {
+		return x.Persistent
+	}
+	return 0
+}
+
+func (x *Stats) GetConfigured() int64 {
+	if x != nil {
+		return x.Configured
+	}
+	return 0
+}
+
+func (x *Stats) GetQuarantined() int64 {
+	if x != nil {
+		return x.Quarantined
+	}
+	return 0
+}
+
+func (x *Stats) GetHistory() int64 {
+	if x != nil {
+		return x.History
+	}
+	return 0
+}
+
+func (x *Stats) GetCursors() []*Cursor {
+	if x != nil {
+		return x.Cursors
 	}
 	return nil
 }
@@ -222,41 +738,124 @@ var file_control_proto_rawDesc = []byte{
 	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x65, 0x6d, 0x70, 0x74, 0x79, 0x2e, 0x70,
 	0x72, 0x6f, 0x74, 0x6f, 0x22, 0x26, 0x0a, 0x0a, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65,
 	0x73, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22, 0xf9, 0x01, 0x0a,
-	0x05, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64,
-	0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4d, 0x41, 0x43, 0x41,
-	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72,
-	0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64,
-	0x72, 0x65, 0x73, 0x73, 0x12, 0x36, 0x0a, 0x08, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x45, 0x6e, 0x64,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
-	0x6d, 0x70, 0x52, 0x08, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x45, 0x6e, 0x64, 0x12, 0x18, 0x0a, 0x07,
-	0x44, 0x79, 0x6e, 0x61, 0x6d, 0x69, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x44,
-	0x79, 0x6e, 0x61, 0x6d, 0x69, 0x63, 0x12, 0x1e, 0x0a, 0x0a, 0x50, 0x65, 0x72, 0x73, 0x69, 0x73,
-	0x74, 0x65, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x50, 0x65, 0x72, 0x73,
-	0x69, 0x73, 0x74, 0x65, 0x6e, 0x74, 0x12, 0x40, 0x0a, 0x0d, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x47,
-	0x72, 0x61, 0x63, 0x65, 0x45, 0x6e, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
-	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
-	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0d, 0x4c, 0x65, 0x61, 0x73, 0x65,
-	0x47, 0x72, 0x61, 0x63, 0x65, 0x45, 0x6e, 0x64, 0x22, 0x2a, 0x0a, 0x06, 0x4c, 0x65, 0x61, 0x73,
-	0x65, 0x73, 0x12, 0x20, 0x0a, 0x04, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
-	0x32, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x04,
-	0x4c, 0x69, 0x73, 0x74, 0x32, 0xe4, 0x01, 0x0a, 0x0c, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x43, 0x6f,
-	0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x32, 0x0a, 0x08, 0x53, 0x65, 0x74, 0x4c, 0x65, 0x61, 0x73,
-	0x65, 0x12, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x1a,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22, 0x1a, 0x0a, 0x08,
+	0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12, 0x0e, 0x0a, 0x02, 0x49, 0x44, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x49, 0x44, 0x22, 0x25, 0x0a, 0x09, 0x49, 0x50, 0x41, 0x64,
+	0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x22,
+	0xbf, 0x04, 0x0a, 0x05, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x4d, 0x41, 0x43,
+	0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x4d,
+	0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x49, 0x50, 0x41,
+	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x49, 0x50,
+	0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x36, 0x0a, 0x08, 0x4c, 0x65, 0x61, 0x73, 0x65,
+	0x45, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x45, 0x6e, 0x64, 0x12,
+	0x18, 0x0a, 0x07, 0x44, 0x79, 0x6e, 0x61, 0x6d, 0x69, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x07, 0x44, 0x79, 0x6e, 0x61, 0x6d, 0x69, 0x63, 0x12, 0x1e, 0x0a, 0x0a, 0x50, 0x65, 0x72,
+	0x73, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x50,
+	0x65, 0x72, 0x73, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x74, 0x12, 0x40, 0x0a, 0x0d, 0x4c, 0x65, 0x61,
+	0x73, 0x65, 0x47, 0x72, 0x61, 0x63, 0x65, 0x45, 0x6e, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0d, 0x4c, 0x65,
+	0x61, 0x73, 0x65, 0x47, 0x72, 0x61, 0x63, 0x65, 0x45, 0x6e, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x4f,
+	0x66, 0x66, 0x65, 0x72, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x4f, 0x66,
+	0x66, 0x65, 0x72, 0x65, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x43, 0x69, 0x72, 0x63, 0x75, 0x69, 0x74,
+	0x49, 0x44, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x43, 0x69, 0x72, 0x63, 0x75, 0x69,
+	0x74, 0x49, 0x44, 0x12, 0x1a, 0x0a, 0x08, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x49, 0x44, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x49, 0x44, 0x12,
+	0x22, 0x0a, 0x0c, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x72, 0x49, 0x44, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65,
+	0x72, 0x49, 0x44, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18,
+	0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12,
+	0x1e, 0x0a, 0x0a, 0x4e, 0x65, 0x78, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x4e, 0x65, 0x78, 0x74, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12,
+	0x1a, 0x0a, 0x08, 0x42, 0x6f, 0x6f, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x42, 0x6f, 0x6f, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x49,
+	0x50, 0x58, 0x45, 0x42, 0x6f, 0x6f, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x0e, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0c, 0x49, 0x50, 0x58, 0x45, 0x42, 0x6f, 0x6f, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x12,
+	0x1a, 0x0a, 0x08, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x46,
+	0x51, 0x44, 0x4e, 0x18, 0x10, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x46, 0x51, 0x44, 0x4e, 0x12,
+	0x20, 0x0a, 0x0b, 0x56, 0x65, 0x6e, 0x64, 0x6f, 0x72, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x11,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x56, 0x65, 0x6e, 0x64, 0x6f, 0x72, 0x43, 0x6c, 0x61, 0x73,
+	0x73, 0x22, 0x29, 0x0a, 0x0b, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72,
+	0x12, 0x1a, 0x0a, 0x08, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x2a, 0x0a, 0x06,
+	0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x12, 0x20, 0x0a, 0x04, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61,
+	0x73, 0x65, 0x52, 0x04, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x84, 0x01, 0x0a, 0x12, 0x51, 0x75, 0x61,
+	0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65, 0x64, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12,
+	0x1c, 0x0a, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1e, 0x0a,
+	0x0a, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x30, 0x0a,
+	0x05, 0x55, 0x6e, 0x74, 0x69, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x55, 0x6e, 0x74, 0x69, 0x6c, 0x22,
+	0x45, 0x0a, 0x14, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65, 0x64, 0x41, 0x64,
+	0x64, 0x72, 0x65, 0x73, 0x73, 0x65, 0x73, 0x12, 0x2d, 0x0a, 0x04, 0x4c, 0x69, 0x73, 0x74, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x51, 0x75,
+	0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65, 0x64, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x52, 0x04, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x8c, 0x01, 0x0a, 0x06, 0x43, 0x75, 0x72, 0x73, 0x6f,
+	0x72, 0x12, 0x12, 0x0a, 0x04, 0x50, 0x6f, 0x6f, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x50, 0x6f, 0x6f, 0x6c, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67,
+	0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x53, 0x74, 0x72, 0x61, 0x74, 0x65, 0x67,
+	0x79, 0x12, 0x1c, 0x0a, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12,
+	0x34, 0x0a, 0x07, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x07, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x64, 0x22, 0xde, 0x01, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12,
+	0x16, 0x0a, 0x06, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x06, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x4f, 0x66, 0x66, 0x65, 0x72,
+	0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x4f, 0x66, 0x66, 0x65, 0x72, 0x65,
+	0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x50, 0x65, 0x72, 0x73, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x50, 0x65, 0x72, 0x73, 0x69, 0x73, 0x74, 0x65, 0x6e,
+	0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x65, 0x64, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x65,
+	0x64, 0x12, 0x20, 0x0a, 0x0b, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65, 0x64,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69,
+	0x6e, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x27, 0x0a,
+	0x07, 0x43, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x43, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x52, 0x07, 0x43,
+	0x75, 0x72, 0x73, 0x6f, 0x72, 0x73, 0x32, 0x98, 0x04, 0x0a, 0x0c, 0x4c, 0x65, 0x61, 0x73, 0x65,
+	0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x32, 0x0a, 0x08, 0x53, 0x65, 0x74, 0x4c, 0x65,
+	0x61, 0x73, 0x65, 0x12, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73,
+	0x65, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x2d, 0x0a, 0x08, 0x47,
+	0x65, 0x74, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e,
+	0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x1a, 0x0c, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x0a, 0x4c, 0x69,
+	0x73, 0x74, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x12, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x1a, 0x0d, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x22, 0x00, 0x12, 0x3a, 0x0a,
+	0x0b, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x11, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x1a,
 	0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
-	0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x2d, 0x0a, 0x08, 0x47, 0x65, 0x74,
-	0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x41,
-	0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x1a, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x22, 0x00, 0x12, 0x35, 0x0a, 0x0a, 0x4c, 0x69, 0x73, 0x74,
-	0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0d,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x73, 0x22, 0x00, 0x12,
-	0x3a, 0x0a, 0x0b, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x11,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4d, 0x41, 0x43, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73,
-	0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x42, 0x09, 0x5a, 0x07, 0x2e,
-	0x3b, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x35, 0x0a, 0x12, 0x47, 0x65, 0x74,
+	0x4c, 0x65, 0x61, 0x73, 0x65, 0x42, 0x79, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12,
+	0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44,
+	0x1a, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x22, 0x00,
+	0x12, 0x42, 0x0a, 0x15, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x42,
+	0x79, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12, 0x0f, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x22, 0x00, 0x12, 0x47, 0x0a, 0x0e, 0x4c, 0x69, 0x73, 0x74, 0x51, 0x75, 0x61, 0x72,
+	0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1b,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e,
+	0x65, 0x64, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x65, 0x73, 0x22, 0x00, 0x12, 0x3e, 0x0a,
+	0x10, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e,
+	0x65, 0x12, 0x10, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x49, 0x50, 0x41, 0x64, 0x64, 0x72,
+	0x65, 0x73, 0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x00, 0x12, 0x32, 0x0a,
+	0x08, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74,
+	0x79, 0x1a, 0x0c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x22,
+	0x00, 0x42, 0x09, 0x5a, 0x07, 0x2e, 0x3b, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -271,31 +870,52 @@ func file_control_proto_rawDescGZIP() []byte {
 	return file_control_proto_rawDescData
 }
 
-var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_control_proto_goTypes = []interface{}{
-	(*MACAddress)(nil),          // 0: proto.MACAddress
-	(*Lease)(nil),               // 1: proto.Lease
-	(*Leases)(nil),              // 2: proto.Leases
-	(*timestamp.Timestamp)(nil), // 3: google.protobuf.Timestamp
-	(*empty.Empty)(nil),         // 4: google.protobuf.Empty
+	(*MACAddress)(nil),           // 0: proto.MACAddress
+	(*ClientID)(nil),             // 1: proto.ClientID
+	(*IPAddress)(nil),            // 2: proto.IPAddress
+	(*Lease)(nil),                // 3: proto.Lease
+	(*LeaseFilter)(nil),          // 4: proto.LeaseFilter
+	(*Leases)(nil),               // 5: proto.Leases
+	(*QuarantinedAddress)(nil),   // 6: proto.QuarantinedAddress
+	(*QuarantinedAddresses)(nil), // 7: proto.QuarantinedAddresses
+	(*Cursor)(nil),               // 8: proto.Cursor
+	(*Stats)(nil),                // 9: proto.Stats
+	(*timestamp.Timestamp)(nil),  // 10: google.protobuf.Timestamp
+	(*empty.Empty)(nil),          // 11: google.protobuf.Empty
 }
 var file_control_proto_depIdxs = []int32{
-	3, // 0: proto.Lease.LeaseEnd:type_name -> google.protobuf.Timestamp
-	3, // 1: proto.Lease.LeaseGraceEnd:type_name -> google.protobuf.Timestamp
-	1, // 2: proto.Leases.List:type_name -> proto.Lease
-	1, // 3: proto.LeaseControl.SetLease:input_type -> proto.Lease
-	0, // 4: proto.LeaseControl.GetLease:input_type -> proto.MACAddress
-	4, // 5: proto.LeaseControl.ListLeases:input_type -> google.protobuf.Empty
-	0, // 6: proto.LeaseControl.RemoveLease:input_type -> proto.MACAddress
-	4, // 7: proto.LeaseControl.SetLease:output_type -> google.protobuf.Empty
-	1, // 8: proto.LeaseControl.GetLease:output_type -> proto.Lease
-	2, // 9: proto.LeaseControl.ListLeases:output_type -> proto.Leases
-	4, // 10: proto.LeaseControl.RemoveLease:output_type -> google.protobuf.Empty
-	7, // [7:11] is the sub-list for method output_type
-	3, // [3:7] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	10, // 0: proto.Lease.LeaseEnd:type_name -> google.protobuf.Timestamp
+	10, // 1: proto.Lease.LeaseGraceEnd:type_name -> google.protobuf.Timestamp
+	3,  // 2: proto.Leases.List:type_name -> proto.Lease
+	10, // 3: proto.QuarantinedAddress.Until:type_name -> google.protobuf.Timestamp
+	6,  // 4: proto.QuarantinedAddresses.List:type_name -> proto.QuarantinedAddress
+	10, // 5: proto.Cursor.Updated:type_name -> google.protobuf.Timestamp
+	8,  // 6: proto.Stats.Cursors:type_name -> proto.Cursor
+	3,  // 7: proto.LeaseControl.SetLease:input_type -> proto.Lease
+	0,  // 8: proto.LeaseControl.GetLease:input_type -> proto.MACAddress
+	4,  // 9: proto.LeaseControl.ListLeases:input_type -> proto.LeaseFilter
+	0,  // 10: proto.LeaseControl.RemoveLease:input_type -> proto.MACAddress
+	1,  // 11: proto.LeaseControl.GetLeaseByClientID:input_type -> proto.ClientID
+	1,  // 12: proto.LeaseControl.RemoveLeaseByClientID:input_type -> proto.ClientID
+	11, // 13: proto.LeaseControl.ListQuarantine:input_type -> google.protobuf.Empty
+	2,  // 14: proto.LeaseControl.RemoveQuarantine:input_type -> proto.IPAddress
+	11, // 15: proto.LeaseControl.GetStats:input_type -> google.protobuf.Empty
+	11, // 16: proto.LeaseControl.SetLease:output_type -> google.protobuf.Empty
+	3,  // 17: proto.LeaseControl.GetLease:output_type -> proto.Lease
+	5,  // 18: proto.LeaseControl.ListLeases:output_type -> proto.Leases
+	11, // 19: proto.LeaseControl.RemoveLease:output_type -> google.protobuf.Empty
+	3,  // 20: proto.LeaseControl.GetLeaseByClientID:output_type -> proto.Lease
+	11, // 21: proto.LeaseControl.RemoveLeaseByClientID:output_type -> google.protobuf.Empty
+	7,  // 22: proto.LeaseControl.ListQuarantine:output_type -> proto.QuarantinedAddresses
+	11, // 23: proto.LeaseControl.RemoveQuarantine:output_type -> google.protobuf.Empty
+	9,  // 24: proto.LeaseControl.GetStats:output_type -> proto.Stats
+	16, // [16:25] is the sub-list for method output_type
+	7,  // [7:16] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_control_proto_init() }
@@ -317,7 +937,7 @@ func file_control_proto_init() {
 			}
 		}
 		file_control_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Lease); i {
+			switch v := v.(*ClientID); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -329,6 +949,42 @@ func file_control_proto_init() {
 			}
 		}
 		file_control_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*IPAddress); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Lease); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LeaseFilter); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Leases); i {
 			case 0:
 				return &v.state
@@ -340,6 +996,54 @@ func file_control_proto_init() {
 				return nil
 			}
 		}
+		file_control_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*QuarantinedAddress); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*QuarantinedAddresses); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Cursor); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Stats); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -347,7 +1051,7 @@ func file_control_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_control_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -375,8 +1079,13 @@ const _ = grpc.SupportPackageIsVersion6
 type LeaseControlClient interface {
 	SetLease(ctx context.Context, in *Lease, opts ...grpc.CallOption) (*empty.Empty, error)
 	GetLease(ctx context.Context, in *MACAddress, opts ...grpc.CallOption) (*Lease, error)
-	ListLeases(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Leases, error)
+	ListLeases(ctx context.Context, in *LeaseFilter, opts ...grpc.CallOption) (*Leases, error)
 	RemoveLease(ctx context.Context, in *MACAddress, opts ...grpc.CallOption) (*empty.Empty, error)
+	GetLeaseByClientID(ctx context.Context, in *ClientID, opts ...grpc.CallOption) (*Lease, error)
+	RemoveLeaseByClientID(ctx context.Context, in *ClientID, opts ...grpc.CallOption) (*empty.Empty, error)
+	ListQuarantine(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*QuarantinedAddresses, error)
+	RemoveQuarantine(ctx context.Context, in *IPAddress, opts ...grpc.CallOption) (*empty.Empty, error)
+	GetStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Stats, error)
 }
 
 type leaseControlClient struct {
@@ -405,7 +1114,7 @@ func (c *leaseControlClient) GetLease(ctx context.Context, in *MACAddress, opts
 	return out, nil
 }
 
-func (c *leaseControlClient) ListLeases(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Leases, error) {
+func (c *leaseControlClient) ListLeases(ctx context.Context, in *LeaseFilter, opts ...grpc.CallOption) (*Leases, error) {
 	out := new(Leases)
 	err := c.cc.Invoke(ctx, "/proto.LeaseControl/ListLeases", in, out, opts...)
 	if err != nil {
@@ -423,12 +1132,62 @@ func (c *leaseControlClient) RemoveLease(ctx context.Context, in *MACAddress, op
 	return out, nil
 }
 
+func (c *leaseControlClient) GetLeaseByClientID(ctx context.Context, in *ClientID, opts ...grpc.CallOption) (*Lease, error) {
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, "/proto.LeaseControl/GetLeaseByClientID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaseControlClient) RemoveLeaseByClientID(ctx context.Context, in *ClientID, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/proto.LeaseControl/RemoveLeaseByClientID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaseControlClient) ListQuarantine(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*QuarantinedAddresses, error) {
+	out := new(QuarantinedAddresses)
+	err := c.cc.Invoke(ctx, "/proto.LeaseControl/ListQuarantine", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaseControlClient) RemoveQuarantine(ctx context.Context, in *IPAddress, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/proto.LeaseControl/RemoveQuarantine", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaseControlClient) GetStats(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, "/proto.LeaseControl/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LeaseControlServer is the server API for LeaseControl service.
 type LeaseControlServer interface {
 	SetLease(context.Context, *Lease) (*empty.Empty, error)
 	GetLease(context.Context, *MACAddress) (*Lease, error)
-	ListLeases(context.Context, *empty.Empty) (*Leases, error)
+	ListLeases(context.Context, *LeaseFilter) (*Leases, error)
 	RemoveLease(context.Context, *MACAddress) (*empty.Empty, error)
+	GetLeaseByClientID(context.Context, *ClientID) (*Lease, error)
+	RemoveLeaseByClientID(context.Context, *ClientID) (*empty.Empty, error)
+	ListQuarantine(context.Context, *empty.Empty) (*QuarantinedAddresses, error)
+	RemoveQuarantine(context.Context, *IPAddress) (*empty.Empty, error)
+	GetStats(context.Context, *empty.Empty) (*Stats, error)
 }
 
 // UnimplementedLeaseControlServer can be embedded to have forward compatible implementations.
@@ -441,12 +1200,27 @@ func (*UnimplementedLeaseControlServer) SetLease(context.Context, *Lease) (*empt
 func (*UnimplementedLeaseControlServer) GetLease(context.Context, *MACAddress) (*Lease, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLease not implemented")
 }
-func (*UnimplementedLeaseControlServer) ListLeases(context.Context, *empty.Empty) (*Leases, error) {
+func (*UnimplementedLeaseControlServer) ListLeases(context.Context, *LeaseFilter) (*Leases, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListLeases not implemented")
 }
 func (*UnimplementedLeaseControlServer) RemoveLease(context.Context, *MACAddress) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveLease not implemented")
 }
+func (*UnimplementedLeaseControlServer) GetLeaseByClientID(context.Context, *ClientID) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLeaseByClientID not implemented")
+}
+func (*UnimplementedLeaseControlServer) RemoveLeaseByClientID(context.Context, *ClientID) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveLeaseByClientID not implemented")
+}
+func (*UnimplementedLeaseControlServer) ListQuarantine(context.Context, *empty.Empty) (*QuarantinedAddresses, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQuarantine not implemented")
+}
+func (*UnimplementedLeaseControlServer) RemoveQuarantine(context.Context, *IPAddress) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveQuarantine not implemented")
+}
+func (*UnimplementedLeaseControlServer) GetStats(context.Context, *empty.Empty) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
 
 func RegisterLeaseControlServer(s *grpc.Server, srv LeaseControlServer) {
 	s.RegisterService(&_LeaseControl_serviceDesc, srv)
@@ -489,7 +1263,7 @@ func _LeaseControl_GetLease_Handler(srv interface{}, ctx context.Context, dec fu
 }
 
 func _LeaseControl_ListLeases_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(empty.Empty)
+	in := new(LeaseFilter)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -501,7 +1275,7 @@ func _LeaseControl_ListLeases_Handler(srv interface{}, ctx context.Context, dec
 		FullMethod: "/proto.LeaseControl/ListLeases",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(LeaseControlServer).ListLeases(ctx, req.(*empty.Empty))
+		return srv.(LeaseControlServer).ListLeases(ctx, req.(*LeaseFilter))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -524,6 +1298,96 @@ func _LeaseControl_RemoveLease_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LeaseControl_GetLeaseByClientID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaseControlServer).GetLeaseByClientID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LeaseControl/GetLeaseByClientID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaseControlServer).GetLeaseByClientID(ctx, req.(*ClientID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LeaseControl_RemoveLeaseByClientID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClientID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaseControlServer).RemoveLeaseByClientID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LeaseControl/RemoveLeaseByClientID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaseControlServer).RemoveLeaseByClientID(ctx, req.(*ClientID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LeaseControl_ListQuarantine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaseControlServer).ListQuarantine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LeaseControl/ListQuarantine",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaseControlServer).ListQuarantine(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LeaseControl_RemoveQuarantine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IPAddress)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaseControlServer).RemoveQuarantine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LeaseControl/RemoveQuarantine",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaseControlServer).RemoveQuarantine(ctx, req.(*IPAddress))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LeaseControl_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaseControlServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.LeaseControl/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaseControlServer).GetStats(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _LeaseControl_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.LeaseControl",
 	HandlerType: (*LeaseControlServer)(nil),
@@ -544,6 +1408,26 @@ var _LeaseControl_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveLease",
 			Handler:    _LeaseControl_RemoveLease_Handler,
 		},
+		{
+			MethodName: "GetLeaseByClientID",
+			Handler:    _LeaseControl_GetLeaseByClientID_Handler,
+		},
+		{
+			MethodName: "RemoveLeaseByClientID",
+			Handler:    _LeaseControl_RemoveLeaseByClientID_Handler,
+		},
+		{
+			MethodName: "ListQuarantine",
+			Handler:    _LeaseControl_ListQuarantine_Handler,
+		},
+		{
+			MethodName: "RemoveQuarantine",
+			Handler:    _LeaseControl_RemoveQuarantine_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _LeaseControl_GetStats_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "control.proto",
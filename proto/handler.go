@@ -34,6 +34,17 @@ func toGRPC(lease *db.Lease) *Lease {
 		IPAddress:     lease.IPAddress,
 		Dynamic:       lease.Dynamic,
 		Persistent:    lease.Persistent,
+		Offered:       lease.Offered,
+		ClientID:      lease.ClientID,
+		CircuitID:     []byte(lease.CircuitID),
+		RemoteID:      []byte(lease.RemoteID),
+		SubscriberID:  []byte(lease.SubscriberID),
+		NextServer:    lease.NextServer,
+		BootFile:      lease.BootFile,
+		IPXEBootFile:  lease.IPXEBootFile,
+		Hostname:      lease.Hostname,
+		FQDN:          lease.FQDN,
+		VendorClass:   lease.VendorClass,
 		LeaseEnd:      &timestamp.Timestamp{Seconds: lease.LeaseEnd.Unix()},
 		LeaseGraceEnd: &timestamp.Timestamp{Seconds: lease.LeaseGraceEnd.Unix()},
 	}
@@ -42,10 +53,20 @@ func toGRPC(lease *db.Lease) *Lease {
 // SetLease creates an explicit lease with the parameters provided. It does not
 // currently have any scoping rules other than it must be a valid lease in the
 // networking sense. Whether or not the lease can be offered is another matter.
+//
+// When a client identifier is provided, the mac address may be omitted; the
+// lease is claimed by the first client presenting the identifier.
 func (h *Handler) SetLease(ctx context.Context, lease *Lease) (*empty.Empty, error) {
-	mac, err := net.ParseMAC(lease.MACAddress)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "mac address is invalid: %v", err)
+	var (
+		mac net.HardwareAddr
+		err error
+	)
+
+	if lease.MACAddress != "" || lease.ClientID == "" {
+		mac, err = net.ParseMAC(lease.MACAddress)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "mac address is invalid: %v", err)
+		}
 	}
 
 	ip := net.ParseIP(lease.IPAddress)
@@ -57,10 +78,34 @@ func (h *Handler) SetLease(ctx context.Context, lease *Lease) (*empty.Empty, err
 		return nil, status.Errorf(codes.InvalidArgument, "lease values are nil")
 	}
 
-	if err := h.db.SetLease(mac, ip.To4(), false, lease.Persistent, time.Unix(lease.LeaseEnd.Seconds, 0), time.Unix(lease.LeaseGraceEnd.Seconds, 0)); err != nil {
+	if lease.NextServer != "" && net.ParseIP(lease.NextServer).To4() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "next server is invalid")
+	}
+
+	end, graceEnd := time.Unix(lease.LeaseEnd.Seconds, 0), time.Unix(lease.LeaseGraceEnd.Seconds, 0)
+
+	c := db.Client{MAC: mac}
+
+	if lease.ClientID != "" {
+		id, err := db.ParseClientID(lease.ClientID)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "client id is invalid: %v", err)
+		}
+
+		c.ID = db.FormatClientID(id)
+	}
+
+	if err := h.db.SetLease(c, ip.To4(), false, lease.Persistent, end, graceEnd); err != nil {
 		return nil, status.Errorf(codes.Aborted, "failed to set lease: %v", err)
 	}
 
+	boot := db.Boot{NextServer: lease.NextServer, BootFile: lease.BootFile, IPXEBootFile: lease.IPXEBootFile}
+	if boot != (db.Boot{}) {
+		if err := h.db.SetBoot(c, boot); err != nil {
+			return nil, status.Errorf(codes.Aborted, "failed to set boot configuration: %v", err)
+		}
+	}
+
 	return &empty.Empty{}, nil
 }
 
@@ -71,7 +116,22 @@ func (h *Handler) GetLease(ctx context.Context, mac *MACAddress) (*Lease, error)
 		return nil, status.Errorf(codes.InvalidArgument, "mac address is invalid: %v", err)
 	}
 
-	lease, err := h.db.GetLease(m)
+	lease, err := h.db.GetLease(db.Client{MAC: m})
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not retrieve lease: %v", err)
+	}
+
+	return toGRPC(lease), nil
+}
+
+// GetLeaseByClientID retreives the lease for the client identifier provided.
+func (h *Handler) GetLeaseByClientID(ctx context.Context, clientID *ClientID) (*Lease, error) {
+	id, err := db.ParseClientID(clientID.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "client id is invalid: %v", err)
+	}
+
+	lease, err := h.db.GetLease(db.Client{ID: db.FormatClientID(id)})
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "could not retrieve lease: %v", err)
 	}
@@ -79,11 +139,21 @@ func (h *Handler) GetLease(ctx context.Context, mac *MACAddress) (*Lease, error)
 	return toGRPC(lease), nil
 }
 
-// ListLeases lists all the leases we know about.
-func (h *Handler) ListLeases(ctx context.Context, empty *empty.Empty) (*Leases, error) {
+// ListLeases lists the leases we know about which match the filter.
+func (h *Handler) ListLeases(ctx context.Context, filter *LeaseFilter) (*Leases, error) {
 	list := []*Lease{}
 
-	leases, err := h.db.ListLeases()
+	var (
+		leases []*db.Lease
+		err    error
+	)
+
+	if filter.Hostname != "" {
+		leases, err = h.db.ListLeasesByHostname(filter.Hostname)
+	} else {
+		leases, err = h.db.ListLeases()
+	}
+
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "could not list leases: %v", err)
 	}
@@ -102,9 +172,92 @@ func (h *Handler) RemoveLease(ctx context.Context, mac *MACAddress) (*empty.Empt
 		return nil, status.Errorf(codes.InvalidArgument, "mac address is invalid: %v", err)
 	}
 
-	if err := h.db.RemoveLease(m); err != nil {
+	if err := h.db.RemoveLease(db.Client{MAC: m}); err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not remove lease: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// RemoveLeaseByClientID removes the lease for a client identifier.
+func (h *Handler) RemoveLeaseByClientID(ctx context.Context, clientID *ClientID) (*empty.Empty, error) {
+	id, err := db.ParseClientID(clientID.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "client id is invalid: %v", err)
+	}
+
+	if err := h.db.RemoveLease(db.Client{ID: db.FormatClientID(id)}); err != nil {
 		return nil, status.Errorf(codes.Aborted, "could not remove lease: %v", err)
 	}
 
 	return &empty.Empty{}, nil
 }
+
+// ListQuarantine lists all quarantined addresses.
+func (h *Handler) ListQuarantine(ctx context.Context, empty *empty.Empty) (*QuarantinedAddresses, error) {
+	list := []*QuarantinedAddress{}
+
+	quarantine, err := h.db.ListQuarantine()
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not list quarantined addresses: %v", err)
+	}
+
+	for _, q := range quarantine {
+		list = append(list, &QuarantinedAddress{
+			IPAddress:  q.IPAddress,
+			MACAddress: q.MACAddress,
+			Until:      &timestamp.Timestamp{Seconds: q.Until.Unix()},
+		})
+	}
+
+	return &QuarantinedAddresses{List: list}, nil
+}
+
+// RemoveQuarantine clears the quarantine on an address, making it available
+// for allocation again.
+func (h *Handler) RemoveQuarantine(ctx context.Context, ip *IPAddress) (*empty.Empty, error) {
+	i := net.ParseIP(ip.Address)
+	if len(i) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ip address is invalid")
+	}
+
+	if err := h.db.RemoveQuarantine(i.To4()); err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not remove quarantine: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetStats returns counts of the leases, quarantined addresses and lease
+// history, and where allocation from each pool of dynamic ranges is.
+func (h *Handler) GetStats(ctx context.Context, empty *empty.Empty) (*Stats, error) {
+	stats, err := h.db.Stats()
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not count leases: %v", err)
+	}
+
+	cursors, err := h.db.ListCursors()
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "could not list allocation cursors: %v", err)
+	}
+
+	list := []*Cursor{}
+	for _, c := range cursors {
+		list = append(list, &Cursor{
+			Pool:      c.Pool,
+			Strategy:  c.Strategy,
+			IPAddress: c.IPAddress,
+			Updated:   &timestamp.Timestamp{Seconds: c.Updated.Unix()},
+		})
+	}
+
+	return &Stats{
+		Leases:      int64(stats.Leases),
+		Offered:     int64(stats.Offered),
+		Persistent:  int64(stats.Persistent),
+		Configured:  int64(stats.Configured),
+		Quarantined: int64(stats.Quarantined),
+		History:     int64(stats.History),
+		Cursors:     list,
+	}, nil
+}
@@ -15,6 +15,12 @@ import (
 	"google.golang.org/grpc"
 )
 
+// byMAC returns the client with the hardware address, for tests where db is
+// the database.
+func byMAC(mac net.HardwareAddr) db.Client {
+	return db.Client{MAC: mac}
+}
+
 var (
 	invalidIPs = []string{
 		"1",
@@ -74,7 +80,7 @@ func TestLeaseHandlerInput(t *testing.T) {
 	client, l, s, db := setupTest(t)
 	defer cleanupTest(t, l, s, db)
 
-	list, err := client.ListLeases(context.Background(), &empty.Empty{})
+	list, err := client.ListLeases(context.Background(), &LeaseFilter{})
 	if err != nil {
 		t.Fatalf("Error reading empty list of leases: %v", err)
 	}
@@ -110,7 +116,7 @@ func TestLeaseHandlerInput(t *testing.T) {
 		}
 	}
 
-	list, err = client.ListLeases(context.Background(), &empty.Empty{})
+	list, err = client.ListLeases(context.Background(), &LeaseFilter{})
 	if err != nil {
 		t.Fatalf("Error reading empty list of leases: %v", err)
 	}
@@ -124,7 +130,7 @@ func TestLeaseHandlerSetGetLease(t *testing.T) {
 	client, l, s, db := setupTest(t)
 	defer cleanupTest(t, l, s, db)
 
-	list, err := client.ListLeases(context.Background(), &empty.Empty{})
+	list, err := client.ListLeases(context.Background(), &LeaseFilter{})
 	if err != nil {
 		t.Fatalf("Error reading empty list of leases: %v", err)
 	}
@@ -184,7 +190,7 @@ func TestLeaseHandlerSetGetLease(t *testing.T) {
 		}
 	}
 
-	list, err = client.ListLeases(context.Background(), &empty.Empty{})
+	list, err = client.ListLeases(context.Background(), &LeaseFilter{})
 	if err != nil {
 		t.Fatalf("error listing leases: %v", err)
 	}
@@ -211,3 +217,190 @@ func TestLeaseHandlerSetGetLease(t *testing.T) {
 		}
 	}
 }
+
+func TestQuarantineHandler(t *testing.T) {
+	client, l, s, db := setupTest(t)
+	defer cleanupTest(t, l, s, db)
+
+	for _, badIP := range invalidIPs {
+		if _, err := client.RemoveQuarantine(context.Background(), &IPAddress{Address: badIP}); err == nil {
+			t.Fatalf("Did not error with invalid input: %v", badIP)
+		}
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := db.QuarantineIP(net.ParseIP("10.0.0.1").To4(), testutil.FakeMAC, until); err != nil {
+		t.Fatalf("Error quarantining ip: %v", err)
+	}
+
+	list, err := client.ListQuarantine(context.Background(), &empty.Empty{})
+	if err != nil {
+		t.Fatalf("Error listing quarantine: %v", err)
+	}
+
+	if len(list.List) != 1 {
+		t.Fatalf("Quarantine list was the wrong size: %d", len(list.List))
+	}
+
+	q := list.List[0]
+	if q.IPAddress != "10.0.0.1" || q.MACAddress != testutil.FakeMAC.String() || q.Until.Seconds != until.Unix() {
+		t.Fatalf("Quarantine entry did not match: %v", q)
+	}
+
+	if _, err := client.RemoveQuarantine(context.Background(), &IPAddress{Address: "10.0.0.1"}); err != nil {
+		t.Fatalf("Error clearing quarantine: %v", err)
+	}
+
+	if _, err := client.RemoveQuarantine(context.Background(), &IPAddress{Address: "10.0.0.1"}); err == nil {
+		t.Fatal("No error clearing missing quarantine")
+	}
+}
+
+func TestLeaseHandlerClientID(t *testing.T) {
+	client, l, s, db := setupTest(t)
+	defer cleanupTest(t, l, s, db)
+
+	leaseEnd := time.Now().Add(time.Minute).Unix()
+
+	for _, badID := range []string{"", "1", "01:zz"} {
+		_, err := client.SetLease(context.Background(), &Lease{
+			ClientID:      badID,
+			IPAddress:     "10.0.0.1",
+			LeaseEnd:      &timestamp.Timestamp{Seconds: leaseEnd},
+			LeaseGraceEnd: &timestamp.Timestamp{Seconds: leaseEnd},
+		})
+		if err == nil {
+			t.Fatalf("Did not error with invalid input: %q", badID)
+		}
+
+		if _, err := client.GetLeaseByClientID(context.Background(), &ClientID{ID: badID}); err == nil {
+			t.Fatalf("Did not error with invalid input: %q", badID)
+		}
+	}
+
+	_, err := client.SetLease(context.Background(), &Lease{
+		ClientID:      "01:ab:cd:ef:01:02:03",
+		IPAddress:     "10.0.0.1",
+		Persistent:    true,
+		NextServer:    "10.0.0.5",
+		BootFile:      "host.kpxe",
+		LeaseEnd:      &timestamp.Timestamp{Seconds: leaseEnd},
+		LeaseGraceEnd: &timestamp.Timestamp{Seconds: leaseEnd},
+	})
+	if err != nil {
+		t.Fatalf("Error setting lease by client id: %v", err)
+	}
+
+	lease, err := client.GetLeaseByClientID(context.Background(), &ClientID{ID: "01:AB:CD:EF:01:02:03"})
+	if err != nil {
+		t.Fatalf("Error getting lease by client id: %v", err)
+	}
+
+	if lease.IPAddress != "10.0.0.1" || lease.ClientID != "01:ab:cd:ef:01:02:03" || !lease.Persistent {
+		t.Fatalf("Lease did not match: %v", lease)
+	}
+
+	if lease.NextServer != "10.0.0.5" || lease.BootFile != "host.kpxe" || lease.IPXEBootFile != "" {
+		t.Fatalf("Boot configuration did not match: %v", lease)
+	}
+
+	if _, err := client.RemoveLeaseByClientID(context.Background(), &ClientID{ID: "01:ab:cd:ef:01:02:03"}); err != nil {
+		t.Fatalf("Error removing lease by client id: %v", err)
+	}
+
+	if _, err := client.RemoveLeaseByClientID(context.Background(), &ClientID{ID: "01:ab:cd:ef:01:02:03"}); err == nil {
+		t.Fatal("No error removing missing lease")
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	client, l, s, db := setupTest(t)
+	defer cleanupTest(t, l, s, db)
+
+	end := time.Now().Add(time.Hour)
+	if err := db.SetLease(byMAC(testutil.FakeMAC), net.ParseIP("10.0.0.1"), true, false, end, end); err != nil {
+		t.Fatalf("Error setting lease: %v", err)
+	}
+
+	if err := db.SetLease(byMAC(testutil.FakeMAC2), net.ParseIP("10.0.0.2"), false, true, end, end); err != nil {
+		t.Fatalf("Error setting lease: %v", err)
+	}
+
+	if err := db.OfferLease(byMAC(testutil.RandomMAC()), net.ParseIP("10.0.0.3"), end); err != nil {
+		t.Fatalf("Error offering lease: %v", err)
+	}
+
+	if err := db.QuarantineIP(net.ParseIP("10.0.0.4").To4(), testutil.FakeMAC, end); err != nil {
+		t.Fatalf("Error quarantining ip: %v", err)
+	}
+
+	if err := db.SetCursor("10.0.0.1-10.0.0.100", "sequential", net.ParseIP("10.0.0.3")); err != nil {
+		t.Fatalf("Error setting cursor: %v", err)
+	}
+
+	stats, err := client.GetStats(context.Background(), &empty.Empty{})
+	if err != nil {
+		t.Fatalf("Error getting stats: %v", err)
+	}
+
+	if stats.Leases != 3 || stats.Offered != 1 || stats.Persistent != 1 || stats.Configured != 0 || stats.Quarantined != 1 || stats.History != 0 {
+		t.Fatalf("Stats did not match: %v", stats)
+	}
+
+	if len(stats.Cursors) != 1 {
+		t.Fatalf("Cursor list was the wrong size: %d", len(stats.Cursors))
+	}
+
+	c := stats.Cursors[0]
+	if c.Pool != "10.0.0.1-10.0.0.100" || c.Strategy != "sequential" || c.IPAddress != "10.0.0.3" {
+		t.Fatalf("Cursor did not match: %v", c)
+	}
+}
+
+func TestLeaseHandlerHostname(t *testing.T) {
+	client, l, s, d := setupTest(t)
+	defer cleanupTest(t, l, s, d)
+
+	end := time.Now().Add(time.Hour)
+	for i, hostname := range []string{"laptop", "printer"} {
+		mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, byte(i)}
+		if err := d.SetLease(byMAC(mac), net.ParseIP(fmt.Sprintf("10.0.0.%d", i+1)), true, false, end, end); err != nil {
+			t.Fatalf("Error setting lease: %v", err)
+		}
+
+		if err := d.SetClientInfo(byMAC(mac), db.ClientInfo{Hostname: hostname, FQDN: hostname + ".example.com", VendorClass: "MSFT 5.0"}); err != nil {
+			t.Fatalf("Error setting client info: %v", err)
+		}
+	}
+
+	list, err := client.ListLeases(context.Background(), &LeaseFilter{Hostname: "LAP*"})
+	if err != nil {
+		t.Fatalf("Error listing leases: %v", err)
+	}
+
+	if len(list.List) != 1 {
+		t.Fatalf("Lease list was the wrong size: %d", len(list.List))
+	}
+
+	lease := list.List[0]
+	if lease.Hostname != "laptop" || lease.FQDN != "laptop.example.com" || lease.VendorClass != "MSFT 5.0" {
+		t.Fatalf("Client info did not match: %v", lease)
+	}
+
+	// clients from before the filter was added send an empty message, and
+	// get all leases
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Error dialing service: %v", err)
+	}
+	defer cc.Close()
+
+	all := &Leases{}
+	if err := cc.Invoke(context.Background(), "/proto.LeaseControl/ListLeases", &empty.Empty{}, all); err != nil {
+		t.Fatalf("Error listing leases with an empty message: %v", err)
+	}
+
+	if len(all.List) != 2 {
+		t.Fatalf("Lease list was the wrong size: %d", len(all.List))
+	}
+}